@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// clientSweepInterval is how often limiters of clients which have gone quiet are discarded.
+const clientSweepInterval = time.Minute
+
+// ClientLimiter rate limits each client IP address separately, so a busy client can't use up the limit of the others.
+type ClientLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewClientLimiter returns a limiter allowing each client limit requests per second, with bursts of up to burst requests.
+func NewClientLimiter(limit rate.Limit, burst int) *ClientLimiter {
+	return &ClientLimiter{
+		limit:     limit,
+		burst:     burst,
+		clients:   make(map[string]*clientLimiter),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow reports whether the client at the IP address may make a request now.
+func (c *ClientLimiter) Allow(ip string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastSweep) >= clientSweepInterval {
+		c.sweep(now)
+	}
+
+	client, ok := c.clients[ip]
+	if !ok {
+		client = &clientLimiter{limiter: rate.NewLimiter(c.limit, c.burst)}
+		c.clients[ip] = client
+	}
+	client.lastSeen = now
+	return client.limiter.AllowN(now, 1)
+}
+
+// sweep discards the limiters of clients which have been quiet for long enough to have their whole burst back,
+// since a new limiter would allow them the same.
+func (c *ClientLimiter) sweep(now time.Time) {
+	c.lastSweep = now
+	if c.limit <= 0 {
+		return
+	}
+
+	refill := time.Duration(float64(c.burst) / float64(c.limit) * float64(time.Second))
+	for ip, client := range c.clients {
+		if now.Sub(client.lastSeen) >= refill {
+			delete(c.clients, ip)
+		}
+	}
+}
@@ -1,26 +1,36 @@
 package middleware
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
 
-	"golang.org/x/time/rate"
-
 	"github.com/jwtly10/simple-site-tracker/api/service"
+	"github.com/jwtly10/simple-site-tracker/api/track"
 	"github.com/jwtly10/simple-site-tracker/utils/logger"
 )
 
 type Middleware struct {
-	service *service.Service
+	service   *service.Service
+	clientIPs *track.ClientIPResolver
 }
 
 func NewMiddleware(svc *service.Service) *Middleware {
 	return &Middleware{
-		service: svc,
+		service:   svc,
+		clientIPs: track.NewClientIPResolver(nil),
 	}
 }
 
+// SetTrustedProxies makes ResolveClientIP believe the forwarding headers of requests from proxies in the given networks.
+func (m *Middleware) SetTrustedProxies(trustedProxies []*net.IPNet) {
+	m.clientIPs = track.NewClientIPResolver(trustedProxies)
+}
+
 func (m *Middleware) HandleMiddleware(next http.HandlerFunc, mws ...func(http.HandlerFunc) http.HandlerFunc) http.HandlerFunc {
 	for _, mw := range mws {
 		next = mw(next)
@@ -28,22 +38,32 @@ func (m *Middleware) HandleMiddleware(next http.HandlerFunc, mws ...func(http.Ha
 	return next
 }
 
+// ResolveClientIP resolves the IP address of the request's client onto its context, for track.ClientIP.
+// It must wrap the other middleware which use the address.
+func (m *Middleware) ResolveClientIP(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := track.ContextWithClientIP(r.Context(), m.clientIPs.Resolve(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
 // LogRequest logs the request.
 func (m *Middleware) LogRequest(next http.HandlerFunc) http.HandlerFunc {
 	l := logger.Get()
 	return func(w http.ResponseWriter, r *http.Request) {
-		l.Info().Msgf("Received request: %s %s", r.Method, r.URL.Path)
+		l.Info().Msgf("Received request: %s %s from %s", r.Method, r.URL.Path, track.ClientIP(r))
 		next.ServeHTTP(w, r)
 	}
 }
 
-// RateLimit limits the number of requests per second.
+// RateLimit limits the number of requests from each client.
 // Limits defined in router config
-func (m *Middleware) RateLimit(next http.HandlerFunc, limiter *rate.Limiter) http.HandlerFunc {
+func (m *Middleware) RateLimit(next http.HandlerFunc, limiter *ClientLimiter) http.HandlerFunc {
 	l := logger.Get()
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !limiter.Allow() {
-			l.Error().Msg("Rate limit exceeded")
+		ip := track.ClientIP(r)
+		if !limiter.Allow(ip) {
+			l.Error().Msgf("Rate limit exceeded for %s", ip)
 			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
 			return
 		}
@@ -68,7 +88,7 @@ func (m *Middleware) CheckForIgnoreHeader(next http.HandlerFunc) http.HandlerFun
 }
 
 // DomainValidation validates the domain and key pair.
-// It returns a 400 status code if the domain and key pair is invalid.
+// It returns a 401 status code if the domain and key pair is invalid.
 func (m *Middleware) DomainValidation(next http.HandlerFunc) http.HandlerFunc {
 	l := logger.Get()
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -80,15 +100,20 @@ func (m *Middleware) DomainValidation(next http.HandlerFunc) http.HandlerFunc {
 
 		l.Info().Msgf("Validating origin: %s", origin)
 
-		siteKey := r.Header.Get("X-Site-Key")
+		siteKey, err := getSiteKey(r)
+		if err != nil {
+			l.Error().Err(err).Msg("Error reading site key")
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
 		if siteKey == "" {
-			http.Error(w, "Missing X-Site-Key header", http.StatusUnauthorized)
+			http.Error(w, "Missing site key", http.StatusUnauthorized)
 			return
 		}
 
 		domain := getDomainFromOrigin(origin)
 
-		if !m.service.ValidateDomainKeyPair(domain, siteKey) {
+		if !m.service.ValidateDomainKeyPair(r.Context(), domain, siteKey) {
 			http.Error(w, "Invalid domain key pair", http.StatusUnauthorized)
 			return
 		}
@@ -96,6 +121,46 @@ func (m *Middleware) DomainValidation(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// maxBodyBytes bounds the body read to find a site key sent in the body.
+const maxBodyBytes = 1 << 20
+
+// getSiteKey returns the site key from the X-Site-Key header, the site_key query parameter,
+// or the site_key field of a JSON body, in that order.
+// navigator.sendBeacon can't set headers, so beacons send the key in the body or query string.
+// A body which is read is replaced, so the handler can still decode it.
+func getSiteKey(r *http.Request) (string, error) {
+	if siteKey := r.Header.Get("X-Site-Key"); siteKey != "" {
+		return siteKey, nil
+	}
+
+	if siteKey := r.URL.Query().Get("site_key"); siteKey != "" {
+		return siteKey, nil
+	}
+
+	if r.Body == nil {
+		return "", nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if len(body) > maxBodyBytes {
+		return "", fmt.Errorf("body is larger than %d bytes", maxBodyBytes)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var payload struct {
+		SiteKey string `json:"site_key"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		// Leave reporting malformed bodies to the handler
+		return "", nil
+	}
+
+	return payload.SiteKey, nil
+}
+
 // getDomainFromOrigin returns the domain from the origin.
 func getDomainFromOrigin(origin string) string {
 	u, err := url.Parse(origin)
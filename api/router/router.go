@@ -17,40 +17,70 @@ type Route struct {
 
 type Routes []Route
 
-func NewRouter(trackHandlers *track.Handlers, middleware *middleware.Middleware) *http.ServeMux {
+func NewRouter(trackHandlers *track.Handlers, mw *middleware.Middleware) *http.ServeMux {
 	router := http.NewServeMux()
 
-	//  Max 50 requests per hour
+	//  Max 50 requests per hour from each client
 	allowedReqPerHour:= 50
 	secondsPerHour := 3600
-	ratePerSecond := allowedReqPerHour / secondsPerHour
+	ratePerSecond := float64(allowedReqPerHour) / float64(secondsPerHour)
 	burst := 50
 
-	limiter := rate.NewLimiter(rate.Limit(ratePerSecond), burst)
+	limiter := middleware.NewClientLimiter(rate.Limit(ratePerSecond), burst)
+
+	// The script sends batches in the background for as long as a page is open, with an engagement
+	// heartbeat every minute on top of queued events, so batches and engagement get a separate, larger
+	// limit of 1200 requests per hour from each client, which an engaged reader or a shared NAT won't exhaust
+	backgroundLimiter := middleware.NewClientLimiter(rate.Limit(1200.0/float64(secondsPerHour)), 200)
 
 	routes := Routes{
-		{Path: "/api/v1/track/utm", Handler: middleware.HandleMiddleware(
-			middleware.RateLimit(trackHandlers.TrackUTMHandler, limiter),
-			middleware.DomainValidation,
-			middleware.LogRequest)},
-		{Path: "/api/v1/track/click", Handler: middleware.HandleMiddleware(
-			middleware.RateLimit(trackHandlers.TrackClickHandler, limiter),
-			middleware.DomainValidation,
-			middleware.LogRequest)},
-		{Path: "/api/v1/track/pageview", Handler: middleware.HandleMiddleware(
-			middleware.RateLimit(trackHandlers.TrackPageViewHandler, limiter),
-			middleware.DomainValidation,
-			middleware.LogRequest)},
-		{Path: "/serve/js/", Handler: middleware.HandleMiddleware(
-			middleware.RateLimit(trackHandlers.ServeTrackJSHandler, limiter),
-			middleware.CheckForIgnoreHeader)},
+		{Path: "/api/v1/track/utm", Handler: mw.HandleMiddleware(
+			mw.RateLimit(trackHandlers.TrackUTMHandler, limiter),
+			mw.DomainValidation,
+			mw.LogRequest)},
+		{Path: "/api/v1/track/click", Handler: mw.HandleMiddleware(
+			mw.RateLimit(trackHandlers.TrackClickHandler, limiter),
+			mw.DomainValidation,
+			mw.LogRequest)},
+		{Path: "/api/v1/track/pageview", Handler: mw.HandleMiddleware(
+			mw.RateLimit(trackHandlers.TrackPageViewHandler, limiter),
+			mw.DomainValidation,
+			mw.LogRequest)},
+		{Path: "/api/v1/track/event", Handler: mw.HandleMiddleware(
+			mw.RateLimit(trackHandlers.TrackEventHandler, limiter),
+			mw.DomainValidation,
+			mw.LogRequest)},
+		{Path: "/api/v1/track/engagement", Handler: mw.HandleMiddleware(
+			mw.RateLimit(trackHandlers.TrackEngagementHandler, backgroundLimiter),
+			mw.DomainValidation,
+			mw.LogRequest)},
+		{Path: "/api/v1/track/scroll", Handler: mw.HandleMiddleware(
+			mw.RateLimit(trackHandlers.TrackScrollHandler, limiter),
+			mw.DomainValidation,
+			mw.LogRequest)},
+		{Path: "/api/v1/track/form", Handler: mw.HandleMiddleware(
+			mw.RateLimit(trackHandlers.TrackFormHandler, limiter),
+			mw.DomainValidation,
+			mw.LogRequest)},
+		{Path: "/api/v1/track/error", Handler: mw.HandleMiddleware(
+			mw.RateLimit(trackHandlers.TrackErrorHandler, limiter),
+			mw.DomainValidation,
+			mw.LogRequest)},
+		{Path: "/api/v1/track/batch", Handler: mw.HandleMiddleware(
+			mw.RateLimit(trackHandlers.TrackBatchHandler, backgroundLimiter),
+			mw.DomainValidation,
+			mw.LogRequest)},
+		{Path: "/serve/js/", Handler: mw.HandleMiddleware(
+			mw.RateLimit(trackHandlers.ServeTrackJSHandler, limiter),
+			mw.CheckForIgnoreHeader)},
 	}
 
 	origins := os.Getenv("ALLOWED_ORIGINS")
 	allowedOrigins := strings.Split(origins, ",")
 
 	for _, route := range routes {
-		corsHandler := handleCORS(allowedOrigins, route.Handler)
+		// Resolve the client's IP address before the route's middleware, for the rate limiter, logging and tracking handlers
+		corsHandler := handleCORS(allowedOrigins, mw.ResolveClientIP(route.Handler))
 		router.HandleFunc(route.Path, corsHandler)
 	}
 
@@ -1,29 +1,55 @@
 package service
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+
 	"github.com/jwtly10/simple-site-tracker/api/track"
 	"github.com/jwtly10/simple-site-tracker/utils/logger"
 )
 
 type Service struct {
-	repo track.Repository
+	repo track.RepositoryInterface
+
+	// knownKeyPairs holds the last key pair read for each domain, so sites can
+	// still be validated while the database is unavailable and events spooled.
+	mu            sync.RWMutex
+	knownKeyPairs map[string]track.DomainKeyPair
 }
 
-func NewService(repo *track.Repository) *Service {
+func NewService(repo track.RepositoryInterface) *Service {
 	return &Service{
-		repo: *repo,
+		repo:          repo,
+		knownKeyPairs: make(map[string]track.DomainKeyPair),
 	}
 }
 
 // ValidateDomainKeyPair validates the domain and key pair.
 // It returns true if the domain and key pair is valid.
-func (s *Service) ValidateDomainKeyPair(domain string, siteKey string) bool {
+func (s *Service) ValidateDomainKeyPair(ctx context.Context, domain string, siteKey string) bool {
 	l := logger.Get()
 
-	keyPair, err := s.repo.GetDomainKeyPair(domain)
-	if err != nil {
+	keyPair, err := s.repo.GetDomainKeyPair(ctx, domain)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		s.mu.RLock()
+		known, ok := s.knownKeyPairs[domain]
+		s.mu.RUnlock()
+		if !ok {
+			l.Error().Err(err).Msg("Error getting domain key pair")
+			return false
+		}
+
+		l.Warn().Err(err).Msg("Error getting domain key pair, using last known key pair")
+		keyPair = known
+	} else if err != nil {
 		l.Error().Err(err).Msg("Error getting domain key pair")
 		return false
+	} else {
+		s.mu.Lock()
+		s.knownKeyPairs[domain] = keyPair
+		s.mu.Unlock()
 	}
 
 	l.Info().Msgf("Validating domain key pair: %s %s", keyPair.Domain, keyPair.SiteKey)
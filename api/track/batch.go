@@ -0,0 +1,238 @@
+package track
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jwtly10/simple-site-tracker/utils/logger"
+)
+
+// MaxBatchEvents is the most events a batch request can hold.
+const MaxBatchEvents = 100
+
+// Batch event statuses, reported for each event in the order they were sent.
+const (
+	BatchStatusSaved    = "saved"
+	BatchStatusQueued   = "queued"
+	BatchStatusSpooled  = "spooled"
+	BatchStatusInvalid  = "invalid"
+	BatchStatusRejected = "rejected"
+	BatchStatusFailed   = "failed"
+	BatchStatusDropped  = "dropped"
+)
+
+// TrackBatchEvent is one event of a batch. The fields used depend on its type,
+// matching the single event requests.
+type TrackBatchEvent struct {
+	Type EventType `json:"type"`
+	URL  string    `json:"url"`
+
+	Referrer string `json:"referrer,omitempty"`
+	ViewID   string `json:"view_id,omitempty"`
+
+	Element map[string]interface{} `json:"element,omitempty"`
+
+	UTMSource   string `json:"utm_source,omitempty"`
+	UTMMedium   string `json:"utm_medium,omitempty"`
+	UTMCampaign string `json:"utm_campaign,omitempty"`
+	Track       string `json:"track,omitempty"`
+
+	Name  string                 `json:"name,omitempty"`
+	Props map[string]interface{} `json:"props,omitempty"`
+
+	EngagedSeconds int `json:"engaged_seconds,omitempty"`
+	ScrollDepth    int `json:"scroll_depth,omitempty"`
+
+	FormID     string `json:"form_id,omitempty"`
+	FormName   string `json:"form_name,omitempty"`
+	FormAction string `json:"form_action,omitempty"`
+	FieldCount int    `json:"field_count,omitempty"`
+
+	Kind    string `json:"kind,omitempty"`
+	Message string `json:"message,omitempty"`
+	Source  string `json:"source,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Stack   string `json:"stack,omitempty"`
+}
+
+type TrackBatchRequest struct {
+	Events []TrackBatchEvent `json:"events"`
+}
+
+// BatchEventResult is the outcome of one event of a batch.
+type BatchEventResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type TrackBatchResponse struct {
+	Results []BatchEventResult `json:"results"`
+}
+
+// toEvent validates the batch event and converts it to an event for the domain,
+// classifying the referrer of page views with the rules and the target of clicks with the classifier.
+func (b TrackBatchEvent) toEvent(domain string, createdAt time.Time, referrers *ReferrerRules, clicks *ClickClassifier) (Event, error) {
+	event := Event{
+		Type:      b.Type,
+		Domain:    domain,
+		Page:      getPageFromURL(b.URL),
+		CreatedAt: createdAt,
+	}
+
+	switch b.Type {
+	case EventPageView:
+		if err := ValidateViewID(b.ViewID); err != nil {
+			return Event{}, err
+		}
+		event.Referrer = referrers.Classify(b.Referrer, domain)
+		event.ViewID = b.ViewID
+	case EventClick:
+		event.Element = b.Element
+		event.ClickTarget = clicks.Classify(b.Element, domain)
+	case EventUTM:
+		event.UTMSource = b.UTMSource
+		event.UTMMedium = b.UTMMedium
+		event.UTMCampaign = b.UTMCampaign
+		event.Track = b.Track
+	case EventCustom:
+		if err := ValidateCustomEvent(b.Name, b.Props); err != nil {
+			return Event{}, err
+		}
+		event.Name = b.Name
+		event.Props = b.Props
+	case EventEngagement:
+		if err := ValidateEngagement(b.ViewID, b.EngagedSeconds); err != nil {
+			return Event{}, err
+		}
+		event.ViewID = b.ViewID
+		event.EngagedSeconds = engagedSeconds(b.EngagedSeconds)
+	case EventScroll:
+		if err := ValidateScrollDepth(b.ViewID, b.ScrollDepth); err != nil {
+			return Event{}, err
+		}
+		event.ViewID = b.ViewID
+		event.ScrollDepth = b.ScrollDepth
+	case EventForm:
+		event.Form = Form{FormID: b.FormID, FormName: b.FormName, FormAction: urlWithoutQuery(b.FormAction, MaxFormAttrLength), FieldCount: b.FieldCount}
+		if err := ValidateForm(event.Form); err != nil {
+			return Event{}, err
+		}
+	case EventError:
+		jsError, err := NewJSError(b.Kind, b.Message, b.Source, b.Line, b.Column, b.Stack)
+		if err != nil {
+			return Event{}, err
+		}
+		event.JSError = jsError
+	default:
+		return Event{}, fmt.Errorf("%w %q", ErrUnknownEventType, b.Type)
+	}
+
+	return event, nil
+}
+
+// TrackBatchHandler handles tracking a batch of page view, click, UTM, custom, engagement, scroll, form and error events in one request.
+// The site is validated once for the whole batch, and the valid events are queued or saved together.
+// It returns the result of each event, in the order they were sent.
+func (h *Handlers) TrackBatchHandler(w http.ResponseWriter, r *http.Request) {
+	l := logger.Get()
+	if r.Method != http.MethodPost {
+		l.Warn().Msgf("Invalid method: %s", r.Method)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var batch TrackBatchRequest
+	if !decodeRequest(w, r, &batch) {
+		return
+	}
+
+	if len(batch.Events) == 0 || len(batch.Events) > MaxBatchEvents {
+		l.Error().Msgf("Invalid batch of %d events", len(batch.Events))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		l.Error().Msg("Missing Origin header")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	l.Info().Msgf("Tracking batch of %d events", len(batch.Events))
+
+	domain := getDomainFromOrigin(origin)
+	now := time.Now()
+	ip := ClientIP(r)
+	visitor := h.identify(r, ip, domain, now)
+
+	results := make([]BatchEventResult, len(batch.Events))
+	var events []Event
+	var indexes []int
+	for i, item := range batch.Events {
+		event, err := item.toEvent(domain, now, h.referrers, h.clicks)
+		if err != nil {
+			results[i] = BatchEventResult{Status: BatchStatusInvalid, Error: err.Error()}
+			continue
+		}
+		event.Visitor = visitor
+		event.ClientIP = ip
+		events = append(events, event)
+		indexes = append(indexes, i)
+	}
+
+	status := http.StatusOK
+	if h.writer != nil {
+		status = http.StatusAccepted
+		for j, event := range events {
+			if err := h.writer.Enqueue(event); err != nil {
+				l.Error().Err(err).Msgf("Error queueing %s event", event.Type)
+				if errors.Is(err, ErrQueueFull) {
+					w.Header().Set("Retry-After", "1")
+				}
+				results[indexes[j]] = BatchEventResult{Status: BatchStatusRejected, Error: err.Error()}
+				continue
+			}
+			results[indexes[j]] = BatchEventResult{Status: BatchStatusQueued}
+		}
+	} else if len(events) > 0 {
+		var retry []Event
+		var retryIndexes []int
+		for j, err := range writeEvents(r.Context(), h.repo, events) {
+			switch {
+			case err == nil:
+				results[indexes[j]] = BatchEventResult{Status: BatchStatusSaved}
+			case errors.Is(err, ErrUnknownDomain):
+				results[indexes[j]] = BatchEventResult{Status: BatchStatusInvalid, Error: err.Error()}
+			case errors.Is(err, ErrBotDropped):
+				results[indexes[j]] = BatchEventResult{Status: BatchStatusDropped}
+			case !isTransient(err):
+				results[indexes[j]] = BatchEventResult{Status: BatchStatusFailed}
+			default:
+				retry = append(retry, events[j])
+				retryIndexes = append(retryIndexes, indexes[j])
+			}
+		}
+
+		retryStatus := BatchStatusFailed
+		if len(retry) > 0 && h.spooler != nil {
+			if err := h.spooler.Spool(retry); err != nil {
+				l.Error().Err(err).Msgf("Error spooling %d events", len(retry))
+			} else {
+				l.Warn().Msgf("Spooled %d events for replay", len(retry))
+				retryStatus = BatchStatusSpooled
+			}
+		}
+		for _, i := range retryIndexes {
+			results[i] = BatchEventResult{Status: retryStatus}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(TrackBatchResponse{Results: results})
+}
@@ -0,0 +1,115 @@
+package track
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mssola/useragent"
+)
+
+// Bot modes, for how a site handles events from bots.
+// Dropped events aren't stored, and flagged events are stored with is_bot set and left out of the stats.
+const (
+	BotModeDrop = "drop"
+	BotModeFlag = "flag"
+)
+
+// Reasons a request is detected as coming from a bot.
+const (
+	BotReasonUserAgent = "user_agent"
+	BotReasonHeadless  = "headless"
+	BotReasonPrefetch  = "prefetch"
+)
+
+// botTokens are lowercase substrings of the user agents of crawlers, uptime monitors, link previewers and HTTP clients.
+// A bare "bot" would match phones such as the Cubot, so only its product token forms are matched.
+var botTokens = []string{
+	"bot/", "bot-", "bot;", "bot)", "+http", "crawl", "spider", "slurp", "archiver", "scraper",
+	"curl/", "wget/", "python-requests", "python-urllib", "aiohttp", "go-http-client", "java/", "okhttp",
+	"axios/", "node-fetch", "libwww-perl", "httpclient", "postmanruntime", "insomnia",
+	"uptimerobot", "pingdom", "statuscake", "site24x7", "better uptime", "checkly", "datadog synthetic",
+	"newrelicsynthetics", "chrome-lighthouse", "lighthouse", "pagespeed", "gtmetrix",
+	"facebookexternalhit", "embedly", "whatsapp", "skypeuripreview", "feedfetcher",
+}
+
+// headlessTokens are lowercase substrings of the user agents of headless and automated browsers.
+var headlessTokens = []string{"headlesschrome", "phantomjs", "slimerjs", "puppeteer", "playwright", "selenium", "webdriver"}
+
+// DetectBot returns why a request looks like it came from a bot, crawler, headless browser or prefetch,
+// or an empty string if it looks like it came from a person.
+func DetectBot(r *http.Request) string {
+	// Browsers mark speculative loads, which the visitor may never see
+	if strings.Contains(r.Header.Get("Sec-Purpose"), "prefetch") ||
+		strings.EqualFold(r.Header.Get("Purpose"), "prefetch") ||
+		strings.EqualFold(r.Header.Get("X-Moz"), "prefetch") {
+		return BotReasonPrefetch
+	}
+
+	header := r.UserAgent()
+	lower := strings.ToLower(header)
+	for _, token := range headlessTokens {
+		if strings.Contains(lower, token) {
+			return BotReasonHeadless
+		}
+	}
+	if strings.Contains(r.Header.Get("Sec-CH-UA"), "HeadlessChrome") {
+		return BotReasonHeadless
+	}
+
+	if useragent.New(header).Bot() {
+		return BotReasonUserAgent
+	}
+	for _, token := range botTokens {
+		if strings.Contains(lower, token) {
+			return BotReasonUserAgent
+		}
+	}
+
+	return ""
+}
+
+// botMode returns the bot mode of a site's bot_mode column, where unset drops bot events.
+func botMode(mode string) string {
+	if mode == "" {
+		return BotModeDrop
+	}
+	return mode
+}
+
+func validateBotMode(mode string) error {
+	if mode != BotModeDrop && mode != BotModeFlag {
+		return fmt.Errorf("unknown bot mode %q, expected %s or %s", mode, BotModeDrop, BotModeFlag)
+	}
+	return nil
+}
+
+// queryBotMode reads the bot mode of a domain, returning sql.ErrNoRows for unknown domains.
+func queryBotMode(ctx context.Context, db *sql.DB, d dialect, domainID int) (string, error) {
+	var mode sql.NullString
+	if err := db.QueryRowContext(ctx, d.rebind("SELECT bot_mode FROM domains_tb WHERE id = ?"), domainID).Scan(&mode); err != nil {
+		return "", err
+	}
+	return botMode(mode.String), nil
+}
+
+// updateBotMode sets the bot mode of a domain, returning sql.ErrNoRows for unknown domains.
+func updateBotMode(ctx context.Context, db *sql.DB, d dialect, domainID int, mode string) error {
+	if err := validateBotMode(mode); err != nil {
+		return err
+	}
+
+	result, err := db.ExecContext(ctx, d.rebind("UPDATE domains_tb SET bot_mode = ? WHERE id = ?"), mode, domainID)
+	if err != nil {
+		return err
+	}
+
+	// MySQL doesn't count rows which already had the mode as affected, so check the domain exists
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		_, err = queryBotMode(ctx, db, d, domainID)
+		return err
+	}
+	return nil
+}
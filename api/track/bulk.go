@@ -0,0 +1,193 @@
+package track
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// Most placeholders a single statement can have: SQLite's default SQLITE_MAX_VARIABLE_NUMBER,
+// and the 16 bit parameter count of the MySQL and Postgres protocols.
+const (
+	maxSQLitePlaceholders = 32766
+	maxPlaceholders       = 65535
+)
+
+// maxPlaceholders returns the most placeholders a single statement can have.
+func (d dialect) maxPlaceholders() int {
+	if d == dialectSQLite {
+		return maxSQLitePlaceholders
+	}
+	return maxPlaceholders
+}
+
+// inChunks calls fn with consecutive chunks of rows, each small enough to insert in a single statement
+// with columns placeholders per row, stopping at the first error.
+func inChunks[T any](d dialect, rows []T, columns int, fn func(chunk []T) error) error {
+	size := d.maxPlaceholders() / columns
+	for len(rows) > 0 {
+		n := min(size, len(rows))
+		if err := fn(rows[:n]); err != nil {
+			return err
+		}
+		rows = rows[n:]
+	}
+	return nil
+}
+
+// bulkInsert calls insert with chunks of rows in a single transaction, each chunk small enough
+// to insert in one statement with columns placeholders per row.
+func bulkInsert[T any](ctx context.Context, db *sql.DB, d dialect, rows []T, columns int, insert func(tx *sql.Tx, chunk []T) error) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	return withTx(ctx, db, func(tx *sql.Tx) error {
+		return inChunks(d, rows, columns, func(chunk []T) error {
+			return insert(tx, chunk)
+		})
+	})
+}
+
+// bulkInsertQuery builds a multi-row INSERT statement for the given columns.
+// values holds the SQL expression for each column with ? marking the placeholder,
+// and defaults to a plain placeholder when nil. If numbered is set, placeholders
+// are written as $1, $2, ... for Postgres.
+func bulkInsertQuery(table string, columns, values []string, rows int, numbered bool) string {
+	if values == nil {
+		values = placeholders(len(columns))
+	}
+
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(table)
+	sb.WriteString(" (")
+	sb.WriteString(strings.Join(columns, ", "))
+	sb.WriteString(") VALUES ")
+
+	n := 1
+	for row := 0; row < rows; row++ {
+		if row > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString("(")
+		for i, value := range values {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			if numbered {
+				value = strings.Replace(value, "?", "$"+strconv.Itoa(n), 1)
+			}
+			sb.WriteString(value)
+			n++
+		}
+		sb.WriteString(")")
+	}
+
+	return sb.String()
+}
+
+// visitorColumns are the columns every event table stores its visitor in, in the order of visitorArgs.
+var visitorColumns = []string{"visitor_id", "session_id", "browser", "browser_version", "os", "device", "country", "region", "city", "is_bot", "ip_address_id"}
+
+// visitorArgs returns the values of visitorColumns for a visitor, storing NULL for anything unknown.
+func visitorArgs(v Visitor) []interface{} {
+	return []interface{}{
+		nullString(v.VisitorID), nullString(v.SessionID),
+		nullString(v.Browser), nullString(v.BrowserVersion), nullString(v.OS), nullString(v.Device),
+		nullString(v.Country), nullString(v.Region), nullString(v.City),
+		v.Bot, sql.NullInt64{Int64: int64(v.IPAddressID), Valid: v.IPAddressID != 0},
+	}
+}
+
+// placeholders returns n plain placeholders, for bulkInsertQuery values.
+func placeholders(n int) []string {
+	values := make([]string, n)
+	for i := range values {
+		values[i] = "?"
+	}
+	return values
+}
+
+var pageViewColumns = append(append([]string{"domain_id", "page_id", "created_at"}, visitorColumns...), "referrer_host", "referrer_path", "referrer_channel", "view_id")
+
+// pageViewsQuery builds a multi-row INSERT of the page views into page_views_tb, returning its arguments.
+func pageViewsQuery(d dialect, pageViews []PageView) (string, []interface{}) {
+	args := make([]interface{}, 0, len(pageViews)*len(pageViewColumns))
+	for _, pv := range pageViews {
+		args = append(args, pv.DomainID, pv.PageID, d.timeArg(pv.CreatedAt))
+		args = append(args, visitorArgs(pv.Visitor)...)
+		args = append(args, nullString(pv.ReferrerHost), nullString(pv.ReferrerPath), nullString(pv.ReferrerChannel), nullString(pv.ViewID))
+	}
+
+	return bulkInsertQuery("page_views_tb", pageViewColumns, nil, len(pageViews), d == dialectPostgres), args
+}
+
+var clickColumns = append(append([]string{"page_id", "element", "created_at"}, visitorColumns...), "click_class", "target_host", "target_path")
+
+// clicksQuery builds a multi-row INSERT of the clicks into clicks_tb, returning its arguments.
+func clicksQuery(d dialect, clicks []Click) (string, []interface{}, error) {
+	values := append([]string{"?", d.jsonArg(), "?"}, placeholders(len(visitorColumns)+3)...)
+
+	args := make([]interface{}, 0, len(clicks)*len(clickColumns))
+	for _, c := range clicks {
+		elementJSON, err := json.Marshal(c.Element)
+		if err != nil {
+			return "", nil, err
+		}
+		args = append(args, c.PageID, string(elementJSON), d.timeArg(c.CreatedAt))
+		args = append(args, visitorArgs(c.Visitor)...)
+		args = append(args, nullString(c.ClickClass), nullString(c.TargetHost), nullString(c.TargetPath))
+	}
+
+	return bulkInsertQuery("clicks_tb", clickColumns, values, len(clicks), d == dialectPostgres), args, nil
+}
+
+var utmColumns = append([]string{"page_id", "utm_source", "utm_medium", "utm_campaign", "track", "created_at"}, visitorColumns...)
+
+// utmsQuery builds a multi-row INSERT of the UTMs into utm_tb, returning its arguments.
+func utmsQuery(d dialect, utms []UTM) (string, []interface{}) {
+	args := make([]interface{}, 0, len(utms)*len(utmColumns))
+	for _, u := range utms {
+		args = append(args, u.PageID, u.UTMSource, u.UTMMedium, u.UTMCampaign, u.Track, d.timeArg(u.CreatedAt))
+		args = append(args, visitorArgs(u.Visitor)...)
+	}
+
+	return bulkInsertQuery("utm_tb", utmColumns, nil, len(utms), d == dialectPostgres), args
+}
+
+// savePageViews saves the page views to page_views_tb and adds them to the rollups in a single transaction.
+func savePageViews(ctx context.Context, db *sql.DB, d dialect, pageViews []PageView) error {
+	return bulkInsert(ctx, db, d, pageViews, len(pageViewColumns), func(tx *sql.Tx, pageViews []PageView) error {
+		query, args := pageViewsQuery(d, pageViews)
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+		return writePageViewRollups(ctx, tx, d, pageViews)
+	})
+}
+
+// saveClicks saves the clicks to clicks_tb in a single transaction.
+func saveClicks(ctx context.Context, db *sql.DB, d dialect, clicks []Click) error {
+	return bulkInsert(ctx, db, d, clicks, len(clickColumns), func(tx *sql.Tx, clicks []Click) error {
+		query, args, err := clicksQuery(d, clicks)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, query, args...)
+		return err
+	})
+}
+
+// saveUTMs saves the UTMs to utm_tb and adds them to the rollup in a single transaction.
+func saveUTMs(ctx context.Context, db *sql.DB, d dialect, utms []UTM) error {
+	return bulkInsert(ctx, db, d, utms, len(utmColumns), func(tx *sql.Tx, utms []UTM) error {
+		query, args := utmsQuery(d, utms)
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+		return writeUTMRollups(ctx, tx, d, utms)
+	})
+}
@@ -0,0 +1,182 @@
+package track
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// CacheStats is a snapshot of the CachedRepository counters.
+type CacheStats struct {
+	Domains     int   `json:"domains"`
+	Pages       int   `json:"pages"`
+	IPAddresses int   `json:"ip_addresses"`
+	Hits        int64 `json:"hits"`
+	Misses      int64 `json:"misses"`
+}
+
+// DefaultSettingsTTL is how long a domain's bot mode and IP policy are cached, unless configured otherwise.
+const DefaultSettingsTTL = time.Minute
+
+// cachedSetting is a domain setting, cached until it expires.
+type cachedSetting[T any] struct {
+	value   T
+	expires time.Time
+}
+
+// CachedRepository wraps a RepositoryInterface with an in-process LRU cache of domain and page IDs,
+// so resolving the domain and page of an event doesn't hit the database on every request.
+// Domains and pages are never deleted or renumbered, so cached IDs don't go stale.
+// Unknown domains are not cached, so a newly registered domain is picked up on its first event.
+// Stored IP addresses are cached by their anonymised form, which is never renumbered either.
+// A domain's bot mode and IP policy can change, so are only cached for the settings TTL.
+type CachedRepository struct {
+	RepositoryInterface
+
+	domains     *lruCache[string, int]
+	pages       *lruCache[pageKey, int]
+	ipAddresses *lruCache[string, int64]
+	botModes    *lruCache[int, cachedSetting[string]]
+	ipPolicies  *lruCache[int, cachedSetting[IPPolicy]]
+	settingsTTL time.Duration
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// NewCachedRepository caches up to size domain IDs, page IDs and IP address IDs from the repo,
+// and the settings of up to size domains.
+func NewCachedRepository(repo RepositoryInterface, size int) *CachedRepository {
+	if size <= 0 {
+		size = 10000
+	}
+
+	return &CachedRepository{
+		RepositoryInterface: repo,
+		domains:             newLRUCache[string, int](size),
+		pages:               newLRUCache[pageKey, int](size),
+		ipAddresses:         newLRUCache[string, int64](size),
+		botModes:            newLRUCache[int, cachedSetting[string]](size),
+		ipPolicies:          newLRUCache[int, cachedSetting[IPPolicy]](size),
+		settingsTTL:         DefaultSettingsTTL,
+	}
+}
+
+// SetSettingsTTL sets how long a domain's bot mode and IP policy are cached, so changes made by
+// another process, such as `bots set`, reach the cache within the TTL. 0 disables caching them.
+func (repo *CachedRepository) SetSettingsTTL(ttl time.Duration) {
+	repo.settingsTTL = ttl
+}
+
+// GetDomain returns the ID of the domain, from the cache if it has been looked up before.
+func (repo *CachedRepository) GetDomain(ctx context.Context, domain string) (int, error) {
+	if id, ok := repo.domains.Get(domain); ok {
+		repo.hits.Add(1)
+		return id, nil
+	}
+	repo.misses.Add(1)
+
+	id, err := repo.RepositoryInterface.GetDomain(ctx, domain)
+	if err == nil && id != 0 {
+		repo.domains.Add(domain, id)
+	}
+
+	return id, err
+}
+
+// GetOrCreatePage returns the ID of the page, from the cache if it has been resolved before.
+func (repo *CachedRepository) GetOrCreatePage(ctx context.Context, domainID int, pageURL string) (int, error) {
+	key := pageKey{domainId: domainID, page: pageURL}
+	if id, ok := repo.pages.Get(key); ok {
+		repo.hits.Add(1)
+		return id, nil
+	}
+	repo.misses.Add(1)
+
+	id, err := repo.RepositoryInterface.GetOrCreatePage(ctx, domainID, pageURL)
+	if err == nil && id != 0 {
+		repo.pages.Add(key, id)
+	}
+
+	return id, err
+}
+
+// SaveIPAddress saves the IP address, or returns its ID from the cache if it has been saved before.
+func (repo *CachedRepository) SaveIPAddress(ctx context.Context, ipAddress string) (int64, error) {
+	if id, ok := repo.ipAddresses.Get(ipAddress); ok {
+		repo.hits.Add(1)
+		return id, nil
+	}
+	repo.misses.Add(1)
+
+	id, err := repo.RepositoryInterface.SaveIPAddress(ctx, ipAddress)
+	if err == nil && id != 0 {
+		repo.ipAddresses.Add(ipAddress, id)
+	}
+
+	return id, err
+}
+
+// GetBotMode returns how the domain handles events from bots, from the cache if it was looked up within the settings TTL.
+func (repo *CachedRepository) GetBotMode(ctx context.Context, domainID int) (string, error) {
+	if mode, ok := getCachedSetting(repo, repo.botModes, domainID); ok {
+		return mode, nil
+	}
+
+	mode, err := repo.RepositoryInterface.GetBotMode(ctx, domainID)
+	if err == nil {
+		repo.botModes.Add(domainID, cachedSetting[string]{value: mode, expires: time.Now().Add(repo.settingsTTL)})
+	}
+
+	return mode, err
+}
+
+// SetBotMode sets the domain's bot mode, dropping the cached one.
+func (repo *CachedRepository) SetBotMode(ctx context.Context, domainID int, mode string) error {
+	defer repo.botModes.Remove(domainID)
+	return repo.RepositoryInterface.SetBotMode(ctx, domainID, mode)
+}
+
+// GetIPPolicy returns how the domain stores IP addresses, from the cache if it was looked up within the settings TTL.
+func (repo *CachedRepository) GetIPPolicy(ctx context.Context, domainID int) (IPPolicy, error) {
+	if policy, ok := getCachedSetting(repo, repo.ipPolicies, domainID); ok {
+		return policy, nil
+	}
+
+	policy, err := repo.RepositoryInterface.GetIPPolicy(ctx, domainID)
+	if err == nil {
+		repo.ipPolicies.Add(domainID, cachedSetting[IPPolicy]{value: policy, expires: time.Now().Add(repo.settingsTTL)})
+	}
+
+	return policy, err
+}
+
+// SetIPMode sets the domain's IP mode, dropping the cached policy.
+func (repo *CachedRepository) SetIPMode(ctx context.Context, domainID int, mode string) error {
+	defer repo.ipPolicies.Remove(domainID)
+	return repo.RepositoryInterface.SetIPMode(ctx, domainID, mode)
+}
+
+// getCachedSetting returns a domain's setting from the cache, unless it isn't cached or has expired.
+func getCachedSetting[T any](repo *CachedRepository, cache *lruCache[int, cachedSetting[T]], domainID int) (T, bool) {
+	setting, ok := cache.Get(domainID)
+	if !ok || !time.Now().Before(setting.expires) {
+		repo.misses.Add(1)
+		var zero T
+		return zero, false
+	}
+
+	repo.hits.Add(1)
+	return setting.value, true
+}
+
+// Stats returns the number of cached IDs and the cache hit and miss counters.
+func (repo *CachedRepository) Stats() CacheStats {
+	return CacheStats{
+		Domains:     repo.domains.Len(),
+		Pages:       repo.pages.Len(),
+		IPAddresses: repo.ipAddresses.Len(),
+		Hits:        repo.hits.Load(),
+		Misses:      repo.misses.Load(),
+	}
+}
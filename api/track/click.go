@@ -0,0 +1,150 @@
+package track
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// Click classes. A click's class is where the link clicked leads, classified at ingest.
+const (
+	// ClickOutbound is a click on a link to another site.
+	ClickOutbound = "outbound"
+	// ClickDownload is a click on a link to a file with one of the download extensions, on any site.
+	ClickDownload = "download"
+	// ClickInternal is a click on a link to the same site, or on an element which isn't a link.
+	ClickInternal = "internal"
+)
+
+// DefaultDownloadExtensions are the file extensions of links classified as downloads, unless configured otherwise.
+var DefaultDownloadExtensions = []string{
+	"7z", "apk", "csv", "dmg", "doc", "docx", "epub", "exe", "gz", "iso", "mp3", "mp4", "msi",
+	"pdf", "pkg", "ppt", "pptx", "rar", "tar", "tgz", "txt", "wav", "xls", "xlsx", "zip",
+}
+
+// ClickTarget is where a clicked link leads. Only the host and path of the link are kept,
+// as query strings can hold tokens. Host and path are empty for clicks on elements which aren't links.
+type ClickTarget struct {
+	ClickClass string `json:"click_class,omitempty"`
+	TargetHost string `json:"target_host,omitempty"`
+	TargetPath string `json:"target_path,omitempty"`
+}
+
+// ClickTargetCount is the number of clicks on links to a target of a class.
+// Path is empty for outbound clicks, which are counted by host.
+type ClickTargetCount struct {
+	Host  string
+	Path  string
+	Count int64
+}
+
+// ClickClassifier classifies clicks by the link clicked as outbound, download or internal.
+type ClickClassifier struct {
+	downloads map[string]bool
+}
+
+// NewClickClassifier returns a classifier treating links to files with the extensions as downloads.
+func NewClickClassifier(downloadExtensions []string) *ClickClassifier {
+	c := &ClickClassifier{downloads: make(map[string]bool, len(downloadExtensions))}
+	for _, ext := range downloadExtensions {
+		c.downloads[strings.ToLower(strings.TrimPrefix(ext, "."))] = true
+	}
+	return c
+}
+
+// Classify returns the class, host and path of the link a click on the domain was on.
+// The script sends the href of a clicked link, or of the link the clicked element is in.
+// Links to files with a download extension are downloads wherever they are, links to other sites are outbound,
+// and anything else, including clicks on elements which aren't links or links which aren't http(s), is internal.
+func (c *ClickClassifier) Classify(element map[string]interface{}, domain string) ClickTarget {
+	href := clickHref(element)
+	if href == "" {
+		return ClickTarget{ClickClass: ClickInternal}
+	}
+
+	u, err := url.Parse(href)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return ClickTarget{ClickClass: ClickInternal}
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	p := u.Path
+	if p == "" {
+		p = "/"
+	}
+	target := ClickTarget{
+		TargetHost: truncate(host, maxReferrerLength),
+		TargetPath: truncate(p, maxReferrerLength),
+	}
+
+	switch {
+	case c.downloads[strings.ToLower(strings.TrimPrefix(path.Ext(p), "."))]:
+		target.ClickClass = ClickDownload
+	case !sameSite(host, strings.ToLower(domain)):
+		target.ClickClass = ClickOutbound
+	default:
+		target.ClickClass = ClickInternal
+	}
+
+	return target
+}
+
+// clickHref returns the href of the clicked element, or of its parent element.
+func clickHref(element map[string]interface{}) string {
+	if href, ok := element["href"].(string); ok && href != "" {
+		return strings.TrimSpace(href)
+	}
+	if parent, ok := element["parentElement"].(map[string]interface{}); ok {
+		if href, ok := parent["href"].(string); ok {
+			return strings.TrimSpace(href)
+		}
+	}
+	return ""
+}
+
+// validateClickClass checks the class is one reported on.
+func validateClickClass(class string) error {
+	switch class {
+	case ClickOutbound, ClickDownload, ClickInternal:
+		return nil
+	}
+	return fmt.Errorf("unknown click class %q", class)
+}
+
+// queryClickTargets counts the clicks of a class on a domain's pages created in [from, to), most clicked first.
+// Outbound clicks are counted by host, and other classes by host and path.
+func queryClickTargets(ctx context.Context, db *sql.DB, d dialect, domainID int, class string, from, to time.Time) ([]ClickTargetCount, error) {
+	if err := validateClickClass(class); err != nil {
+		return nil, err
+	}
+
+	pathColumn, groupBy := "COALESCE(c.target_path, '')", "COALESCE(c.target_host, ''), COALESCE(c.target_path, '')"
+	if class == ClickOutbound {
+		pathColumn, groupBy = "''", "COALESCE(c.target_host, '')"
+	}
+	query := "SELECT COALESCE(c.target_host, '') AS host, " + pathColumn + " AS path, COUNT(*) AS hits " +
+		"FROM clicks_tb c JOIN pages_tb p ON p.id = c.page_id " +
+		"WHERE p.domain_id = ? AND c.click_class = ? AND c.created_at >= ? AND c.created_at < ? AND NOT c.is_bot " +
+		"GROUP BY " + groupBy + " ORDER BY hits DESC, host, path"
+
+	rows, err := db.QueryContext(ctx, d.rebind(query), domainID, class, d.timeArg(from), d.timeArg(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []ClickTargetCount
+	for rows.Next() {
+		var count ClickTargetCount
+		if err := rows.Scan(&count.Host, &count.Path, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+
+	return counts, rows.Err()
+}
@@ -0,0 +1,138 @@
+package track
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// ContextWithClientIP returns a copy of ctx carrying the resolved IP address of a request's client.
+func ContextWithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// ClientIP returns the IP address of the client which made the request, as resolved by a ClientIPResolver,
+// or the address the request came from if it hasn't been resolved.
+// Identifying, locating and storing the IP addresses of events, rate limiting and logging all use this address.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+// remoteIP returns the IP address the request came from, which is a proxy's if the server is behind one.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ClientIPResolver resolves the IP address of a request's client when the server is behind trusted reverse proxies.
+// Forwarding headers are only believed when the request comes from a trusted proxy, since clients can set them to anything.
+type ClientIPResolver struct {
+	trustedProxies []*net.IPNet
+}
+
+// NewClientIPResolver returns a resolver which trusts the forwarding headers of proxies in the given networks.
+// Without any, the client is always the address the request came from.
+func NewClientIPResolver(trustedProxies []*net.IPNet) *ClientIPResolver {
+	return &ClientIPResolver{trustedProxies: trustedProxies}
+}
+
+// Resolve returns the IP address of the request's client.
+//
+// If the request comes from a trusted proxy, the addresses it was forwarded through are read from the standard Forwarded header,
+// or X-Forwarded-For if there is none. The client is the last address which isn't a trusted proxy,
+// so a client can't pretend to be another by prepending addresses. If every address is trusted, the client is the first.
+// Without either header, a valid X-Real-IP header set by the proxy is the client.
+// An address which can't be parsed, such as an obfuscated or unknown Forwarded node, ends the search at the proxy which added it.
+func (c *ClientIPResolver) Resolve(r *http.Request) string {
+	remote := remoteIP(r)
+	ip := net.ParseIP(remote)
+	if ip == nil || !c.trusted(ip) {
+		return remote
+	}
+
+	hops := forwardedFor(r)
+	if hops == nil {
+		hops = forwardedHeaderValues(r, "X-Forwarded-For")
+	}
+	if hops == nil {
+		if realIP := parseForwardedIP(r.Header.Get("X-Real-IP")); realIP != nil {
+			return realIP.String()
+		}
+		return ip.String()
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseForwardedIP(hops[i])
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !c.trusted(ip) {
+			break
+		}
+	}
+	return ip.String()
+}
+
+func (c *ClientIPResolver) trusted(ip net.IP) bool {
+	for _, proxy := range c.trustedProxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedHeaderValues returns the comma separated values of every occurrence of a header in order, or nil if there are none.
+func forwardedHeaderValues(r *http.Request, header string) []string {
+	var values []string
+	for _, line := range r.Header.Values(header) {
+		for _, value := range strings.Split(line, ",") {
+			values = append(values, strings.TrimSpace(value))
+		}
+	}
+	return values
+}
+
+// forwardedFor returns the for parameter of each element of the RFC 7239 Forwarded header in order, or nil if there is no header.
+// An element without one is an empty address, so it can't be skipped over.
+func forwardedFor(r *http.Request) []string {
+	var hops []string
+	for _, element := range forwardedHeaderValues(r, "Forwarded") {
+		var hop string
+		for _, pair := range strings.Split(element, ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(name), "for") {
+				hop = strings.Trim(strings.TrimSpace(value), `"`)
+			}
+		}
+		hops = append(hops, hop)
+	}
+	return hops
+}
+
+// parseForwardedIP parses an address from a forwarding header, which may have a port and IPv6 addresses may be in brackets,
+// returning nil if it isn't an IP address.
+func parseForwardedIP(s string) net.IP {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+	if ip == nil {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
@@ -0,0 +1,188 @@
+package track
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	// MaxEventProps is the most properties a custom event can have.
+	MaxEventProps = 20
+	// MaxEventPropLength is the longest a string property value can be.
+	MaxEventPropLength = 255
+)
+
+// eventNamePattern matches valid custom event names and property keys.
+// It excludes quotes so keys can be used in JSON paths.
+var eventNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,64}$`)
+
+// CustomEvent is a custom event row ready to be bulk inserted into events_tb.
+type CustomEvent struct {
+	DomainID  int
+	PageID    int
+	Name      string
+	Props     map[string]interface{}
+	CreatedAt time.Time
+	Visitor
+}
+
+// EventPropertyCount is the number of times a custom event was recorded with a property value.
+type EventPropertyCount struct {
+	Value string
+	Count int64
+}
+
+// ValidateCustomEvent checks the event name and that there are at most MaxEventProps properties,
+// each with a valid key and a string, number or bool value.
+func ValidateCustomEvent(name string, props map[string]interface{}) error {
+	if !eventNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid event name %q", name)
+	}
+
+	if len(props) > MaxEventProps {
+		return fmt.Errorf("too many properties, %d is more than %d", len(props), MaxEventProps)
+	}
+
+	for key, value := range props {
+		if !eventNamePattern.MatchString(key) {
+			return fmt.Errorf("invalid property key %q", key)
+		}
+
+		switch v := value.(type) {
+		case string:
+			if len(v) > MaxEventPropLength {
+				return fmt.Errorf("property %s is longer than %d characters", key, MaxEventPropLength)
+			}
+		case float64:
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return fmt.Errorf("property %s is not a finite number", key)
+			}
+		case bool:
+		default:
+			return fmt.Errorf("property %s must be a string, number or bool", key)
+		}
+	}
+
+	return nil
+}
+
+// eventPropertyValue formats a property value the way the databases extract it from JSON as text.
+func eventPropertyValue(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+// jsonArg returns the placeholder for a JSON encoded argument stored in a JSON column.
+func (d dialect) jsonArg() string {
+	switch d {
+	case dialectSQLite:
+		return "json(?)"
+	case dialectPostgres:
+		return "?::jsonb"
+	}
+	return "JSON_UNQUOTE(?)"
+}
+
+// jsonTextExpr extracts a top level key of a JSON column as text, with booleans as true or false.
+// It returns the expression and the arguments for its placeholders.
+func (d dialect) jsonTextExpr(column, key string) (string, []interface{}) {
+	switch d {
+	case dialectSQLite:
+		// json_extract returns booleans as 1 and 0, so they are matched by type
+		path := `$."` + key + `"`
+		return "CASE json_type(" + column + ", ?) WHEN 'true' THEN 'true' WHEN 'false' THEN 'false' ELSE CAST(json_extract(" + column + ", ?) AS TEXT) END",
+			[]interface{}{path, path}
+	case dialectPostgres:
+		return column + " ->> ?", []interface{}{key}
+	}
+	return "JSON_UNQUOTE(JSON_EXTRACT(" + column + ", ?))", []interface{}{`$."` + key + `"`}
+}
+
+var customEventColumns = append([]string{"domain_id", "page_id", "name", "props", "created_at"}, visitorColumns...)
+
+// customEventsQuery builds a multi-row INSERT of the custom events into events_tb, returning its arguments.
+func customEventsQuery(d dialect, events []CustomEvent) (string, []interface{}, error) {
+	values := append([]string{"?", "?", "?", d.jsonArg(), "?"}, placeholders(len(visitorColumns))...)
+
+	args := make([]interface{}, 0, len(events)*len(customEventColumns))
+	for _, e := range events {
+		propsJSON, err := marshalProps(e.Props)
+		if err != nil {
+			return "", nil, err
+		}
+		args = append(args, e.DomainID, e.PageID, e.Name, propsJSON, d.timeArg(e.CreatedAt))
+		args = append(args, visitorArgs(e.Visitor)...)
+	}
+
+	return bulkInsertQuery("events_tb", customEventColumns, values, len(events), d == dialectPostgres), args, nil
+}
+
+// saveCustomEvents saves the custom events to events_tb in a single transaction.
+func saveCustomEvents(ctx context.Context, db *sql.DB, d dialect, events []CustomEvent) error {
+	return bulkInsert(ctx, db, d, events, len(customEventColumns), func(tx *sql.Tx, events []CustomEvent) error {
+		query, args, err := customEventsQuery(d, events)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, query, args...)
+		return err
+	})
+}
+
+// queryEventPropertyBreakdown counts the custom events with the name for a domain created in [from, to)
+// by the value of the property, most common first. Events without the property are not counted.
+func queryEventPropertyBreakdown(ctx context.Context, db *sql.DB, d dialect, domainID int, name, property string, from, to time.Time) ([]EventPropertyCount, error) {
+	if !eventNamePattern.MatchString(property) {
+		return nil, fmt.Errorf("invalid property key %q", property)
+	}
+
+	valueExpr, valueArgs := d.jsonTextExpr("props", property)
+	query := "SELECT prop_value, COUNT(*) AS hits FROM (SELECT " + valueExpr + " AS prop_value FROM events_tb " +
+		"WHERE domain_id = ? AND name = ? AND created_at >= ? AND created_at < ? AND NOT is_bot) AS event_values " +
+		"WHERE prop_value IS NOT NULL GROUP BY prop_value ORDER BY hits DESC, prop_value"
+
+	args := append(valueArgs, domainID, name, d.timeArg(from), d.timeArg(to))
+	rows, err := db.QueryContext(ctx, d.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []EventPropertyCount
+	for rows.Next() {
+		var count EventPropertyCount
+		if err := rows.Scan(&count.Value, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+
+	return counts, rows.Err()
+}
+
+// marshalProps encodes the properties as a JSON object, storing no properties as {}.
+func marshalProps(props map[string]interface{}) (string, error) {
+	if props == nil {
+		return "{}", nil
+	}
+
+	propsJSON, err := json.Marshal(props)
+	if err != nil {
+		return "", err
+	}
+
+	return string(propsJSON), nil
+}
@@ -0,0 +1,160 @@
+package track
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/jwtly10/simple-site-tracker/utils/logger"
+)
+
+// MaxEngagedSeconds is the most engaged time one engagement event can add to a page view.
+// The script reports at least every heartbeat, so larger reports are clamped rather than trusted.
+const MaxEngagedSeconds = 300
+
+// viewIDPattern matches the random IDs the script gives page views, such as a UUID.
+var viewIDPattern = regexp.MustCompile(`^[A-Za-z0-9-]{8,36}$`)
+
+// Engagement is engaged time ready to be added to the page view with the view ID on the domain.
+type Engagement struct {
+	DomainID int
+	ViewID   string
+	Seconds  int
+}
+
+// PageEngagement is how long the page views of a path were engaged with on average.
+// Only page views with a view ID, whose script reports engaged time, are counted.
+type PageEngagement struct {
+	Page           string
+	Views          int64
+	EngagedSeconds int64
+	AverageSeconds float64
+}
+
+// ValidateViewID checks a page view's view ID, which is optional on page views.
+func ValidateViewID(viewID string) error {
+	if viewID != "" && !viewIDPattern.MatchString(viewID) {
+		return fmt.Errorf("invalid view ID %q", viewID)
+	}
+	return nil
+}
+
+// ValidateEngagement checks an engagement event has a view ID and a positive number of seconds.
+func ValidateEngagement(viewID string, seconds int) error {
+	if viewID == "" {
+		return fmt.Errorf("missing view ID")
+	}
+	if err := ValidateViewID(viewID); err != nil {
+		return err
+	}
+	if seconds <= 0 {
+		return fmt.Errorf("engaged seconds must be positive, got %d", seconds)
+	}
+	return nil
+}
+
+// engagedSeconds clamps the seconds of an engagement event to MaxEngagedSeconds.
+func engagedSeconds(seconds int) int {
+	if seconds > MaxEngagedSeconds {
+		return MaxEngagedSeconds
+	}
+	return seconds
+}
+
+type TrackEngagementRequest struct {
+	ViewID         string `json:"view_id"`
+	EngagedSeconds int    `json:"engaged_seconds"`
+	URL            string `json:"url"`
+}
+
+// TrackEngagementHandler handles engaged time reported for a page view, which is added to the page view with its view ID.
+// It returns a 400 status code if the view ID or seconds are invalid.
+func (h *Handlers) TrackEngagementHandler(w http.ResponseWriter, r *http.Request) {
+	l := logger.Get()
+	if r.Method != http.MethodPost {
+		l.Warn().Msgf("Invalid method: %s", r.Method)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var engagement TrackEngagementRequest
+	if !decodeRequest(w, r, &engagement) {
+		return
+	}
+
+	if err := ValidateEngagement(engagement.ViewID, engagement.EngagedSeconds); err != nil {
+		l.Error().Msgf("Invalid engagement: %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		l.Error().Msg("Missing Origin header")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	h.handleEvent(w, r, Event{
+		Type:           EventEngagement,
+		Domain:         getDomainFromOrigin(origin),
+		Page:           getPageFromURL(engagement.URL),
+		CreatedAt:      time.Now(),
+		ViewID:         engagement.ViewID,
+		EngagedSeconds: engagedSeconds(engagement.EngagedSeconds),
+	})
+}
+
+// addEngagements adds the engaged time of each engagement to its page view in one transaction.
+// Engagements for page views which don't exist, such as those of dropped bots, are ignored.
+func addEngagements(ctx context.Context, db *sql.DB, d dialect, engagements []Engagement) error {
+	return withTx(ctx, db, func(tx *sql.Tx) error {
+		query := d.rebind("UPDATE page_views_tb SET engaged_seconds = engaged_seconds + ? WHERE domain_id = ? AND view_id = ?")
+		for _, e := range engagements {
+			if _, err := tx.ExecContext(ctx, query, e.Seconds, e.DomainID, e.ViewID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// queryPageEngagement averages the engaged time of a domain's page views created in [from, to) by path,
+// most viewed first.
+func queryPageEngagement(ctx context.Context, db *sql.DB, d dialect, domainID int, from, to time.Time) ([]PageEngagement, error) {
+	query := "SELECT p.page_url, COUNT(*) AS views, SUM(pv.engaged_seconds) FROM page_views_tb pv JOIN pages_tb p ON p.id = pv.page_id " +
+		"WHERE pv.domain_id = ? AND pv.created_at >= ? AND pv.created_at < ? AND pv.view_id IS NOT NULL AND NOT pv.is_bot " +
+		"GROUP BY p.page_url ORDER BY views DESC, p.page_url"
+
+	rows, err := db.QueryContext(ctx, d.rebind(query), domainID, d.timeArg(from), d.timeArg(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var engagement []PageEngagement
+	for rows.Next() {
+		var page PageEngagement
+		if err := rows.Scan(&page.Page, &page.Views, &page.EngagedSeconds); err != nil {
+			return nil, err
+		}
+		page.AverageSeconds = float64(page.EngagedSeconds) / float64(page.Views)
+		engagement = append(engagement, page)
+	}
+
+	return engagement, rows.Err()
+}
+
+// sortPageEngagement orders pages most viewed first, then by path.
+func sortPageEngagement(engagement []PageEngagement) {
+	sort.Slice(engagement, func(i, j int) bool {
+		if engagement[i].Views != engagement[j].Views {
+			return engagement[i].Views > engagement[j].Views
+		}
+		return engagement[i].Page < engagement[j].Page
+	})
+}
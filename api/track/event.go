@@ -0,0 +1,118 @@
+package track
+
+import "time"
+
+type EventType string
+
+const (
+	EventPageView EventType = "pageview"
+	EventClick    EventType = "click"
+	EventUTM      EventType = "utm"
+	EventCustom   EventType = "event"
+	EventForm     EventType = "form"
+	EventError    EventType = "error"
+	// EventEngagement adds engaged time to an earlier page view, rather than being stored itself
+	EventEngagement EventType = "engagement"
+	// EventScroll keeps how far down the page an earlier page view scrolled, rather than being stored itself
+	EventScroll EventType = "scroll"
+)
+
+// Event is a single tracking event received by a handler, before its domain and page IDs are resolved.
+type Event struct {
+	Type      EventType `json:"type"`
+	Domain    string    `json:"domain"`
+	Page      string    `json:"page"`
+	CreatedAt time.Time `json:"created_at"`
+	Visitor
+
+	// ClientIP is the IP address of the client, held in memory until the site's IP mode is known.
+	// It is never serialised, so spooled events can't hold the raw address.
+	ClientIP string `json:"-"`
+	// IPAddress is the client's IP address in the form stored by the site's IP mode, set in place of
+	// ClientIP once the mode is known, or empty if the site doesn't store IP addresses.
+	IPAddress string `json:"ip_address,omitempty"`
+
+	// Set for page view events
+	Referrer
+
+	// ViewID is the random ID the script gives a page view, set for page view, engagement and scroll events
+	ViewID string `json:"view_id,omitempty"`
+	// Set for engagement events
+	EngagedSeconds int `json:"engaged_seconds,omitempty"`
+	// Set for scroll events
+	ScrollDepth int `json:"scroll_depth,omitempty"`
+
+	// Set for click events
+	Element map[string]interface{} `json:"element,omitempty"`
+	ClickTarget
+
+	// Set for UTM events
+	UTMSource   string `json:"utm_source,omitempty"`
+	UTMMedium   string `json:"utm_medium,omitempty"`
+	UTMCampaign string `json:"utm_campaign,omitempty"`
+	Track       string `json:"track,omitempty"`
+
+	// Set for custom events
+	Name  string                 `json:"name,omitempty"`
+	Props map[string]interface{} `json:"props,omitempty"`
+
+	// Set for form events
+	Form
+
+	// Set for error events
+	JSError
+}
+
+// Visitor identifies who an event came from without cookies. The visitor ID is a hash of the
+// client's IP address, user agent and site with a salt which rotates daily, and the session ID
+// groups a visitor's events until they are inactive for the session timeout.
+// Both are empty if the event wasn't identified.
+// The user agent is the visitor's parsed browser, operating system and device class,
+// and the geo is where their IP address is located, if the handlers have a GeoIP database.
+// Bot is set if the request came from a bot, crawler, headless browser or prefetch.
+// IPAddressID links the event to its anonymised IP address in ip_addresses_tb, once it is stored.
+type Visitor struct {
+	VisitorID string `json:"visitor_id,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+	UserAgent
+	Geo
+	Bot         bool `json:"bot,omitempty"`
+	IPAddressID int  `json:"-"`
+}
+
+// Spooler durably stores events which could not be saved, so they can be replayed later.
+type Spooler interface {
+	Spool(events []Event) error
+}
+
+// PageView is a page view row ready to be bulk inserted into page_views_tb.
+type PageView struct {
+	DomainID  int
+	PageID    int
+	ViewID    string
+	CreatedAt time.Time
+	Visitor
+	Referrer
+}
+
+// Click is a click row ready to be bulk inserted into clicks_tb.
+type Click struct {
+	PageID    int
+	Element   map[string]interface{}
+	CreatedAt time.Time
+	Visitor
+	ClickTarget
+}
+
+// UTM is a UTM row ready to be bulk inserted into utm_tb.
+// DomainID is not stored on utm_tb, but keys the UTM rollup.
+type UTM struct {
+	DomainID    int
+	PageID      int
+	UTMSource   string
+	UTMMedium   string
+	UTMCampaign string
+	Track       string
+	CreatedAt   time.Time
+	Visitor
+}
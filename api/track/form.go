@@ -0,0 +1,178 @@
+package track
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jwtly10/simple-site-tracker/utils/logger"
+)
+
+const (
+	// MaxFormAttrLength is the longest a form's id or name can be, matching the column sizes.
+	MaxFormAttrLength = 255
+	// MaxFormFields is the most fields a submitted form can have.
+	MaxFormFields = 1000
+)
+
+// Form is the form of a submission. Only its id, name, action and number of fields are kept,
+// never the values entered. The action is stored without its query string, which can hold tokens.
+type Form struct {
+	FormID     string `json:"form_id,omitempty"`
+	FormName   string `json:"form_name,omitempty"`
+	FormAction string `json:"form_action,omitempty"`
+	FieldCount int    `json:"field_count,omitempty"`
+}
+
+// FormSubmission is a form submission row ready to be bulk inserted into form_submissions_tb.
+type FormSubmission struct {
+	DomainID  int
+	PageID    int
+	CreatedAt time.Time
+	Visitor
+	Form
+}
+
+// FormSubmissionCount is the number of times a form was submitted on a page.
+// A form is identified by its id, name and action, any of which can be empty.
+type FormSubmissionCount struct {
+	Page       string
+	FormID     string
+	FormName   string
+	FormAction string
+	Count      int64
+}
+
+// ValidateForm checks the form's id and name are at most MaxFormAttrLength long,
+// and that it has between 0 and MaxFormFields fields.
+func ValidateForm(form Form) error {
+	if len(form.FormID) > MaxFormAttrLength {
+		return fmt.Errorf("form id is longer than %d characters", MaxFormAttrLength)
+	}
+	if len(form.FormName) > MaxFormAttrLength {
+		return fmt.Errorf("form name is longer than %d characters", MaxFormAttrLength)
+	}
+	if form.FieldCount < 0 || form.FieldCount > MaxFormFields {
+		return fmt.Errorf("invalid field count %d, expected 0 to %d", form.FieldCount, MaxFormFields)
+	}
+	return nil
+}
+
+// urlWithoutQuery returns the URL without its credentials, query string or fragment, truncated to n bytes,
+// or an empty string if it is invalid.
+func urlWithoutQuery(rawURL string, n int) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return ""
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.ForceQuery = false
+	u.Fragment = ""
+	return truncate(u.String(), n)
+}
+
+type TrackFormRequest struct {
+	FormID     string `json:"form_id"`
+	FormName   string `json:"form_name"`
+	FormAction string `json:"form_action"`
+	FieldCount int    `json:"field_count"`
+	URL        string `json:"url"`
+}
+
+// TrackFormHandler handles form submissions.
+// It returns a 400 status code if the form is invalid.
+func (h *Handlers) TrackFormHandler(w http.ResponseWriter, r *http.Request) {
+	l := logger.Get()
+	if r.Method != http.MethodPost {
+		l.Warn().Msgf("Invalid method: %s", r.Method)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var formEvent TrackFormRequest
+	if !decodeRequest(w, r, &formEvent) {
+		return
+	}
+
+	form := Form{
+		FormID:     formEvent.FormID,
+		FormName:   formEvent.FormName,
+		FormAction: urlWithoutQuery(formEvent.FormAction, MaxFormAttrLength),
+		FieldCount: formEvent.FieldCount,
+	}
+	if err := ValidateForm(form); err != nil {
+		l.Error().Msgf("Invalid form submission: %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		l.Error().Msg("Missing Origin header")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	h.handleEvent(w, r, Event{
+		Type:      EventForm,
+		Domain:    getDomainFromOrigin(origin),
+		Page:      getPageFromURL(formEvent.URL),
+		CreatedAt: time.Now(),
+		Form:      form,
+	})
+}
+
+var formSubmissionColumns = append(append([]string{"domain_id", "page_id", "created_at"}, visitorColumns...), "form_id", "form_name", "form_action", "field_count")
+
+// formSubmissionsQuery builds a multi-row INSERT of the form submissions into form_submissions_tb, returning its arguments.
+func formSubmissionsQuery(d dialect, submissions []FormSubmission) (string, []interface{}) {
+	args := make([]interface{}, 0, len(submissions)*len(formSubmissionColumns))
+	for _, s := range submissions {
+		args = append(args, s.DomainID, s.PageID, d.timeArg(s.CreatedAt))
+		args = append(args, visitorArgs(s.Visitor)...)
+		args = append(args, nullString(s.FormID), nullString(s.FormName), nullString(s.FormAction), s.FieldCount)
+	}
+
+	return bulkInsertQuery("form_submissions_tb", formSubmissionColumns, nil, len(submissions), d == dialectPostgres), args
+}
+
+// saveFormSubmissions saves the form submissions to form_submissions_tb in a single transaction.
+func saveFormSubmissions(ctx context.Context, db *sql.DB, d dialect, submissions []FormSubmission) error {
+	return bulkInsert(ctx, db, d, submissions, len(formSubmissionColumns), func(tx *sql.Tx, submissions []FormSubmission) error {
+		query, args := formSubmissionsQuery(d, submissions)
+		_, err := tx.ExecContext(ctx, query, args...)
+		return err
+	})
+}
+
+// queryFormSubmissionCounts counts a domain's form submissions created in [from, to) by page and form, most submitted first.
+func queryFormSubmissionCounts(ctx context.Context, db *sql.DB, d dialect, domainID int, from, to time.Time) ([]FormSubmissionCount, error) {
+	query := "SELECT p.page_url, COALESCE(f.form_id, '') AS form_id, COALESCE(f.form_name, '') AS form_name, " +
+		"COALESCE(f.form_action, '') AS form_action, COUNT(*) AS hits " +
+		"FROM form_submissions_tb f JOIN pages_tb p ON p.id = f.page_id " +
+		"WHERE f.domain_id = ? AND f.created_at >= ? AND f.created_at < ? AND NOT f.is_bot " +
+		"GROUP BY p.page_url, COALESCE(f.form_id, ''), COALESCE(f.form_name, ''), COALESCE(f.form_action, '') " +
+		"ORDER BY hits DESC, p.page_url, form_id, form_name, form_action"
+
+	rows, err := db.QueryContext(ctx, d.rebind(query), domainID, d.timeArg(from), d.timeArg(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []FormSubmissionCount
+	for rows.Next() {
+		var count FormSubmissionCount
+		if err := rows.Scan(&count.Page, &count.FormID, &count.FormName, &count.FormAction, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+
+	return counts, rows.Err()
+}
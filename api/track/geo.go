@@ -0,0 +1,74 @@
+package track
+
+import (
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// maxGeoFieldLength is the longest region or city name stored, matching the column sizes.
+const maxGeoFieldLength = 64
+
+// Geo is where a client's IP address is located: its ISO country code and English region and city names.
+// Each field is empty if the location isn't known to that precision.
+type Geo struct {
+	Country string `json:"country,omitempty"`
+	Region  string `json:"region,omitempty"`
+	City    string `json:"city,omitempty"`
+}
+
+// geoRecord is the part of a GeoIP2 or GeoLite2 City or Country record which is read.
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+// GeoIP looks up the location of IP addresses in a local MaxMind-format database, such as GeoLite2 City.
+// Lookups run offline, so IP addresses are never sent to a third party.
+type GeoIP struct {
+	reader *maxminddb.Reader
+}
+
+// OpenGeoIP opens the .mmdb database at path.
+func OpenGeoIP(path string) (*GeoIP, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &GeoIP{reader: reader}, nil
+}
+
+// Lookup returns the location of the IP address, which is empty if the address is invalid or not in the database.
+// Only the first subdivision of a location is used as its region.
+func (g *GeoIP) Lookup(ipAddress string) Geo {
+	ip := net.ParseIP(ipAddress)
+	if ip == nil {
+		return Geo{}
+	}
+
+	var record geoRecord
+	if err := g.reader.Lookup(ip, &record); err != nil {
+		return Geo{}
+	}
+
+	geo := Geo{
+		Country: truncate(record.Country.ISOCode, 2),
+		City:    truncate(record.City.Names["en"], maxGeoFieldLength),
+	}
+	if len(record.Subdivisions) > 0 {
+		geo.Region = truncate(record.Subdivisions[0].Names["en"], maxGeoFieldLength)
+	}
+	return geo
+}
+
+// Close closes the database.
+func (g *GeoIP) Close() error {
+	return g.reader.Close()
+}
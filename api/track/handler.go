@@ -1,13 +1,17 @@
 package track
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"mime"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/tdewolff/minify"
 	"github.com/tdewolff/minify/js"
@@ -16,11 +20,49 @@ import (
 )
 
 type Handlers struct {
-	repo RepositoryInterface
+	repo      RepositoryInterface
+	writer    *EventWriter
+	spooler   Spooler
+	visitors  *Visitors
+	referrers *ReferrerRules
+	clicks    *ClickClassifier
+	geoIP     *GeoIP
 }
 
 func NewHandlers(repo RepositoryInterface) *Handlers {
-	return &Handlers{repo: repo}
+	return &Handlers{repo: repo, referrers: DefaultReferrerRules(), clicks: NewClickClassifier(DefaultDownloadExtensions)}
+}
+
+// SetEventWriter makes the tracking handlers queue events on the writer and respond
+// immediately, rather than saving each event before responding.
+func (h *Handlers) SetEventWriter(writer *EventWriter) {
+	h.writer = writer
+}
+
+// SetSpooler makes the tracking handlers spool events which fail to save, rather than dropping them.
+// Events queued on an event writer are spooled by the writer instead.
+func (h *Handlers) SetSpooler(spooler Spooler) {
+	h.spooler = spooler
+}
+
+// SetVisitors makes the tracking handlers identify the visitor and session of each event.
+func (h *Handlers) SetVisitors(visitors *Visitors) {
+	h.visitors = visitors
+}
+
+// SetGeoIP sets the database used to locate the clients of events. Without one, events aren't located.
+func (h *Handlers) SetGeoIP(geoIP *GeoIP) {
+	h.geoIP = geoIP
+}
+
+// SetReferrerRules replaces the bundled rules used to classify page view referrers.
+func (h *Handlers) SetReferrerRules(rules *ReferrerRules) {
+	h.referrers = rules
+}
+
+// SetDownloadExtensions replaces the file extensions of links whose clicks are classified as downloads.
+func (h *Handlers) SetDownloadExtensions(extensions []string) {
+	h.clicks = NewClickClassifier(extensions)
 }
 
 type TrackUTMRequest struct {
@@ -43,7 +85,7 @@ func (h *Handlers) ServeTrackJSHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check valid clientKey
-	domainId, err := h.repo.GetDomainIDFromKey(clientKey)
+	domainId, err := h.repo.GetDomainIDFromKey(r.Context(), clientKey)
 	if err != nil {
 		l.Error().Err(err).Msg("Error getting domain ID from key")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -81,10 +123,7 @@ func (h *Handlers) TrackUTMHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var utmEvent TrackUTMRequest
-	err := json.NewDecoder(r.Body).Decode(&utmEvent)
-	if err != nil {
-		l.Error().Msgf("Error decoding request: %s", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
+	if !decodeRequest(w, r, &utmEvent) {
 		return
 	}
 
@@ -98,53 +137,22 @@ func (h *Handlers) TrackUTMHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	domain := getDomainFromOrigin(origin)
-
-	// We can ignore the error here because we already validated the domain
-	// TODO ensure this is true
-	domainId, _ := h.repo.GetDomain(domain)
-	if domainId == 0 {
-		w.WriteHeader(http.StatusUnauthorized)
-	}
-
-	// Get the page ID
-	page := getPageFromURL(utmEvent.PageURL)
-	pageId, err := h.repo.GetPage(domainId, page)
-	if err != nil {
-		l.Error().Err(err).Msg("Error getting page")
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	// Create page if it doesn't exist
-	var pageId64 int64
-	if pageId == 0 {
-		l.Info().Msgf("Page %s does not exist. Creating page", page)
-		pageId64, err = h.repo.CreatePage(domainId, page)
-		if err != nil {
-			l.Error().Err(err).Msg("Error creating page")
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		pageId = int(pageId64)
-	}
-
-	// Save UTM
-	l.Info().Msgf("Saving UTM for page %s", page)
-	utmId, err := h.repo.SaveUTM(pageId, utmEvent.UTMSource, utmEvent.UTMMedium, utmEvent.UTMCampaign, utmEvent.Track)
-	if err != nil {
-		l.Error().Err(err).Msg("Error saving UTM")
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	l.Info().Msgf("UTM tracked with ID %d", utmId)
-	w.WriteHeader(http.StatusOK)
+	h.handleEvent(w, r, Event{
+		Type:        EventUTM,
+		Domain:      getDomainFromOrigin(origin),
+		Page:        getPageFromURL(utmEvent.PageURL),
+		CreatedAt:   time.Now(),
+		UTMSource:   utmEvent.UTMSource,
+		UTMMedium:   utmEvent.UTMMedium,
+		UTMCampaign: utmEvent.UTMCampaign,
+		Track:       utmEvent.Track,
+	})
 }
 
 type TrackPageViewRequest struct {
-	URL string `json:"url"`
+	URL      string `json:"url"`
+	Referrer string `json:"referrer"`
+	ViewID   string `json:"view_id"`
 }
 
 func (h *Handlers) TrackPageViewHandler(w http.ResponseWriter, r *http.Request) {
@@ -157,9 +165,12 @@ func (h *Handlers) TrackPageViewHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	var pageViewEvent TrackPageViewRequest
-	err := json.NewDecoder(r.Body).Decode(&pageViewEvent)
-	if err != nil {
-		l.Error().Msgf("Error decoding request: %s", err.Error())
+	if !decodeRequest(w, r, &pageViewEvent) {
+		return
+	}
+
+	if err := ValidateViewID(pageViewEvent.ViewID); err != nil {
+		l.Error().Msgf("Invalid page view: %s", err.Error())
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -174,43 +185,14 @@ func (h *Handlers) TrackPageViewHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	domain := getDomainFromOrigin(origin)
-	domainId, err := h.repo.GetDomain(domain)
-	if domainId == 0 {
-		w.WriteHeader(http.StatusUnauthorized)
-	}
-
-	page := getPageFromURL(pageViewEvent.URL)
-	pageId, err := h.repo.GetPage(domainId, page)
-	if err != nil {
-		l.Error().Err(err).Msg("Error getting page")
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	var pageId64 int64
-	if pageId == 0 {
-		l.Info().Msgf("Page %s does not exist. Creating page", page)
-		pageId64, err = h.repo.CreatePage(domainId, page)
-		if err != nil {
-			l.Error().Err(err).Msg("Error creating page")
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		pageId = int(pageId64)
-	}
-
-	// Save page view
-	l.Info().Msgf("Saving page view for page %s", page)
-	pageViewId, err := h.repo.SavePageView(domainId, pageId)
-	if err != nil {
-		l.Error().Err(err).Msg("Error saving page view")
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	l.Info().Msgf("Page view tracked with ID %d", pageViewId)
-	w.WriteHeader(http.StatusOK)
+	h.handleEvent(w, r, Event{
+		Type:      EventPageView,
+		Domain:    domain,
+		Page:      getPageFromURL(pageViewEvent.URL),
+		CreatedAt: time.Now(),
+		Referrer:  h.referrers.Classify(pageViewEvent.Referrer, domain),
+		ViewID:    pageViewEvent.ViewID,
+	})
 }
 
 type TrackClickRequest struct {
@@ -232,10 +214,7 @@ func (h *Handlers) TrackClickHandler(w http.ResponseWriter, r *http.Request) {
 	l.Info().Msg("Tracking clicks")
 
 	var clickEvent TrackClickRequest
-	err := json.NewDecoder(r.Body).Decode(&clickEvent)
-	if err != nil {
-		l.Error().Msgf("Error decoding request: %s", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
+	if !decodeRequest(w, r, &clickEvent) {
 		return
 	}
 
@@ -248,47 +227,251 @@ func (h *Handlers) TrackClickHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	domain := getDomainFromOrigin(origin)
+	h.handleEvent(w, r, Event{
+		Type:        EventClick,
+		Domain:      domain,
+		Page:        getPageFromURL(clickEvent.URL),
+		CreatedAt:   time.Now(),
+		Element:     clickEvent.Element,
+		ClickTarget: h.clicks.Classify(clickEvent.Element, domain),
+	})
+}
 
-	domainId, err := h.repo.GetDomain(domain)
-	if domainId == 0 {
+type TrackEventRequest struct {
+	Name  string                 `json:"name"`
+	Props map[string]interface{} `json:"props"`
+	URL   string                 `json:"url"`
+}
+
+// TrackEventHandler handles tracking custom events.
+// It returns a 400 status code if the event name or properties are invalid.
+func (h *Handlers) TrackEventHandler(w http.ResponseWriter, r *http.Request) {
+	l := logger.Get()
+	if r.Method != http.MethodPost {
+		l.Warn().Msgf("Invalid method: %s", r.Method)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var customEvent TrackEventRequest
+	if !decodeRequest(w, r, &customEvent) {
+		return
+	}
+
+	if err := ValidateCustomEvent(customEvent.Name, customEvent.Props); err != nil {
+		l.Error().Msgf("Invalid custom event: %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	l.Info().Msgf("Tracking custom event %s", customEvent.Name)
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		l.Error().Msg("Missing Origin header")
 		w.WriteHeader(http.StatusUnauthorized)
+		return
 	}
 
-	// Get the page id
-	page := getPageFromURL(clickEvent.URL)
-	pageId, err := h.repo.GetPage(domainId, page)
-	if err != nil {
-		l.Error().Err(err).Msg("Error getting page")
-		w.WriteHeader(http.StatusInternalServerError)
+	h.handleEvent(w, r, Event{
+		Type:      EventCustom,
+		Domain:    getDomainFromOrigin(origin),
+		Page:      getPageFromURL(customEvent.URL),
+		CreatedAt: time.Now(),
+		Name:      customEvent.Name,
+		Props:     customEvent.Props,
+	})
+}
+
+// decodeRequest decodes the JSON request body into v, writing an error status code if it can't.
+// JSON sent as text/plain is accepted, as navigator.sendBeacon and CORS-simple requests send it
+// that way to avoid a preflight.
+func decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	l := logger.Get()
+
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || (mediaType != "application/json" && mediaType != "text/plain") {
+			l.Error().Msgf("Unsupported content type: %s", contentType)
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return false
+		}
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		l.Error().Msgf("Error decoding request: %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
+
+// handleEvent identifies the visitor of the event, then queues it on the event writer and returns a 202 status code.
+// Without an event writer, it saves the event before returning a 200 status code,
+// cancelling the queries if the request's context is cancelled.
+func (h *Handlers) handleEvent(w http.ResponseWriter, r *http.Request, event Event) {
+	l := logger.Get()
+	ctx := r.Context()
+
+	event.ClientIP = ClientIP(r)
+	event.Visitor = h.identify(r, event.ClientIP, event.Domain, event.CreatedAt)
+
+	if h.writer != nil {
+		err := h.writer.Enqueue(event)
+		if err != nil {
+			l.Error().Err(err).Msgf("Error queueing %s event", event.Type)
+			if errors.Is(err, ErrQueueFull) {
+				w.Header().Set("Retry-After", "1")
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	domainId, err := h.repo.GetDomain(ctx, event.Domain)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && domainId == 0) {
+		l.Error().Msgf("Unknown domain %s", event.Domain)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		l.Error().Err(err).Msg("Error getting domain")
+		h.spoolEvent(w, event, err)
+		return
+	}
+
+	if event.Bot {
+		mode, err := h.repo.GetBotMode(ctx, domainId)
+		if err != nil {
+			l.Error().Err(err).Msg("Error getting bot mode")
+			h.spoolEvent(w, event, err)
+			return
+		}
+		if mode == BotModeDrop {
+			l.Info().Msgf("Dropping %s event from bot for domain %s", event.Type, event.Domain)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+
+	if event.Type == EventEngagement {
+		if err := h.repo.AddEngagements(ctx, []Engagement{{DomainID: domainId, ViewID: event.ViewID, Seconds: event.EngagedSeconds}}); err != nil {
+			l.Error().Err(err).Msg("Error adding engagement")
+			h.spoolEvent(w, event, err)
+			return
+		}
+
+		l.Info().Msgf("Added %ds of engagement to page view %s", event.EngagedSeconds, event.ViewID)
+		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	// Create page if it doesn't exist
-	var pageId64 int64
-	if pageId == 0 {
-		l.Info().Msgf("Page %s does not exist. Creating page", page)
-		pageId64, err = h.repo.CreatePage(domainId, page)
+	if event.Type == EventScroll {
+		if err := h.repo.SaveScrollDepths(ctx, []ScrollDepth{{DomainID: domainId, ViewID: event.ViewID, Depth: event.ScrollDepth}}); err != nil {
+			l.Error().Err(err).Msg("Error saving scroll depth")
+			h.spoolEvent(w, event, err)
+			return
+		}
+
+		l.Info().Msgf("Saved scroll depth %d of page view %s", event.ScrollDepth, event.ViewID)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if event.ClientIP != "" {
+		policy, err := h.repo.GetIPPolicy(ctx, domainId)
 		if err != nil {
-			l.Error().Err(err).Msg("Error creating page")
-			w.WriteHeader(http.StatusInternalServerError)
+			l.Error().Err(err).Msg("Error getting IP policy")
+			h.spoolEvent(w, event, err)
 			return
 		}
+		// Anonymise the address first, so the event is spooled without it if it can't be saved
+		anonymiseClientIP(&event, policy)
+		if event.IPAddressID, err = saveIPAddress(ctx, h.repo, event.IPAddress); err != nil {
+			l.Error().Err(err).Msg("Error saving IP address")
+			h.spoolEvent(w, event, err)
+			return
+		}
+	}
 
-		pageId = int(pageId64)
+	pageId, err := h.repo.GetOrCreatePage(ctx, domainId, event.Page)
+	if err != nil {
+		l.Error().Err(err).Msg("Error getting page")
+		h.spoolEvent(w, event, err)
+		return
+	}
+
+	var id int64
+	switch event.Type {
+	case EventUTM:
+		l.Info().Msgf("Saving UTM for page %s", event.Page)
+		id, err = h.repo.SaveUTM(ctx, pageId, event.UTMSource, event.UTMMedium, event.UTMCampaign, event.Track, event.Visitor)
+	case EventPageView:
+		l.Info().Msgf("Saving page view for page %s", event.Page)
+		id, err = h.repo.SavePageView(ctx, domainId, pageId, event.ViewID, event.Visitor, event.Referrer)
+	case EventClick:
+		l.Info().Msgf("Saving click for page %s", event.Page)
+		id, err = h.repo.SaveClick(ctx, pageId, event.Element, event.Visitor, event.ClickTarget)
+	case EventCustom:
+		l.Info().Msgf("Saving %s event for page %s", event.Name, event.Page)
+		id, err = h.repo.SaveCustomEvent(ctx, domainId, pageId, event.Name, event.Props, event.Visitor)
+	case EventForm:
+		l.Info().Msgf("Saving form submission for page %s", event.Page)
+		id, err = h.repo.SaveFormSubmission(ctx, domainId, pageId, event.Form, event.Visitor)
+	case EventError:
+		l.Info().Msgf("Saving JS error %s for page %s", event.Fingerprint, event.Page)
+		id, err = h.repo.SaveJSError(ctx, domainId, pageId, event.JSError, event.Visitor)
 	}
-	// Save the click
-	l.Info().Msgf("Saving click for page %s", page)
-	clickId, err := h.repo.SaveClick(pageId, clickEvent.Element)
 	if err != nil {
-		l.Error().Err(err).Msg("Error saving click")
-		w.WriteHeader(http.StatusInternalServerError)
+		l.Error().Err(err).Msgf("Error saving %s event", event.Type)
+		h.spoolEvent(w, event, err)
 		return
 	}
 
-	l.Info().Msgf("Click tracked with ID %d", clickId)
+	l.Info().Msgf("Tracked %s event with ID %d", event.Type, id)
 	w.WriteHeader(http.StatusOK)
 }
 
+// identify returns the visitor and session of a request's events on the domain, with its parsed user agent,
+// location and whether it came from a bot. The visitor and session IDs are empty if the handlers don't identify visitors.
+// The client's IP address, as resolved by ClientIP, is only used to identify and locate them, and isn't stored.
+func (h *Handlers) identify(r *http.Request, ip, domain string, at time.Time) Visitor {
+	var visitor Visitor
+	if h.visitors != nil {
+		visitor = h.visitors.Identify(r.Context(), domain, ip, r.UserAgent(), at)
+	}
+	visitor.UserAgent = ParseUserAgent(r.UserAgent())
+	if h.geoIP != nil {
+		visitor.Geo = h.geoIP.Lookup(ip)
+	}
+	visitor.Bot = DetectBot(r) != ""
+	return visitor
+}
+
+// spoolEvent stores an event which could not be saved because the repository was unavailable for later replay,
+// returning a 202 status code. It returns a 500 status code if there is no spooler, the event could not be spooled,
+// or the repository rejected the event, as it would be rejected again on replay.
+func (h *Handlers) spoolEvent(w http.ResponseWriter, event Event, err error) {
+	l := logger.Get()
+
+	if h.spooler == nil || !isTransient(err) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.spooler.Spool([]Event{event}); err != nil {
+		l.Error().Err(err).Msgf("Error spooling %s event", event.Type)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	l.Warn().Msgf("Spooled %s event for replay", event.Type)
+	w.WriteHeader(http.StatusAccepted)
+}
+
 // getDomainFromOrigin returns the domain from the origin.
 func getDomainFromOrigin(origin string) string {
 	u, err := url.Parse(origin)
@@ -0,0 +1,153 @@
+package track
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// IP modes, for how a site stores the IP addresses of its events in ip_addresses_tb.
+const (
+	// IPModeDisabled doesn't store IP addresses
+	IPModeDisabled = "disabled"
+	// IPModeFull stores the whole address
+	IPModeFull = "full"
+	// IPModeTruncated stores the /24 network of IPv4 addresses and the /48 network of IPv6 addresses
+	IPModeTruncated = "truncated"
+	// IPModeHash stores an HMAC-SHA256 of the address keyed with a secret of the site
+	IPModeHash = "hash"
+)
+
+// IPPolicy is how a site stores the IP addresses of its events.
+// HashKey is the site's secret for IPModeHash, so hashes can't be reversed by hashing every address,
+// or linked between sites.
+type IPPolicy struct {
+	Mode    string
+	HashKey string
+}
+
+// Anonymise returns the form of the IP address stored under the policy,
+// or an empty string if it isn't stored or isn't a valid IP address.
+func (p IPPolicy) Anonymise(ipAddress string) string {
+	ip := net.ParseIP(ipAddress)
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	switch p.Mode {
+	case IPModeFull:
+		return ip.String()
+	case IPModeTruncated:
+		if len(ip) == net.IPv4len {
+			return ip.Mask(net.CIDRMask(24, 32)).String()
+		}
+		return ip.Mask(net.CIDRMask(48, 128)).String()
+	case IPModeHash:
+		if p.HashKey == "" {
+			return ""
+		}
+		mac := hmac.New(sha256.New, []byte(p.HashKey))
+		mac.Write([]byte(ip.String()))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	return ""
+}
+
+// ipPolicy returns the IP policy of a site's ip_mode and ip_hash_key columns, where an unset mode doesn't store IP addresses.
+func ipPolicy(mode, hashKey sql.NullString) IPPolicy {
+	policy := IPPolicy{Mode: mode.String, HashKey: hashKey.String}
+	if policy.Mode == "" {
+		policy.Mode = IPModeDisabled
+	}
+	return policy
+}
+
+func validateIPMode(mode string) error {
+	switch mode {
+	case IPModeDisabled, IPModeFull, IPModeTruncated, IPModeHash:
+		return nil
+	}
+	return fmt.Errorf("unknown IP mode %q, expected %s, %s, %s or %s", mode, IPModeDisabled, IPModeFull, IPModeTruncated, IPModeHash)
+}
+
+// newIPHashKey returns a random secret for hashing a site's IP addresses.
+func newIPHashKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// anonymiseClientIP replaces the client IP address of an event with the form stored under the site's IP policy,
+// so the raw address isn't held any longer than needed.
+func anonymiseClientIP(event *Event, policy IPPolicy) {
+	event.IPAddress = policy.Anonymise(event.ClientIP)
+	event.ClientIP = ""
+}
+
+// saveIPAddress stores the anonymised IP address of an event,
+// returning the ID of its ip_addresses_tb row, or 0 if the site doesn't store IP addresses.
+func saveIPAddress(ctx context.Context, repo RepositoryInterface, ipAddress string) (int, error) {
+	if ipAddress == "" {
+		return 0, nil
+	}
+
+	id, err := repo.SaveIPAddress(ctx, ipAddress)
+	return int(id), err
+}
+
+// queryIPAddressID returns the ID of a stored IP address, or 0 if it isn't stored.
+func queryIPAddressID(ctx context.Context, db *sql.DB, d dialect, ipAddress string) (int64, error) {
+	var id int64
+	err := db.QueryRowContext(ctx, d.rebind("SELECT id FROM ip_addresses_tb WHERE ip_address = ?"), ipAddress).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return id, err
+}
+
+// queryIPPolicy reads the IP policy of a domain, returning sql.ErrNoRows for unknown domains.
+func queryIPPolicy(ctx context.Context, db *sql.DB, d dialect, domainID int) (IPPolicy, error) {
+	var mode, hashKey sql.NullString
+	if err := db.QueryRowContext(ctx, d.rebind("SELECT ip_mode, ip_hash_key FROM domains_tb WHERE id = ?"), domainID).Scan(&mode, &hashKey); err != nil {
+		return IPPolicy{}, err
+	}
+	return ipPolicy(mode, hashKey), nil
+}
+
+// updateIPMode sets the IP mode of a domain, returning sql.ErrNoRows for unknown domains.
+// The domain's hash key is created the first time it is needed, and kept if the mode changes,
+// so hashes stay comparable if the site switches back.
+func updateIPMode(ctx context.Context, db *sql.DB, d dialect, domainID int, mode string) error {
+	if err := validateIPMode(mode); err != nil {
+		return err
+	}
+
+	return withTx(ctx, db, func(tx *sql.Tx) error {
+		var hashKey sql.NullString
+		if err := tx.QueryRowContext(ctx, d.rebind("SELECT ip_hash_key FROM domains_tb WHERE id = ?"), domainID).Scan(&hashKey); err != nil {
+			return err
+		}
+
+		if mode == IPModeHash && !hashKey.Valid {
+			key, err := newIPHashKey()
+			if err != nil {
+				return err
+			}
+			hashKey = sql.NullString{String: key, Valid: true}
+		}
+
+		_, err := tx.ExecContext(ctx, d.rebind("UPDATE domains_tb SET ip_mode = ?, ip_hash_key = ? WHERE id = ?"), mode, hashKey, domainID)
+		return err
+	})
+}
@@ -0,0 +1,231 @@
+package track
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jwtly10/simple-site-tracker/utils/logger"
+)
+
+// JavaScript error kinds, for how an error reached the script.
+const (
+	// ErrorKindError is an uncaught error, reported by window's error event.
+	ErrorKindError = "error"
+	// ErrorKindRejection is a promise rejected without a handler, reported by window's unhandledrejection event.
+	ErrorKindRejection = "unhandledrejection"
+)
+
+const (
+	// MaxErrorMessageLength is the longest error message stored. Longer messages are trimmed.
+	MaxErrorMessageLength = 512
+	// MaxErrorStackLength is the longest stack trace stored. Longer stacks are trimmed, as they are by the script.
+	MaxErrorStackLength = 2000
+	// maxErrorSourceLength is the longest script URL stored, matching the column size.
+	maxErrorSourceLength = 255
+)
+
+// JSError is a JavaScript error thrown on a page. The source is the URL of the script it was thrown in,
+// without its query string, and line and column are where in the script, or 0 if unknown.
+// Fingerprint groups identical errors: those of the same kind, with the same message, thrown at the same place.
+type JSError struct {
+	ErrorKind    string `json:"error_kind,omitempty"`
+	ErrorMessage string `json:"error_message,omitempty"`
+	ErrorSource  string `json:"error_source,omitempty"`
+	ErrorLine    int    `json:"error_line,omitempty"`
+	ErrorColumn  int    `json:"error_column,omitempty"`
+	ErrorStack   string `json:"error_stack,omitempty"`
+	Fingerprint  string `json:"fingerprint,omitempty"`
+}
+
+// JSErrorEvent is a JavaScript error row ready to be bulk inserted into js_errors_tb.
+type JSErrorEvent struct {
+	DomainID  int
+	PageID    int
+	CreatedAt time.Time
+	Visitor
+	JSError
+}
+
+// JSErrorGroup is the number of times an error was thrown, and on how many pages.
+type JSErrorGroup struct {
+	Fingerprint string
+	Kind        string
+	Message     string
+	Source      string
+	Line        int
+	Column      int
+	Count       int64
+	Pages       int64
+}
+
+// JSErrorCount is the number of times an error was thrown on a page in a browser.
+// Browser is empty for clients whose browser is unknown.
+type JSErrorCount struct {
+	Page    string
+	Browser string
+	Count   int64
+}
+
+// NewJSError trims the error's message and stack, drops the query string of its source and fingerprints it.
+// It returns an error if the kind is unknown, the message is empty or the line or column are negative.
+func NewJSError(kind, message, source string, line, column int, stack string) (JSError, error) {
+	if kind != ErrorKindError && kind != ErrorKindRejection {
+		return JSError{}, fmt.Errorf("invalid error kind %q", kind)
+	}
+	message = truncate(strings.TrimSpace(message), MaxErrorMessageLength)
+	if message == "" {
+		return JSError{}, fmt.Errorf("missing error message")
+	}
+	if line < 0 || column < 0 {
+		return JSError{}, fmt.Errorf("invalid error position %d:%d", line, column)
+	}
+
+	e := JSError{
+		ErrorKind:    kind,
+		ErrorMessage: message,
+		ErrorSource:  urlWithoutQuery(source, maxErrorSourceLength),
+		ErrorLine:    line,
+		ErrorColumn:  column,
+		ErrorStack:   truncate(strings.TrimSpace(stack), MaxErrorStackLength),
+	}
+	e.Fingerprint = errorFingerprint(e)
+	return e, nil
+}
+
+// errorFingerprint hashes the error's kind, message, source and position.
+// The stack isn't hashed, as browsers format it differently and it may have been trimmed.
+func errorFingerprint(e JSError) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		e.ErrorKind, e.ErrorMessage, e.ErrorSource, strconv.Itoa(e.ErrorLine), strconv.Itoa(e.ErrorColumn),
+	}, "\n")))
+	return hex.EncodeToString(sum[:16])
+}
+
+type TrackErrorRequest struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	Source  string `json:"source"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Stack   string `json:"stack"`
+	URL     string `json:"url"`
+}
+
+// TrackErrorHandler handles JavaScript errors thrown on a page.
+// It returns a 400 status code if the error is invalid.
+func (h *Handlers) TrackErrorHandler(w http.ResponseWriter, r *http.Request) {
+	l := logger.Get()
+	if r.Method != http.MethodPost {
+		l.Warn().Msgf("Invalid method: %s", r.Method)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var errorEvent TrackErrorRequest
+	if !decodeRequest(w, r, &errorEvent) {
+		return
+	}
+
+	jsError, err := NewJSError(errorEvent.Kind, errorEvent.Message, errorEvent.Source, errorEvent.Line, errorEvent.Column, errorEvent.Stack)
+	if err != nil {
+		l.Error().Msgf("Invalid JS error: %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		l.Error().Msg("Missing Origin header")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	h.handleEvent(w, r, Event{
+		Type:      EventError,
+		Domain:    getDomainFromOrigin(origin),
+		Page:      getPageFromURL(errorEvent.URL),
+		CreatedAt: time.Now(),
+		JSError:   jsError,
+	})
+}
+
+var jsErrorColumns = append(append([]string{"domain_id", "page_id", "created_at"}, visitorColumns...),
+	"fingerprint", "kind", "message", "source_url", "line_number", "column_number", "stack")
+
+// jsErrorsQuery builds a multi-row INSERT of the errors into js_errors_tb, returning its arguments.
+func jsErrorsQuery(d dialect, events []JSErrorEvent) (string, []interface{}) {
+	args := make([]interface{}, 0, len(events)*len(jsErrorColumns))
+	for _, e := range events {
+		args = append(args, e.DomainID, e.PageID, d.timeArg(e.CreatedAt))
+		args = append(args, visitorArgs(e.Visitor)...)
+		args = append(args, e.Fingerprint, e.ErrorKind, e.ErrorMessage, nullString(e.ErrorSource), e.ErrorLine, e.ErrorColumn, nullString(e.ErrorStack))
+	}
+
+	return bulkInsertQuery("js_errors_tb", jsErrorColumns, nil, len(events), d == dialectPostgres), args
+}
+
+// saveJSErrors saves the errors to js_errors_tb in a single transaction.
+func saveJSErrors(ctx context.Context, db *sql.DB, d dialect, events []JSErrorEvent) error {
+	return bulkInsert(ctx, db, d, events, len(jsErrorColumns), func(tx *sql.Tx, events []JSErrorEvent) error {
+		query, args := jsErrorsQuery(d, events)
+		_, err := tx.ExecContext(ctx, query, args...)
+		return err
+	})
+}
+
+// queryTopJSErrors groups a domain's errors thrown in [from, to) by fingerprint, most thrown first.
+func queryTopJSErrors(ctx context.Context, db *sql.DB, d dialect, domainID int, from, to time.Time) ([]JSErrorGroup, error) {
+	query := "SELECT fingerprint, kind, message, COALESCE(source_url, ''), line_number, column_number, " +
+		"COUNT(*) AS hits, COUNT(DISTINCT page_id) AS pages FROM js_errors_tb " +
+		"WHERE domain_id = ? AND created_at >= ? AND created_at < ? AND NOT is_bot " +
+		"GROUP BY fingerprint, kind, message, COALESCE(source_url, ''), line_number, column_number ORDER BY hits DESC, fingerprint"
+
+	rows, err := db.QueryContext(ctx, d.rebind(query), domainID, d.timeArg(from), d.timeArg(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []JSErrorGroup
+	for rows.Next() {
+		var g JSErrorGroup
+		if err := rows.Scan(&g.Fingerprint, &g.Kind, &g.Message, &g.Source, &g.Line, &g.Column, &g.Count, &g.Pages); err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+
+	return groups, rows.Err()
+}
+
+// queryJSErrorCounts counts the times an error with the fingerprint was thrown on a domain in [from, to)
+// by page and browser, most thrown first.
+func queryJSErrorCounts(ctx context.Context, db *sql.DB, d dialect, domainID int, fingerprint string, from, to time.Time) ([]JSErrorCount, error) {
+	query := "SELECT p.page_url, COALESCE(e.browser, ''), COUNT(*) AS hits FROM js_errors_tb e JOIN pages_tb p ON p.id = e.page_id " +
+		"WHERE e.domain_id = ? AND e.fingerprint = ? AND e.created_at >= ? AND e.created_at < ? AND NOT e.is_bot " +
+		"GROUP BY p.page_url, COALESCE(e.browser, '') ORDER BY hits DESC, p.page_url, COALESCE(e.browser, '')"
+
+	rows, err := db.QueryContext(ctx, d.rebind(query), domainID, fingerprint, d.timeArg(from), d.timeArg(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []JSErrorCount
+	for rows.Next() {
+		var count JSErrorCount
+		if err := rows.Scan(&count.Page, &count.Browser, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+
+	return counts, rows.Err()
+}
@@ -0,0 +1,79 @@
+package track
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lruCache is a fixed size, concurrency-safe cache which evicts the least recently used entry.
+type lruCache[K comparable, V any] struct {
+	mu      sync.Mutex
+	size    int
+	entries map[K]*list.Element
+	order   *list.List
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+func newLRUCache[K comparable, V any](size int) *lruCache[K, V] {
+	return &lruCache[K, V]{
+		size:    size,
+		entries: make(map[K]*list.Element, size),
+		order:   list.New(),
+	}
+}
+
+// Get returns the cached value for the key, marking it as recently used.
+func (c *lruCache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.order.MoveToFront(el)
+		return el.Value.(*lruEntry[K, V]).value, true
+	}
+
+	var zero V
+	return zero, false
+}
+
+// Add caches the value for the key, evicting the least recently used entry if the cache is full.
+func (c *lruCache[K, V]) Add(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*lruEntry[K, V]).value = value
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry[K, V]{key: key, value: value})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry[K, V]).key)
+	}
+}
+
+// Remove drops the key from the cache, if it is cached.
+func (c *lruCache[K, V]) Remove(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.order.Remove(el)
+		delete(c.entries, key)
+	}
+}
+
+// Len returns the number of cached entries.
+func (c *lruCache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
@@ -0,0 +1,1190 @@
+package track
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryDomain struct {
+	id        int
+	domain    string
+	siteKey   string
+	createdAt time.Time
+	retention RetentionPolicy
+	botMode   string
+	ipPolicy  IPPolicy
+}
+
+type memoryPage struct {
+	id        int
+	domainID  int
+	pageURL   string
+	createdAt time.Time
+}
+
+// PageViewRecord is a page view held by the MemoryRepository.
+type PageViewRecord struct {
+	ID        int64
+	DomainID  int
+	PageID    int
+	ViewID    string
+	CreatedAt time.Time
+	Visitor
+	Referrer
+	EngagedSeconds int
+	ScrollDepth    int
+}
+
+// UTMRecord is a UTM hit held by the MemoryRepository.
+type UTMRecord struct {
+	ID          int64
+	PageID      int
+	UTMSource   string
+	UTMMedium   string
+	UTMCampaign string
+	Track       string
+	CreatedAt   time.Time
+	Visitor
+}
+
+// ClickRecord is a click held by the MemoryRepository.
+type ClickRecord struct {
+	ID        int64
+	PageID    int
+	Element   map[string]interface{}
+	CreatedAt time.Time
+	Visitor
+	ClickTarget
+}
+
+// CustomEventRecord is a custom event held by the MemoryRepository.
+type CustomEventRecord struct {
+	ID        int64
+	DomainID  int
+	PageID    int
+	Name      string
+	Props     map[string]interface{}
+	CreatedAt time.Time
+	Visitor
+}
+
+// FormSubmissionRecord is a form submission held by the MemoryRepository.
+type FormSubmissionRecord struct {
+	ID        int64
+	DomainID  int
+	PageID    int
+	CreatedAt time.Time
+	Visitor
+	Form
+}
+
+// JSErrorRecord is a JavaScript error held by the MemoryRepository.
+type JSErrorRecord struct {
+	ID        int64
+	DomainID  int
+	PageID    int
+	CreatedAt time.Time
+	Visitor
+	JSError
+}
+
+// MemoryRepository is a concurrency-safe, in-memory RepositoryInterface.
+// Nothing is persisted, so it is intended for local development, demos and tests.
+type MemoryRepository struct {
+	mu sync.RWMutex
+
+	domains     []memoryDomain
+	pages       []memoryPage
+	ipAddresses map[string]int64
+	pageViews   []PageViewRecord
+	utms        []UTMRecord
+	clicks      []ClickRecord
+	events      []CustomEventRecord
+	forms       []FormSubmissionRecord
+	jsErrors    []JSErrorRecord
+
+	visitorSalts map[string]string
+
+	pageViewsHourly map[pageViewBucket]int
+	pageViewsDaily  map[pageViewBucket]int
+	utmsDaily       map[utmBucket]int
+
+	// Event IDs come from sequences, as expired events can be deleted
+	pageViewSeq int64
+	utmSeq      int64
+	clickSeq    int64
+	eventSeq    int64
+	formSeq     int64
+	jsErrorSeq  int64
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		ipAddresses:     make(map[string]int64),
+		visitorSalts:    make(map[string]string),
+		pageViewsHourly: make(map[pageViewBucket]int),
+		pageViewsDaily:  make(map[pageViewBucket]int),
+		utmsDaily:       make(map[utmBucket]int),
+	}
+}
+
+// SavePageView saves a new page view.
+func (repo *MemoryRepository) SavePageView(ctx context.Context, domainId, pageId int, viewID string, visitor Visitor, referrer Referrer) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.pageViewSeq++
+	id := repo.pageViewSeq
+	pv := PageViewRecord{ID: id, DomainID: domainId, PageID: pageId, ViewID: viewID, CreatedAt: time.Now(), Visitor: visitor, Referrer: referrer}
+	repo.pageViews = append(repo.pageViews, pv)
+	repo.addPageViewRollups([]PageView{{DomainID: pv.DomainID, PageID: pv.PageID, CreatedAt: pv.CreatedAt, Visitor: pv.Visitor}})
+
+	return id, nil
+}
+
+// SaveDomain saves a new domain. Domains are unique, as in domains_tb.
+func (repo *MemoryRepository) SaveDomain(ctx context.Context, domain, key string) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, d := range repo.domains {
+		if d.domain == domain {
+			return 0, fmt.Errorf("domain %s already exists", domain)
+		}
+	}
+
+	id := len(repo.domains) + 1
+	repo.domains = append(repo.domains, memoryDomain{id: id, domain: domain, siteKey: key, createdAt: time.Now()})
+
+	return int64(id), nil
+}
+
+// GetDomain returns the ID of the domain, or sql.ErrNoRows if it does not exist.
+func (repo *MemoryRepository) GetDomain(ctx context.Context, domain string) (int, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	for _, d := range repo.domains {
+		if d.domain == domain {
+			return d.id, nil
+		}
+	}
+
+	return 0, sql.ErrNoRows
+}
+
+// GetDomainIDFromKey returns the ID of the domain given the key, or 0 if it does not exist.
+func (repo *MemoryRepository) GetDomainIDFromKey(ctx context.Context, key string) (int, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	for _, d := range repo.domains {
+		if d.siteKey == key {
+			return d.id, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// GetDomainKeyPair returns the key of the domain, or sql.ErrNoRows if it does not exist.
+func (repo *MemoryRepository) GetDomainKeyPair(ctx context.Context, domain string) (DomainKeyPair, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	for _, d := range repo.domains {
+		if d.domain == domain {
+			return DomainKeyPair{Domain: d.domain, SiteKey: d.siteKey}, nil
+		}
+	}
+
+	return DomainKeyPair{}, sql.ErrNoRows
+}
+
+// GetPage returns the ID of the page, or 0 if it does not exist.
+func (repo *MemoryRepository) GetPage(ctx context.Context, domainID int, pageURL string) (int, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	return repo.findPage(domainID, pageURL), nil
+}
+
+// CreatePage saves a new page. Pages are unique per domain, as in pages_tb.
+func (repo *MemoryRepository) CreatePage(ctx context.Context, domainID int, pageURL string) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.findPage(domainID, pageURL) != 0 {
+		return 0, fmt.Errorf("page %s already exists", pageURL)
+	}
+
+	return int64(repo.createPage(domainID, pageURL)), nil
+}
+
+// GetOrCreatePage returns the ID of the page, creating it if it doesn't exist.
+func (repo *MemoryRepository) GetOrCreatePage(ctx context.Context, domainID int, pageURL string) (int, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if id := repo.findPage(domainID, pageURL); id != 0 {
+		return id, nil
+	}
+
+	return repo.createPage(domainID, pageURL), nil
+}
+
+// findPage returns the ID of the page, or 0 if it does not exist. repo.mu must be held.
+func (repo *MemoryRepository) findPage(domainID int, pageURL string) int {
+	for _, p := range repo.pages {
+		if p.domainID == domainID && p.pageURL == pageURL {
+			return p.id
+		}
+	}
+	return 0
+}
+
+// createPage saves a new page and returns its ID. repo.mu must be held.
+func (repo *MemoryRepository) createPage(domainID int, pageURL string) int {
+	id := len(repo.pages) + 1
+	repo.pages = append(repo.pages, memoryPage{id: id, domainID: domainID, pageURL: pageURL, createdAt: time.Now()})
+	return id
+}
+
+// SaveIPAddress saves an IP address unless it is already stored, and returns its ID.
+// IP addresses are unique, as in ip_addresses_tb.
+func (repo *MemoryRepository) SaveIPAddress(ctx context.Context, ipAddress string) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if id, ok := repo.ipAddresses[ipAddress]; ok {
+		return id, nil
+	}
+
+	id := int64(len(repo.ipAddresses) + 1)
+	repo.ipAddresses[ipAddress] = id
+
+	return id, nil
+}
+
+// SaveUTM saves a new UTM req.
+func (repo *MemoryRepository) SaveUTM(ctx context.Context, pageID int, utmSource, utmMedium, utmCampaign, track string, visitor Visitor) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.utmSeq++
+	id := repo.utmSeq
+	u := UTMRecord{
+		ID:          id,
+		PageID:      pageID,
+		UTMSource:   utmSource,
+		UTMMedium:   utmMedium,
+		UTMCampaign: utmCampaign,
+		Track:       track,
+		CreatedAt:   time.Now(),
+		Visitor:     visitor,
+	}
+	repo.utms = append(repo.utms, u)
+	repo.addUTMRollups([]UTM{repo.utmRow(u)})
+
+	return id, nil
+}
+
+// SaveClick saves a new click.
+func (repo *MemoryRepository) SaveClick(ctx context.Context, pageID int, element map[string]interface{}, visitor Visitor, target ClickTarget) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.clickSeq++
+	id := repo.clickSeq
+	repo.clicks = append(repo.clicks, ClickRecord{ID: id, PageID: pageID, Element: element, CreatedAt: time.Now(), Visitor: visitor, ClickTarget: target})
+
+	return id, nil
+}
+
+// SavePageViews saves a batch of page views.
+func (repo *MemoryRepository) SavePageViews(ctx context.Context, pageViews []PageView) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, pv := range pageViews {
+		repo.pageViewSeq++
+		id := repo.pageViewSeq
+		repo.pageViews = append(repo.pageViews, PageViewRecord{ID: id, DomainID: pv.DomainID, PageID: pv.PageID, ViewID: pv.ViewID, CreatedAt: pv.CreatedAt, Visitor: pv.Visitor, Referrer: pv.Referrer})
+	}
+	repo.addPageViewRollups(pageViews)
+
+	return nil
+}
+
+// SaveClicks saves a batch of clicks.
+func (repo *MemoryRepository) SaveClicks(ctx context.Context, clicks []Click) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, c := range clicks {
+		repo.clickSeq++
+		id := repo.clickSeq
+		repo.clicks = append(repo.clicks, ClickRecord{ID: id, PageID: c.PageID, Element: c.Element, CreatedAt: c.CreatedAt, Visitor: c.Visitor, ClickTarget: c.ClickTarget})
+	}
+
+	return nil
+}
+
+// SaveUTMs saves a batch of UTM reqs.
+func (repo *MemoryRepository) SaveUTMs(ctx context.Context, utms []UTM) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, u := range utms {
+		repo.utmSeq++
+		id := repo.utmSeq
+		repo.utms = append(repo.utms, UTMRecord{
+			ID:          id,
+			PageID:      u.PageID,
+			UTMSource:   u.UTMSource,
+			UTMMedium:   u.UTMMedium,
+			UTMCampaign: u.UTMCampaign,
+			Track:       u.Track,
+			CreatedAt:   u.CreatedAt,
+			Visitor:     u.Visitor,
+		})
+	}
+	repo.addUTMRollups(utms)
+
+	return nil
+}
+
+// GetRetentionPolicies returns the retention policy of every domain.
+func (repo *MemoryRepository) GetRetentionPolicies(ctx context.Context) ([]RetentionPolicy, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	policies := make([]RetentionPolicy, 0, len(repo.domains))
+	for _, d := range repo.domains {
+		policy := d.retention
+		policy.DomainID = d.id
+		policy.Domain = d.domain
+		policies = append(policies, policy)
+	}
+
+	return policies, nil
+}
+
+// SetRetentionPolicy updates the retention policy of a domain.
+func (repo *MemoryRepository) SetRetentionPolicy(ctx context.Context, policy RetentionPolicy) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for i := range repo.domains {
+		if repo.domains[i].id == policy.DomainID {
+			repo.domains[i].retention = policy
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+// GetBotMode returns how a domain handles events from bots, dropping them unless it has been set to flag them.
+func (repo *MemoryRepository) GetBotMode(ctx context.Context, domainID int) (string, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	for _, d := range repo.domains {
+		if d.id == domainID {
+			return botMode(d.botMode), nil
+		}
+	}
+
+	return "", sql.ErrNoRows
+}
+
+// SetBotMode sets whether a domain drops or flags events from bots.
+func (repo *MemoryRepository) SetBotMode(ctx context.Context, domainID int, mode string) error {
+	if err := validateBotMode(mode); err != nil {
+		return err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for i := range repo.domains {
+		if repo.domains[i].id == domainID {
+			repo.domains[i].botMode = mode
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+// GetIPPolicy returns how a domain stores the IP addresses of its events, not storing them unless it has been set to.
+func (repo *MemoryRepository) GetIPPolicy(ctx context.Context, domainID int) (IPPolicy, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	for _, d := range repo.domains {
+		if d.id == domainID {
+			policy := d.ipPolicy
+			if policy.Mode == "" {
+				policy.Mode = IPModeDisabled
+			}
+			return policy, nil
+		}
+	}
+
+	return IPPolicy{}, sql.ErrNoRows
+}
+
+// SetIPMode sets how a domain stores the IP addresses of its events, creating its hash key the first time it is needed.
+func (repo *MemoryRepository) SetIPMode(ctx context.Context, domainID int, mode string) error {
+	if err := validateIPMode(mode); err != nil {
+		return err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for i := range repo.domains {
+		if repo.domains[i].id != domainID {
+			continue
+		}
+
+		policy := &repo.domains[i].ipPolicy
+		if mode == IPModeHash && policy.HashKey == "" {
+			key, err := newIPHashKey()
+			if err != nil {
+				return err
+			}
+			policy.HashKey = key
+		}
+		policy.Mode = mode
+		return nil
+	}
+
+	return sql.ErrNoRows
+}
+
+// DeleteExpired deletes up to limit events of the given type created before the cutoff for a domain.
+func (repo *MemoryRepository) DeleteExpired(ctx context.Context, eventType EventType, domainID int, before time.Time, limit int) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	domainPages := make(map[int]bool)
+	for _, p := range repo.pages {
+		if p.domainID == domainID {
+			domainPages[p.id] = true
+		}
+	}
+
+	var deleted int64
+	expired := func(pageID int, createdAt time.Time) bool {
+		if deleted < int64(limit) && domainPages[pageID] && createdAt.Before(before) {
+			deleted++
+			return true
+		}
+		return false
+	}
+
+	switch eventType {
+	case EventPageView:
+		repo.pageViews = deleteRecords(repo.pageViews, func(pv PageViewRecord) bool { return expired(pv.PageID, pv.CreatedAt) })
+	case EventClick:
+		repo.clicks = deleteRecords(repo.clicks, func(c ClickRecord) bool { return expired(c.PageID, c.CreatedAt) })
+	case EventUTM:
+		repo.utms = deleteRecords(repo.utms, func(u UTMRecord) bool { return expired(u.PageID, u.CreatedAt) })
+	case EventCustom:
+		repo.events = deleteRecords(repo.events, func(e CustomEventRecord) bool { return expired(e.PageID, e.CreatedAt) })
+	case EventForm:
+		repo.forms = deleteRecords(repo.forms, func(f FormSubmissionRecord) bool { return expired(f.PageID, f.CreatedAt) })
+	case EventError:
+		repo.jsErrors = deleteRecords(repo.jsErrors, func(e JSErrorRecord) bool { return expired(e.PageID, e.CreatedAt) })
+	default:
+		return 0, fmt.Errorf("unknown event type %s", eventType)
+	}
+
+	return deleted, nil
+}
+
+// RebuildRollups recalculates the rollups for the UTC days in [from, to) from the stored events.
+func (repo *MemoryRepository) RebuildRollups(ctx context.Context, from, to time.Time) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	from = from.UTC().Truncate(24 * time.Hour)
+	to = to.UTC().Truncate(24 * time.Hour)
+	fromDay, toDay := from.Format(rollupDayFormat), to.Format(rollupDayFormat)
+	inRange := func(day string) bool { return day >= fromDay && day < toDay }
+
+	for key := range repo.pageViewsHourly {
+		if inRange(key.bucket[:len(rollupDayFormat)]) {
+			delete(repo.pageViewsHourly, key)
+		}
+	}
+	for key := range repo.pageViewsDaily {
+		if inRange(key.bucket) {
+			delete(repo.pageViewsDaily, key)
+		}
+	}
+	for key := range repo.utmsDaily {
+		if inRange(key.day) {
+			delete(repo.utmsDaily, key)
+		}
+	}
+
+	var pageViews []PageView
+	for _, pv := range repo.pageViews {
+		if !pv.CreatedAt.Before(from) && pv.CreatedAt.Before(to) {
+			pageViews = append(pageViews, PageView{DomainID: pv.DomainID, PageID: pv.PageID, CreatedAt: pv.CreatedAt, Visitor: pv.Visitor})
+		}
+	}
+	repo.addPageViewRollups(pageViews)
+
+	var utms []UTM
+	for _, u := range repo.utms {
+		if !u.CreatedAt.Before(from) && u.CreatedAt.Before(to) {
+			utms = append(utms, repo.utmRow(u))
+		}
+	}
+	repo.addUTMRollups(utms)
+
+	return nil
+}
+
+// SaveCustomEvent saves a new custom event.
+func (repo *MemoryRepository) SaveCustomEvent(ctx context.Context, domainID, pageID int, name string, props map[string]interface{}, visitor Visitor) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.eventSeq++
+	id := repo.eventSeq
+	repo.events = append(repo.events, CustomEventRecord{ID: id, DomainID: domainID, PageID: pageID, Name: name, Props: props, CreatedAt: time.Now(), Visitor: visitor})
+
+	return id, nil
+}
+
+// SaveCustomEvents saves a batch of custom events.
+func (repo *MemoryRepository) SaveCustomEvents(ctx context.Context, events []CustomEvent) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, e := range events {
+		repo.eventSeq++
+		id := repo.eventSeq
+		repo.events = append(repo.events, CustomEventRecord{ID: id, DomainID: e.DomainID, PageID: e.PageID, Name: e.Name, Props: e.Props, CreatedAt: e.CreatedAt, Visitor: e.Visitor})
+	}
+
+	return nil
+}
+
+// GetEventPropertyBreakdown counts the custom events with the name for a domain created in [from, to)
+// by the value of the property, most common first.
+func (repo *MemoryRepository) GetEventPropertyBreakdown(ctx context.Context, domainID int, name, property string, from, to time.Time) ([]EventPropertyCount, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	hits := make(map[string]int64)
+	for _, e := range repo.events {
+		if e.DomainID != domainID || e.Name != name || e.CreatedAt.Before(from) || !e.CreatedAt.Before(to) || e.Bot {
+			continue
+		}
+		if value, ok := eventPropertyValue(e.Props[property]); ok {
+			hits[value]++
+		}
+	}
+
+	var counts []EventPropertyCount
+	for value, count := range hits {
+		counts = append(counts, EventPropertyCount{Value: value, Count: count})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Value < counts[j].Value
+	})
+
+	return counts, nil
+}
+
+// GetOrCreateVisitorSalt stores the visitor salt for the UTC day unless one already is, and returns the stored salt.
+// The salts of earlier days are deleted.
+func (repo *MemoryRepository) GetOrCreateVisitorSalt(ctx context.Context, day, salt string) (string, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.visitorSalts[day]; !ok {
+		repo.visitorSalts[day] = salt
+	}
+	for d := range repo.visitorSalts {
+		if d < day {
+			delete(repo.visitorSalts, d)
+		}
+	}
+
+	return repo.visitorSalts[day], nil
+}
+
+// GetVisitorCounts counts the page views, visitors and sessions of a domain created in [from, to).
+func (repo *MemoryRepository) GetVisitorCounts(ctx context.Context, domainID int, from, to time.Time) (VisitorCounts, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	var counts VisitorCounts
+	visitors := make(map[string]bool)
+	sessions := make(map[string]bool)
+	for _, pv := range repo.pageViews {
+		if pv.DomainID != domainID || pv.CreatedAt.Before(from) || !pv.CreatedAt.Before(to) || pv.Bot {
+			continue
+		}
+		counts.PageViews++
+		if pv.VisitorID != "" {
+			visitors[pv.VisitorID] = true
+		}
+		if pv.SessionID != "" {
+			sessions[pv.SessionID] = true
+		}
+	}
+	counts.Visitors = int64(len(visitors))
+	counts.Sessions = int64(len(sessions))
+
+	return counts, nil
+}
+
+// GetReferrerCounts counts the page views of a domain created in [from, to) by referrer channel and host.
+func (repo *MemoryRepository) GetReferrerCounts(ctx context.Context, domainID int, from, to time.Time) ([]ReferrerCount, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	hits := make(map[ReferrerCount]int64)
+	for _, pv := range repo.pageViews {
+		if pv.DomainID != domainID || pv.CreatedAt.Before(from) || !pv.CreatedAt.Before(to) || pv.ReferrerChannel == "" || pv.Bot {
+			continue
+		}
+		hits[ReferrerCount{Channel: pv.ReferrerChannel, Host: pv.ReferrerHost}]++
+	}
+
+	var counts []ReferrerCount
+	for key, count := range hits {
+		key.Count = count
+		counts = append(counts, key)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		if counts[i].Channel != counts[j].Channel {
+			return counts[i].Channel < counts[j].Channel
+		}
+		return counts[i].Host < counts[j].Host
+	})
+
+	return counts, nil
+}
+
+// GetDimensionCounts counts the page views of a domain created in [from, to) by browser, browser version, OS, device, country, region or city.
+func (repo *MemoryRepository) GetDimensionCounts(ctx context.Context, domainID int, dimension string, from, to time.Time) ([]DimensionCount, error) {
+	if _, err := dimensionColumns(dimension); err != nil {
+		return nil, err
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	hits := make(map[string]int64)
+	for _, pv := range repo.pageViews {
+		if pv.DomainID != domainID || pv.CreatedAt.Before(from) || !pv.CreatedAt.Before(to) || pv.Bot {
+			continue
+		}
+		switch dimension {
+		case DimensionBrowser:
+			hits[pv.Browser]++
+		case DimensionBrowserVersion:
+			hits[dimensionValue(dimension, pv.Browser, pv.BrowserVersion)]++
+		case DimensionOS:
+			hits[pv.OS]++
+		case DimensionDevice:
+			hits[pv.Device]++
+		case DimensionCountry:
+			hits[pv.Country]++
+		case DimensionRegion:
+			hits[dimensionValue(dimension, pv.Region, pv.Country)]++
+		case DimensionCity:
+			hits[dimensionValue(dimension, pv.City, pv.Region, pv.Country)]++
+		}
+	}
+
+	var counts []DimensionCount
+	for value, count := range hits {
+		counts = append(counts, DimensionCount{Value: value, Count: count})
+	}
+	sortDimensionCounts(counts)
+
+	return counts, nil
+}
+
+// AddEngagements adds the engaged time of each engagement to its page view, ignoring page views which don't exist.
+func (repo *MemoryRepository) AddEngagements(ctx context.Context, engagements []Engagement) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, e := range engagements {
+		for i := range repo.pageViews {
+			if repo.pageViews[i].DomainID == e.DomainID && repo.pageViews[i].ViewID == e.ViewID {
+				repo.pageViews[i].EngagedSeconds += e.Seconds
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetPageEngagement averages the engaged time of a domain's page views created in [from, to) by path.
+func (repo *MemoryRepository) GetPageEngagement(ctx context.Context, domainID int, from, to time.Time) ([]PageEngagement, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	pages := make(map[int]*PageEngagement)
+	for _, pv := range repo.pageViews {
+		if pv.DomainID != domainID || pv.CreatedAt.Before(from) || !pv.CreatedAt.Before(to) || pv.ViewID == "" || pv.Bot {
+			continue
+		}
+		page, ok := pages[pv.PageID]
+		if !ok {
+			page = &PageEngagement{Page: repo.pageURL(pv.PageID)}
+			pages[pv.PageID] = page
+		}
+		page.Views++
+		page.EngagedSeconds += int64(pv.EngagedSeconds)
+	}
+
+	var engagement []PageEngagement
+	for _, page := range pages {
+		page.AverageSeconds = float64(page.EngagedSeconds) / float64(page.Views)
+		engagement = append(engagement, *page)
+	}
+	sortPageEngagement(engagement)
+
+	return engagement, nil
+}
+
+// SaveScrollDepths keeps the depth of each scroll on its page view, unless it has scrolled deeper, ignoring page views which don't exist.
+func (repo *MemoryRepository) SaveScrollDepths(ctx context.Context, scrolls []ScrollDepth) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, s := range scrolls {
+		for i := range repo.pageViews {
+			if repo.pageViews[i].DomainID == s.DomainID && repo.pageViews[i].ViewID == s.ViewID && repo.pageViews[i].ScrollDepth < s.Depth {
+				repo.pageViews[i].ScrollDepth = s.Depth
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetScrollDepths counts a domain's page views created in [from, to) by path and the deepest scroll depth they reached.
+func (repo *MemoryRepository) GetScrollDepths(ctx context.Context, domainID int, from, to time.Time) ([]PageScrollDepth, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	pages := make(map[string]*PageScrollDepth)
+	for _, pv := range repo.pageViews {
+		if pv.DomainID != domainID || pv.CreatedAt.Before(from) || !pv.CreatedAt.Before(to) || pv.ViewID == "" || pv.Bot {
+			continue
+		}
+		addScrollDepth(pages, repo.pageURL(pv.PageID), pv.ScrollDepth, 1)
+	}
+
+	return sortedScrollDepths(pages), nil
+}
+
+// GetClickTargets counts the clicks of a class on a domain's pages created in [from, to) by target, most clicked first.
+func (repo *MemoryRepository) GetClickTargets(ctx context.Context, domainID int, class string, from, to time.Time) ([]ClickTargetCount, error) {
+	if err := validateClickClass(class); err != nil {
+		return nil, err
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	domainPages := make(map[int]bool)
+	for _, p := range repo.pages {
+		if p.domainID == domainID {
+			domainPages[p.id] = true
+		}
+	}
+
+	hits := make(map[ClickTargetCount]int64)
+	for _, c := range repo.clicks {
+		if !domainPages[c.PageID] || c.ClickClass != class || c.CreatedAt.Before(from) || !c.CreatedAt.Before(to) || c.Bot {
+			continue
+		}
+		key := ClickTargetCount{Host: c.TargetHost, Path: c.TargetPath}
+		if class == ClickOutbound {
+			key.Path = ""
+		}
+		hits[key]++
+	}
+
+	var counts []ClickTargetCount
+	for key, count := range hits {
+		key.Count = count
+		counts = append(counts, key)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		if counts[i].Host != counts[j].Host {
+			return counts[i].Host < counts[j].Host
+		}
+		return counts[i].Path < counts[j].Path
+	})
+
+	return counts, nil
+}
+
+// SaveFormSubmission saves a new form submission.
+func (repo *MemoryRepository) SaveFormSubmission(ctx context.Context, domainID, pageID int, form Form, visitor Visitor) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.formSeq++
+	id := repo.formSeq
+	repo.forms = append(repo.forms, FormSubmissionRecord{ID: id, DomainID: domainID, PageID: pageID, CreatedAt: time.Now(), Visitor: visitor, Form: form})
+
+	return id, nil
+}
+
+// SaveFormSubmissions saves a batch of form submissions.
+func (repo *MemoryRepository) SaveFormSubmissions(ctx context.Context, submissions []FormSubmission) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, s := range submissions {
+		repo.formSeq++
+		id := repo.formSeq
+		repo.forms = append(repo.forms, FormSubmissionRecord{ID: id, DomainID: s.DomainID, PageID: s.PageID, CreatedAt: s.CreatedAt, Visitor: s.Visitor, Form: s.Form})
+	}
+
+	return nil
+}
+
+// GetFormSubmissionCounts counts a domain's form submissions created in [from, to) by page and form, most submitted first.
+func (repo *MemoryRepository) GetFormSubmissionCounts(ctx context.Context, domainID int, from, to time.Time) ([]FormSubmissionCount, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	hits := make(map[FormSubmissionCount]int64)
+	for _, f := range repo.forms {
+		if f.DomainID != domainID || f.CreatedAt.Before(from) || !f.CreatedAt.Before(to) || f.Bot {
+			continue
+		}
+		hits[FormSubmissionCount{Page: repo.pageURL(f.PageID), FormID: f.FormID, FormName: f.FormName, FormAction: f.FormAction}]++
+	}
+
+	var counts []FormSubmissionCount
+	for key, count := range hits {
+		key.Count = count
+		counts = append(counts, key)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		a, b := counts[i], counts[j]
+		switch {
+		case a.Count != b.Count:
+			return a.Count > b.Count
+		case a.Page != b.Page:
+			return a.Page < b.Page
+		case a.FormID != b.FormID:
+			return a.FormID < b.FormID
+		case a.FormName != b.FormName:
+			return a.FormName < b.FormName
+		}
+		return a.FormAction < b.FormAction
+	})
+
+	return counts, nil
+}
+
+// SaveJSError saves a new JavaScript error.
+func (repo *MemoryRepository) SaveJSError(ctx context.Context, domainID, pageID int, jsError JSError, visitor Visitor) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.jsErrorSeq++
+	id := repo.jsErrorSeq
+	repo.jsErrors = append(repo.jsErrors, JSErrorRecord{ID: id, DomainID: domainID, PageID: pageID, CreatedAt: time.Now(), Visitor: visitor, JSError: jsError})
+
+	return id, nil
+}
+
+// SaveJSErrors saves a batch of JavaScript errors.
+func (repo *MemoryRepository) SaveJSErrors(ctx context.Context, events []JSErrorEvent) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, e := range events {
+		repo.jsErrorSeq++
+		id := repo.jsErrorSeq
+		repo.jsErrors = append(repo.jsErrors, JSErrorRecord{ID: id, DomainID: e.DomainID, PageID: e.PageID, CreatedAt: e.CreatedAt, Visitor: e.Visitor, JSError: e.JSError})
+	}
+
+	return nil
+}
+
+// GetTopJSErrors groups a domain's JavaScript errors thrown in [from, to) by fingerprint, most thrown first.
+func (repo *MemoryRepository) GetTopJSErrors(ctx context.Context, domainID int, from, to time.Time) ([]JSErrorGroup, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	groups := make(map[string]*JSErrorGroup)
+	pages := make(map[string]map[int]bool)
+	for _, e := range repo.jsErrors {
+		if e.DomainID != domainID || e.CreatedAt.Before(from) || !e.CreatedAt.Before(to) || e.Bot {
+			continue
+		}
+		g, ok := groups[e.Fingerprint]
+		if !ok {
+			g = &JSErrorGroup{
+				Fingerprint: e.Fingerprint,
+				Kind:        e.ErrorKind,
+				Message:     e.ErrorMessage,
+				Source:      e.ErrorSource,
+				Line:        e.ErrorLine,
+				Column:      e.ErrorColumn,
+			}
+			groups[e.Fingerprint] = g
+			pages[e.Fingerprint] = make(map[int]bool)
+		}
+		g.Count++
+		pages[e.Fingerprint][e.PageID] = true
+	}
+
+	var top []JSErrorGroup
+	for fingerprint, g := range groups {
+		g.Pages = int64(len(pages[fingerprint]))
+		top = append(top, *g)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Fingerprint < top[j].Fingerprint
+	})
+
+	return top, nil
+}
+
+// GetJSErrorCounts counts the times a JavaScript error was thrown on a domain in [from, to) by page and browser.
+func (repo *MemoryRepository) GetJSErrorCounts(ctx context.Context, domainID int, fingerprint string, from, to time.Time) ([]JSErrorCount, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	hits := make(map[JSErrorCount]int64)
+	for _, e := range repo.jsErrors {
+		if e.DomainID != domainID || e.Fingerprint != fingerprint || e.CreatedAt.Before(from) || !e.CreatedAt.Before(to) || e.Bot {
+			continue
+		}
+		hits[JSErrorCount{Page: repo.pageURL(e.PageID), Browser: e.Browser}]++
+	}
+
+	var counts []JSErrorCount
+	for key, count := range hits {
+		key.Count = count
+		counts = append(counts, key)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		if counts[i].Page != counts[j].Page {
+			return counts[i].Page < counts[j].Page
+		}
+		return counts[i].Browser < counts[j].Browser
+	})
+
+	return counts, nil
+}
+
+// pageURL returns the URL of a page, or an empty string if it doesn't exist. repo.mu must be held.
+func (repo *MemoryRepository) pageURL(pageID int) string {
+	for _, p := range repo.pages {
+		if p.id == pageID {
+			return p.pageURL
+		}
+	}
+	return ""
+}
+
+// addPageViewRollups adds the page views onto the hourly and daily rollups. repo.mu must be held.
+func (repo *MemoryRepository) addPageViewRollups(pageViews []PageView) {
+	hourly, daily := rollupPageViews(pageViews)
+	for key, views := range hourly {
+		repo.pageViewsHourly[key] += views
+	}
+	for key, views := range daily {
+		repo.pageViewsDaily[key] += views
+	}
+}
+
+// addUTMRollups adds the UTM hits onto the daily rollup. repo.mu must be held.
+func (repo *MemoryRepository) addUTMRollups(utms []UTM) {
+	for key, hits := range rollupUTMs(utms) {
+		repo.utmsDaily[key] += hits
+	}
+}
+
+// utmRow converts a stored UTM hit to a row, looking up its domain from the page. repo.mu must be held.
+func (repo *MemoryRepository) utmRow(u UTMRecord) UTM {
+	row := UTM{PageID: u.PageID, UTMSource: u.UTMSource, UTMMedium: u.UTMMedium, UTMCampaign: u.UTMCampaign, Track: u.Track, CreatedAt: u.CreatedAt, Visitor: u.Visitor}
+	for _, p := range repo.pages {
+		if p.id == u.PageID {
+			row.DomainID = p.domainID
+			break
+		}
+	}
+	return row
+}
+
+// deleteRecords removes the records matching del, preserving the order of the rest.
+func deleteRecords[T any](records []T, del func(T) bool) []T {
+	kept := records[:0]
+	for _, r := range records {
+		if !del(r) {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// IPAddress returns the stored IP address with the ID, or an empty string if there isn't one.
+func (repo *MemoryRepository) IPAddress(id int) string {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	for ipAddress, ipID := range repo.ipAddresses {
+		if int(ipID) == id {
+			return ipAddress
+		}
+	}
+	return ""
+}
+
+// PageViews returns a copy of the stored page views.
+func (repo *MemoryRepository) PageViews() []PageViewRecord {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	return append([]PageViewRecord(nil), repo.pageViews...)
+}
+
+// UTMs returns a copy of the stored UTM hits.
+func (repo *MemoryRepository) UTMs() []UTMRecord {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	return append([]UTMRecord(nil), repo.utms...)
+}
+
+// Clicks returns a copy of the stored clicks.
+func (repo *MemoryRepository) Clicks() []ClickRecord {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	return append([]ClickRecord(nil), repo.clicks...)
+}
+
+// CustomEvents returns a copy of the stored custom events.
+func (repo *MemoryRepository) CustomEvents() []CustomEventRecord {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	return append([]CustomEventRecord(nil), repo.events...)
+}
+
+// FormSubmissions returns a copy of the stored form submissions.
+func (repo *MemoryRepository) FormSubmissions() []FormSubmissionRecord {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	return append([]FormSubmissionRecord(nil), repo.forms...)
+}
+
+// JSErrors returns a copy of the stored JavaScript errors.
+func (repo *MemoryRepository) JSErrors() []JSErrorRecord {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	return append([]JSErrorRecord(nil), repo.jsErrors...)
+}
+
+// HourlyPageViews returns the hourly page view rollups, oldest first.
+func (repo *MemoryRepository) HourlyPageViews() []PageViewRollup {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	return pageViewRollups(repo.pageViewsHourly)
+}
+
+// DailyPageViews returns the daily page view rollups, oldest first.
+func (repo *MemoryRepository) DailyPageViews() []PageViewRollup {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	return pageViewRollups(repo.pageViewsDaily)
+}
+
+// DailyUTMs returns the daily UTM rollups, oldest first.
+func (repo *MemoryRepository) DailyUTMs() []UTMRollup {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	rollups := make([]UTMRollup, 0, len(repo.utmsDaily))
+	for key, hits := range repo.utmsDaily {
+		rollups = append(rollups, UTMRollup{
+			DomainID:    key.domainID,
+			Day:         key.day,
+			UTMCampaign: key.campaign,
+			UTMSource:   key.source,
+			UTMMedium:   key.medium,
+			Hits:        hits,
+		})
+	}
+	sort.Slice(rollups, func(i, j int) bool {
+		a, b := rollups[i], rollups[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.DomainID != b.DomainID {
+			return a.DomainID < b.DomainID
+		}
+		return a.UTMCampaign+"\x00"+a.UTMSource+"\x00"+a.UTMMedium < b.UTMCampaign+"\x00"+b.UTMSource+"\x00"+b.UTMMedium
+	})
+
+	return rollups
+}
+
+func pageViewRollups(buckets map[pageViewBucket]int) []PageViewRollup {
+	rollups := make([]PageViewRollup, 0, len(buckets))
+	for key, views := range buckets {
+		rollups = append(rollups, PageViewRollup{DomainID: key.domainID, PageID: key.pageID, Bucket: key.bucket, Views: views})
+	}
+	sort.Slice(rollups, func(i, j int) bool {
+		a, b := rollups[i], rollups[j]
+		if a.Bucket != b.Bucket {
+			return a.Bucket < b.Bucket
+		}
+		if a.DomainID != b.DomainID {
+			return a.DomainID < b.DomainID
+		}
+		return a.PageID < b.PageID
+	})
+
+	return rollups
+}
@@ -0,0 +1,471 @@
+package track
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// PostgresRepository is a RepositoryInterface backed by PostgreSQL.
+// Postgres drivers do not support LastInsertId, so inserts return the new ID with RETURNING.
+type PostgresRepository struct {
+	db           *sql.DB
+	queryTimeout time.Duration
+}
+
+func NewPostgresRepository(db *sql.DB) *PostgresRepository {
+	return &PostgresRepository{db: db}
+}
+
+// SetQueryTimeout bounds how long each repository call may run, on top of its context's deadline.
+func (repo *PostgresRepository) SetQueryTimeout(timeout time.Duration) {
+	repo.queryTimeout = timeout
+}
+
+// insertReturningID runs an INSERT ... RETURNING id statement and returns the new ID.
+func (repo *PostgresRepository) insertReturningID(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	var id int64
+	err := repo.db.QueryRowContext(ctx, query, args...).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// SavePageView saves a new page view to the page_views_tb table and its rollups.
+func (repo *PostgresRepository) SavePageView(ctx context.Context, domainId, pageId int, viewID string, visitor Visitor, referrer Referrer) (int64, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	pageViews := []PageView{{DomainID: domainId, PageID: pageId, ViewID: viewID, CreatedAt: time.Now(), Visitor: visitor, Referrer: referrer}}
+
+	var id int64
+	err := withTx(ctx, repo.db, func(tx *sql.Tx) error {
+		query, args := pageViewsQuery(dialectPostgres, pageViews)
+		if err := tx.QueryRowContext(ctx, query+" RETURNING id", args...).Scan(&id); err != nil {
+			return err
+		}
+
+		return writePageViewRollups(ctx, tx, dialectPostgres, pageViews)
+	})
+
+	return id, err
+}
+
+// SaveDomain saves a new domain to the domains_tb table.
+func (repo *PostgresRepository) SaveDomain(ctx context.Context, domain, key string) (int64, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return repo.insertReturningID(ctx, "INSERT INTO domains_tb (domain, siteKey) VALUES ($1, $2) RETURNING id", domain, key)
+}
+
+// GetDomain returns the ID of the domain from the domains_tb table.
+func (repo *PostgresRepository) GetDomain(ctx context.Context, domain string) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	var id int
+	err := repo.db.QueryRowContext(ctx, "SELECT id FROM domains_tb WHERE domain = $1", domain).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetDomainIDFromKey returns the ID of the domain from the domains_tb table given the key.
+func (repo *PostgresRepository) GetDomainIDFromKey(ctx context.Context, key string) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	var id int
+	err := repo.db.QueryRowContext(ctx, "SELECT id FROM domains_tb WHERE siteKey = $1", key).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetDomainKeyPair returns the key of the domain from the domains_tb table.
+func (repo *PostgresRepository) GetDomainKeyPair(ctx context.Context, domain string) (DomainKeyPair, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	var keyPair DomainKeyPair
+	err := repo.db.QueryRowContext(ctx, "SELECT domain, siteKey FROM domains_tb WHERE domain = $1", domain).Scan(&keyPair.Domain, &keyPair.SiteKey)
+	if err != nil {
+		return DomainKeyPair{}, err
+	}
+
+	return keyPair, nil
+}
+
+// GetPage returns the ID of the page from the pages_tb table.
+func (repo *PostgresRepository) GetPage(ctx context.Context, domainID int, pageURL string) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	var id int
+	err := repo.db.QueryRowContext(ctx, "SELECT id FROM pages_tb WHERE domain_id = $1 AND page_url = $2", domainID, pageURL).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// CreatePage saves a new page to the pages_tb table.
+func (repo *PostgresRepository) CreatePage(ctx context.Context, domainID int, pageURL string) (int64, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return repo.insertReturningID(ctx, "INSERT INTO pages_tb (domain_id, page_url) VALUES ($1, $2) RETURNING id", domainID, pageURL)
+}
+
+// GetOrCreatePage returns the ID of the page from the pages_tb table, creating it if it doesn't exist.
+// The unique index on (domain_id, page_url) makes concurrent creates of a page resolve to the same row.
+func (repo *PostgresRepository) GetOrCreatePage(ctx context.Context, domainID int, pageURL string) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	id, err := repo.GetPage(ctx, domainID, pageURL)
+	if err != nil || id != 0 {
+		return id, err
+	}
+
+	// The no-op update lets RETURNING give the existing row's ID if another request created the page first
+	err = repo.db.QueryRowContext(ctx, "INSERT INTO pages_tb (domain_id, page_url) VALUES ($1, $2) ON CONFLICT (domain_id, page_url) DO UPDATE SET page_url = EXCLUDED.page_url RETURNING id",
+		domainID, pageURL).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// SaveIPAddress saves an IP address to the ip_addresses_tb table unless it is already stored, and returns its ID.
+func (repo *PostgresRepository) SaveIPAddress(ctx context.Context, ipAddress string) (int64, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	id, err := queryIPAddressID(ctx, repo.db, dialectPostgres, ipAddress)
+	if err != nil || id != 0 {
+		return id, err
+	}
+
+	// The no-op update lets RETURNING give the existing row's ID if another request saved the address first
+	return repo.insertReturningID(ctx, "INSERT INTO ip_addresses_tb (ip_address) VALUES ($1) ON CONFLICT (ip_address) DO UPDATE SET ip_address = EXCLUDED.ip_address RETURNING id", ipAddress)
+}
+
+// SaveUTM saves a new UTM req to the utm_tb table and its rollup.
+func (repo *PostgresRepository) SaveUTM(ctx context.Context, pageID int, utmSource, utmMedium, utmCampaign, track string, visitor Visitor) (int64, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	utm := UTM{PageID: pageID, UTMSource: utmSource, UTMMedium: utmMedium, UTMCampaign: utmCampaign, Track: track, CreatedAt: time.Now(), Visitor: visitor}
+
+	var id int64
+	err := withTx(ctx, repo.db, func(tx *sql.Tx) error {
+		if err := tx.QueryRowContext(ctx, "SELECT domain_id FROM pages_tb WHERE id = $1", pageID).Scan(&utm.DomainID); err != nil {
+			return err
+		}
+
+		query, args := utmsQuery(dialectPostgres, []UTM{utm})
+		if err := tx.QueryRowContext(ctx, query+" RETURNING id", args...).Scan(&id); err != nil {
+			return err
+		}
+
+		return writeUTMRollups(ctx, tx, dialectPostgres, []UTM{utm})
+	})
+
+	return id, err
+}
+
+// SaveClick saves a new click data to the clicks_tb table.
+func (repo *PostgresRepository) SaveClick(ctx context.Context, pageID int, element map[string]interface{}, visitor Visitor, target ClickTarget) (int64, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	query, args, err := clicksQuery(dialectPostgres, []Click{{PageID: pageID, Element: element, CreatedAt: time.Now(), Visitor: visitor, ClickTarget: target}})
+	if err != nil {
+		return 0, err
+	}
+
+	return repo.insertReturningID(ctx, query+" RETURNING id", args...)
+}
+
+// SavePageViews saves a batch of page views to the page_views_tb table,
+// and adds them to the rollups in the same transaction.
+func (repo *PostgresRepository) SavePageViews(ctx context.Context, pageViews []PageView) error {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return savePageViews(ctx, repo.db, dialectPostgres, pageViews)
+}
+
+// SaveClicks saves a batch of clicks to the clicks_tb table in a single transaction.
+func (repo *PostgresRepository) SaveClicks(ctx context.Context, clicks []Click) error {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return saveClicks(ctx, repo.db, dialectPostgres, clicks)
+}
+
+// SaveUTMs saves a batch of UTM reqs to the utm_tb table,
+// and adds them to the rollup in the same transaction.
+func (repo *PostgresRepository) SaveUTMs(ctx context.Context, utms []UTM) error {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return saveUTMs(ctx, repo.db, dialectPostgres, utms)
+}
+
+// GetRetentionPolicies returns the retention policy of every domain in the domains_tb table.
+func (repo *PostgresRepository) GetRetentionPolicies(ctx context.Context) ([]RetentionPolicy, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	rows, err := repo.db.QueryContext(ctx, "SELECT id, domain, "+retentionColumns+" FROM domains_tb")
+	if err != nil {
+		return nil, err
+	}
+
+	return scanRetentionPolicies(rows)
+}
+
+// SetRetentionPolicy updates the retention policy of a domain in the domains_tb table.
+func (repo *PostgresRepository) SetRetentionPolicy(ctx context.Context, policy RetentionPolicy) error {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	_, err := repo.db.ExecContext(ctx, "UPDATE domains_tb SET page_views_retention_days = $1, clicks_retention_days = $2, utm_retention_days = $3, events_retention_days = $4, form_submissions_retention_days = $5, js_errors_retention_days = $6 WHERE id = $7",
+		nullRetentionDays(policy.PageViewsDays), nullRetentionDays(policy.ClicksDays), nullRetentionDays(policy.UTMsDays),
+		nullRetentionDays(policy.EventsDays), nullRetentionDays(policy.FormsDays), nullRetentionDays(policy.JSErrorsDays), policy.DomainID)
+	return err
+}
+
+// DeleteExpired deletes up to limit events of the given type created before the cutoff for a domain.
+func (repo *PostgresRepository) DeleteExpired(ctx context.Context, eventType EventType, domainID int, before time.Time, limit int) (int64, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	table, filter, err := eventTableFilter(eventType)
+	if err != nil {
+		return 0, err
+	}
+
+	// Postgres has no DELETE ... LIMIT, so select the batch of IDs instead
+	query := "DELETE FROM " + table + " WHERE id IN (SELECT id FROM " + table + " WHERE " + filter + " AND created_at < ? ORDER BY created_at LIMIT ?)"
+	result, err := repo.db.ExecContext(ctx, dialectPostgres.rebind(query), domainID, before.UTC(), limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// RebuildRollups recalculates the rollups for the UTC days in [from, to) from the event tables.
+func (repo *PostgresRepository) RebuildRollups(ctx context.Context, from, to time.Time) error {
+	return rebuildRollups(ctx, repo.db, dialectPostgres, from, to)
+}
+
+// SaveCustomEvent saves a new custom event to the events_tb table.
+func (repo *PostgresRepository) SaveCustomEvent(ctx context.Context, domainID, pageID int, name string, props map[string]interface{}, visitor Visitor) (int64, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	query, args, err := customEventsQuery(dialectPostgres, []CustomEvent{{DomainID: domainID, PageID: pageID, Name: name, Props: props, CreatedAt: time.Now(), Visitor: visitor}})
+	if err != nil {
+		return 0, err
+	}
+
+	return repo.insertReturningID(ctx, query+" RETURNING id", args...)
+}
+
+// SaveCustomEvents saves a batch of custom events to the events_tb table in a single transaction.
+func (repo *PostgresRepository) SaveCustomEvents(ctx context.Context, events []CustomEvent) error {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return saveCustomEvents(ctx, repo.db, dialectPostgres, events)
+}
+
+// GetEventPropertyBreakdown counts the custom events with the name for a domain created in [from, to)
+// by the value of the property, most common first.
+func (repo *PostgresRepository) GetEventPropertyBreakdown(ctx context.Context, domainID int, name, property string, from, to time.Time) ([]EventPropertyCount, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return queryEventPropertyBreakdown(ctx, repo.db, dialectPostgres, domainID, name, property, from, to)
+}
+
+// GetOrCreateVisitorSalt stores the visitor salt for the UTC day unless one already is, and returns the stored salt.
+// The salts of earlier days are deleted.
+func (repo *PostgresRepository) GetOrCreateVisitorSalt(ctx context.Context, day, salt string) (string, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return getOrCreateVisitorSalt(ctx, repo.db, dialectPostgres, day, salt)
+}
+
+// GetVisitorCounts counts the page views, visitors and sessions of a domain created in [from, to).
+func (repo *PostgresRepository) GetVisitorCounts(ctx context.Context, domainID int, from, to time.Time) (VisitorCounts, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return queryVisitorCounts(ctx, repo.db, dialectPostgres, domainID, from, to)
+}
+
+// GetReferrerCounts counts the page views of a domain created in [from, to) by referrer channel and host.
+func (repo *PostgresRepository) GetReferrerCounts(ctx context.Context, domainID int, from, to time.Time) ([]ReferrerCount, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return queryReferrerCounts(ctx, repo.db, dialectPostgres, domainID, from, to)
+}
+
+// GetDimensionCounts counts the page views of a domain created in [from, to) by browser, browser version, OS, device, country, region or city.
+func (repo *PostgresRepository) GetDimensionCounts(ctx context.Context, domainID int, dimension string, from, to time.Time) ([]DimensionCount, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return queryDimensionCounts(ctx, repo.db, dialectPostgres, domainID, dimension, from, to)
+}
+
+// GetBotMode returns how a domain in the domains_tb table handles events from bots.
+func (repo *PostgresRepository) GetBotMode(ctx context.Context, domainID int) (string, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return queryBotMode(ctx, repo.db, dialectPostgres, domainID)
+}
+
+// SetBotMode sets whether a domain in the domains_tb table drops or flags events from bots.
+func (repo *PostgresRepository) SetBotMode(ctx context.Context, domainID int, mode string) error {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return updateBotMode(ctx, repo.db, dialectPostgres, domainID, mode)
+}
+
+// GetIPPolicy returns how a domain in the domains_tb table stores the IP addresses of its events.
+func (repo *PostgresRepository) GetIPPolicy(ctx context.Context, domainID int) (IPPolicy, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return queryIPPolicy(ctx, repo.db, dialectPostgres, domainID)
+}
+
+// SetIPMode sets how a domain in the domains_tb table stores the IP addresses of its events.
+func (repo *PostgresRepository) SetIPMode(ctx context.Context, domainID int, mode string) error {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return updateIPMode(ctx, repo.db, dialectPostgres, domainID, mode)
+}
+
+// AddEngagements adds the engaged time of each engagement to its page view in the page_views_tb table.
+func (repo *PostgresRepository) AddEngagements(ctx context.Context, engagements []Engagement) error {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return addEngagements(ctx, repo.db, dialectPostgres, engagements)
+}
+
+// GetPageEngagement averages the engaged time of a domain's page views created in [from, to) by path.
+func (repo *PostgresRepository) GetPageEngagement(ctx context.Context, domainID int, from, to time.Time) ([]PageEngagement, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return queryPageEngagement(ctx, repo.db, dialectPostgres, domainID, from, to)
+}
+
+// SaveScrollDepths keeps the depth of each scroll on its page view in the page_views_tb table, unless it has scrolled deeper.
+func (repo *PostgresRepository) SaveScrollDepths(ctx context.Context, scrolls []ScrollDepth) error {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return saveScrollDepths(ctx, repo.db, dialectPostgres, scrolls)
+}
+
+// GetScrollDepths counts a domain's page views created in [from, to) by path and the deepest scroll depth they reached.
+func (repo *PostgresRepository) GetScrollDepths(ctx context.Context, domainID int, from, to time.Time) ([]PageScrollDepth, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return queryScrollDepths(ctx, repo.db, dialectPostgres, domainID, from, to)
+}
+
+// GetClickTargets counts the clicks of a class on a domain's pages created in [from, to) by target, most clicked first.
+func (repo *PostgresRepository) GetClickTargets(ctx context.Context, domainID int, class string, from, to time.Time) ([]ClickTargetCount, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return queryClickTargets(ctx, repo.db, dialectPostgres, domainID, class, from, to)
+}
+
+// SaveFormSubmission saves a new form submission to the form_submissions_tb table.
+func (repo *PostgresRepository) SaveFormSubmission(ctx context.Context, domainID, pageID int, form Form, visitor Visitor) (int64, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	query, args := formSubmissionsQuery(dialectPostgres, []FormSubmission{{DomainID: domainID, PageID: pageID, CreatedAt: time.Now(), Visitor: visitor, Form: form}})
+	return repo.insertReturningID(ctx, query+" RETURNING id", args...)
+}
+
+// SaveFormSubmissions saves a batch of form submissions to the form_submissions_tb table in a single transaction.
+func (repo *PostgresRepository) SaveFormSubmissions(ctx context.Context, submissions []FormSubmission) error {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return saveFormSubmissions(ctx, repo.db, dialectPostgres, submissions)
+}
+
+// GetFormSubmissionCounts counts a domain's form submissions created in [from, to) by page and form, most submitted first.
+func (repo *PostgresRepository) GetFormSubmissionCounts(ctx context.Context, domainID int, from, to time.Time) ([]FormSubmissionCount, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return queryFormSubmissionCounts(ctx, repo.db, dialectPostgres, domainID, from, to)
+}
+
+// SaveJSError saves a new JavaScript error to the js_errors_tb table.
+func (repo *PostgresRepository) SaveJSError(ctx context.Context, domainID, pageID int, jsError JSError, visitor Visitor) (int64, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	query, args := jsErrorsQuery(dialectPostgres, []JSErrorEvent{{DomainID: domainID, PageID: pageID, CreatedAt: time.Now(), Visitor: visitor, JSError: jsError}})
+	return repo.insertReturningID(ctx, query+" RETURNING id", args...)
+}
+
+// SaveJSErrors saves a batch of JavaScript errors to the js_errors_tb table in a single transaction.
+func (repo *PostgresRepository) SaveJSErrors(ctx context.Context, events []JSErrorEvent) error {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return saveJSErrors(ctx, repo.db, dialectPostgres, events)
+}
+
+// GetTopJSErrors groups a domain's JavaScript errors thrown in [from, to) by fingerprint, most thrown first.
+func (repo *PostgresRepository) GetTopJSErrors(ctx context.Context, domainID int, from, to time.Time) ([]JSErrorGroup, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return queryTopJSErrors(ctx, repo.db, dialectPostgres, domainID, from, to)
+}
+
+// GetJSErrorCounts counts the times a JavaScript error was thrown on a domain in [from, to) by page and browser.
+func (repo *PostgresRepository) GetJSErrorCounts(ctx context.Context, domainID int, fingerprint string, from, to time.Time) ([]JSErrorCount, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return queryJSErrorCounts(ctx, repo.db, dialectPostgres, domainID, fingerprint, from, to)
+}
@@ -0,0 +1,211 @@
+package track
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Referrer channels. A page view's channel is where its visitor came from.
+const (
+	// ChannelDirect is a page view without a referrer, e.g. a bookmark or a typed URL.
+	ChannelDirect = "direct"
+	// ChannelInternal is a page view referred by another page of the same site.
+	ChannelInternal = "internal"
+	ChannelSearch   = "search"
+	ChannelSocial   = "social"
+	ChannelEmail    = "email"
+	// ChannelReferral is a page view referred by a site no rule matches.
+	ChannelReferral = "referral"
+)
+
+// maxReferrerLength is the longest referrer host or path stored, matching the column sizes.
+const maxReferrerLength = 255
+
+// Referrer is where a page view came from. Only the host and path of the referring URL are kept,
+// as query strings can hold search terms and tokens.
+type Referrer struct {
+	ReferrerHost    string `json:"referrer_host,omitempty"`
+	ReferrerPath    string `json:"referrer_path,omitempty"`
+	ReferrerChannel string `json:"referrer_channel,omitempty"`
+}
+
+// ReferrerCount is the number of page views from a referring host on a channel.
+// Host is empty for direct page views.
+type ReferrerCount struct {
+	Channel string
+	Host    string
+	Count   int64
+}
+
+//go:embed referrer_rules.json
+var bundledReferrerRules []byte
+
+var defaultReferrerRules = mustParseReferrerRules(bundledReferrerRules)
+
+// ReferrerRules classifies referrers into the search, social and email channels by their host.
+//
+// The rules are a JSON object listing the hosts of each channel. A host matches itself and its
+// subdomains, and the most specific host listed wins, so mail.google.com can be email while the
+// rest of google.com is search. A host ending in .* matches the name under any domain suffix,
+// e.g. google.* matches google.com and www.google.co.uk.
+type ReferrerRules struct {
+	hosts     map[string]string
+	wildcards map[string]string
+}
+
+// DefaultReferrerRules returns the rules bundled with the server.
+func DefaultReferrerRules() *ReferrerRules {
+	return defaultReferrerRules
+}
+
+// LoadReferrerRules reads referrer rules from a file, to use instead of the bundled rules.
+func LoadReferrerRules(path string) (*ReferrerRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseReferrerRules(data)
+}
+
+// ParseReferrerRules parses referrer rules, checking each channel is known and each host is listed once.
+func ParseReferrerRules(data []byte) (*ReferrerRules, error) {
+	var channels map[string][]string
+	if err := json.Unmarshal(data, &channels); err != nil {
+		return nil, err
+	}
+
+	rules := &ReferrerRules{
+		hosts:     make(map[string]string),
+		wildcards: make(map[string]string),
+	}
+	for channel, hosts := range channels {
+		switch channel {
+		case ChannelSearch, ChannelSocial, ChannelEmail:
+		default:
+			return nil, fmt.Errorf("unknown channel %q", channel)
+		}
+
+		for _, host := range hosts {
+			host = strings.ToLower(strings.TrimSpace(host))
+			target := rules.hosts
+			if name, ok := strings.CutSuffix(host, ".*"); ok {
+				host = name
+				target = rules.wildcards
+			}
+			if host == "" || strings.Contains(host, "*") {
+				return nil, fmt.Errorf("invalid %s host %q", channel, host)
+			}
+			if existing, ok := target[host]; ok {
+				return nil, fmt.Errorf("host %q is listed under both %s and %s", host, existing, channel)
+			}
+			target[host] = channel
+		}
+	}
+
+	return rules, nil
+}
+
+func mustParseReferrerRules(data []byte) *ReferrerRules {
+	rules, err := ParseReferrerRules(data)
+	if err != nil {
+		panic(fmt.Sprintf("invalid bundled referrer rules: %v", err))
+	}
+	return rules
+}
+
+// Classify returns the host, path and channel of a page view's referrer on the domain.
+// An empty or invalid referrer is direct, and a referrer on the domain or its subdomains is internal.
+func (rules *ReferrerRules) Classify(referrer, domain string) Referrer {
+	u, err := url.Parse(strings.TrimSpace(referrer))
+	if err != nil || u.Host == "" {
+		return Referrer{ReferrerChannel: ChannelDirect}
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	ref := Referrer{
+		ReferrerHost: truncate(host, maxReferrerLength),
+		ReferrerPath: truncate(path, maxReferrerLength),
+	}
+
+	switch {
+	case sameSite(host, strings.ToLower(domain)):
+		ref.ReferrerChannel = ChannelInternal
+	default:
+		ref.ReferrerChannel = rules.match(host)
+	}
+
+	return ref
+}
+
+// match returns the channel of the most specific rule matching the host, or ChannelReferral.
+func (rules *ReferrerRules) match(host string) string {
+	for h := host; ; {
+		if channel, ok := rules.hosts[h]; ok {
+			return channel
+		}
+
+		dot := strings.IndexByte(h, '.')
+		if dot < 0 {
+			return ChannelReferral
+		}
+		if channel, ok := rules.wildcards[h[:dot]]; ok {
+			return channel
+		}
+		h = h[dot+1:]
+	}
+}
+
+// sameSite reports whether the host is the domain or one of its subdomains, ignoring www.
+func sameSite(host, domain string) bool {
+	host = strings.TrimPrefix(host, "www.")
+	domain = strings.TrimPrefix(domain, "www.")
+	return domain != "" && (host == domain || strings.HasSuffix(host, "."+domain))
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	// Back off to the start of a character, so a multi-byte character isn't split
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// queryReferrerCounts counts the classified page views of a domain created in [from, to)
+// by channel and referring host, most common first.
+func queryReferrerCounts(ctx context.Context, db *sql.DB, d dialect, domainID int, from, to time.Time) ([]ReferrerCount, error) {
+	query := "SELECT referrer_channel, COALESCE(referrer_host, '') AS host, COUNT(*) AS hits FROM page_views_tb " +
+		"WHERE domain_id = ? AND created_at >= ? AND created_at < ? AND referrer_channel IS NOT NULL AND NOT is_bot " +
+		"GROUP BY referrer_channel, COALESCE(referrer_host, '') ORDER BY hits DESC, referrer_channel, host"
+
+	rows, err := db.QueryContext(ctx, d.rebind(query), domainID, d.timeArg(from), d.timeArg(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []ReferrerCount
+	for rows.Next() {
+		var count ReferrerCount
+		if err := rows.Scan(&count.Channel, &count.Host, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+
+	return counts, rows.Err()
+}
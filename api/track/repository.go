@@ -1,40 +1,98 @@
 package track
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
 	"errors"
+	"time"
 )
 
 type RepositoryInterface interface {
-	SavePageView(domainId, pageId int) (int64, error)
-	SaveDomain(domain, key string) (int64, error)
-	GetDomain(domain string) (int, error)
-	GetDomainIDFromKey(key string) (int, error)
-	GetDomainKeyPair(domain string) (DomainKeyPair, error)
-	GetPage(domainID int, pageURL string) (int, error)
-	CreatePage(domainID int, pageURL string) (int64, error)
-	SaveIPAddress(ipAddress string) (int64, error)
-	SaveUTM(pageID int, utmSource, utmMedium, utmCampaign, track string) (int64, error)
-	SaveClick(pageID int, element map[string]interface{}) (int64, error)
+	SavePageView(ctx context.Context, domainId, pageId int, viewID string, visitor Visitor, referrer Referrer) (int64, error)
+	SaveDomain(ctx context.Context, domain, key string) (int64, error)
+	GetDomain(ctx context.Context, domain string) (int, error)
+	GetDomainIDFromKey(ctx context.Context, key string) (int, error)
+	GetDomainKeyPair(ctx context.Context, domain string) (DomainKeyPair, error)
+	GetPage(ctx context.Context, domainID int, pageURL string) (int, error)
+	CreatePage(ctx context.Context, domainID int, pageURL string) (int64, error)
+	GetOrCreatePage(ctx context.Context, domainID int, pageURL string) (int, error)
+	SaveIPAddress(ctx context.Context, ipAddress string) (int64, error)
+	SaveUTM(ctx context.Context, pageID int, utmSource, utmMedium, utmCampaign, track string, visitor Visitor) (int64, error)
+	SaveClick(ctx context.Context, pageID int, element map[string]interface{}, visitor Visitor, target ClickTarget) (int64, error)
+	SavePageViews(ctx context.Context, pageViews []PageView) error
+	SaveClicks(ctx context.Context, clicks []Click) error
+	SaveUTMs(ctx context.Context, utms []UTM) error
+	GetRetentionPolicies(ctx context.Context) ([]RetentionPolicy, error)
+	SetRetentionPolicy(ctx context.Context, policy RetentionPolicy) error
+	DeleteExpired(ctx context.Context, eventType EventType, domainID int, before time.Time, limit int) (int64, error)
+	RebuildRollups(ctx context.Context, from, to time.Time) error
+	SaveCustomEvent(ctx context.Context, domainID, pageID int, name string, props map[string]interface{}, visitor Visitor) (int64, error)
+	SaveCustomEvents(ctx context.Context, events []CustomEvent) error
+	GetEventPropertyBreakdown(ctx context.Context, domainID int, name, property string, from, to time.Time) ([]EventPropertyCount, error)
+	GetOrCreateVisitorSalt(ctx context.Context, day, salt string) (string, error)
+	GetVisitorCounts(ctx context.Context, domainID int, from, to time.Time) (VisitorCounts, error)
+	GetReferrerCounts(ctx context.Context, domainID int, from, to time.Time) ([]ReferrerCount, error)
+	GetDimensionCounts(ctx context.Context, domainID int, dimension string, from, to time.Time) ([]DimensionCount, error)
+	GetBotMode(ctx context.Context, domainID int) (string, error)
+	SetBotMode(ctx context.Context, domainID int, mode string) error
+	GetIPPolicy(ctx context.Context, domainID int) (IPPolicy, error)
+	SetIPMode(ctx context.Context, domainID int, mode string) error
+	AddEngagements(ctx context.Context, engagements []Engagement) error
+	GetPageEngagement(ctx context.Context, domainID int, from, to time.Time) ([]PageEngagement, error)
+	SaveScrollDepths(ctx context.Context, scrolls []ScrollDepth) error
+	GetScrollDepths(ctx context.Context, domainID int, from, to time.Time) ([]PageScrollDepth, error)
+	GetClickTargets(ctx context.Context, domainID int, class string, from, to time.Time) ([]ClickTargetCount, error)
+	SaveFormSubmission(ctx context.Context, domainID, pageID int, form Form, visitor Visitor) (int64, error)
+	SaveFormSubmissions(ctx context.Context, submissions []FormSubmission) error
+	GetFormSubmissionCounts(ctx context.Context, domainID int, from, to time.Time) ([]FormSubmissionCount, error)
+	SaveJSError(ctx context.Context, domainID, pageID int, jsError JSError, visitor Visitor) (int64, error)
+	SaveJSErrors(ctx context.Context, events []JSErrorEvent) error
+	GetTopJSErrors(ctx context.Context, domainID int, from, to time.Time) ([]JSErrorGroup, error)
+	GetJSErrorCounts(ctx context.Context, domainID int, fingerprint string, from, to time.Time) ([]JSErrorCount, error)
 }
 
 type Repository struct {
-	db *sql.DB
+	db           *sql.DB
+	queryTimeout time.Duration
 }
 
 func NewRepository(db *sql.DB) *Repository {
 	return &Repository{db: db}
 }
 
-// SavePageView saves a new page view to the page_views_tb table.
-func (repo *Repository) SavePageView(domainId, pageId int) (int64, error) {
-	result, err := repo.db.Exec("INSERT INTO page_views_tb (domain_id, page_id) VALUES (?, ?)", domainId, pageId)
-	if err != nil {
-		return 0, err
+// SetQueryTimeout bounds how long each repository call may run, on top of its context's deadline.
+func (repo *Repository) SetQueryTimeout(timeout time.Duration) {
+	repo.queryTimeout = timeout
+}
+
+// withQueryTimeout returns a context which is cancelled after the timeout, or with the parent if timeout is 0.
+func withQueryTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, timeout)
+}
 
-	id, err := result.LastInsertId()
+// SavePageView saves a new page view to the page_views_tb table and its rollups.
+func (repo *Repository) SavePageView(ctx context.Context, domainId, pageId int, viewID string, visitor Visitor, referrer Referrer) (int64, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	pageViews := []PageView{{DomainID: domainId, PageID: pageId, ViewID: viewID, CreatedAt: time.Now(), Visitor: visitor, Referrer: referrer}}
+
+	var id int64
+	err := withTx(ctx, repo.db, func(tx *sql.Tx) error {
+		query, args := pageViewsQuery(dialectMySQL, pageViews)
+		result, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		if id, err = result.LastInsertId(); err != nil {
+			return err
+		}
+
+		return writePageViewRollups(ctx, tx, dialectMySQL, pageViews)
+	})
 	if err != nil {
 		return 0, err
 	}
@@ -43,8 +101,11 @@ func (repo *Repository) SavePageView(domainId, pageId int) (int64, error) {
 }
 
 // SaveDomain saves a new domain to the domains_tb table.
-func (repo *Repository) SaveDomain(domain, key string) (int64, error) {
-	result, err := repo.db.Exec("INSERT INTO domains_tb (domain, key) VALUES (?, ?)", domain, key)
+func (repo *Repository) SaveDomain(ctx context.Context, domain, key string) (int64, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	result, err := repo.db.ExecContext(ctx, "INSERT INTO domains_tb (domain, key) VALUES (?, ?)", domain, key)
 	if err != nil {
 		return 0, err
 	}
@@ -58,9 +119,12 @@ func (repo *Repository) SaveDomain(domain, key string) (int64, error) {
 }
 
 // GetDomain returns the ID of the domain from the domains_tb table.
-func (repo *Repository) GetDomain(domain string) (int, error) {
+func (repo *Repository) GetDomain(ctx context.Context, domain string) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
 	var id int
-	err := repo.db.QueryRow("SELECT id FROM domains_tb WHERE domain = ?", domain).Scan(&id)
+	err := repo.db.QueryRowContext(ctx, "SELECT id FROM domains_tb WHERE domain = ?", domain).Scan(&id)
 	if err != nil {
 		return 0, err
 	}
@@ -69,9 +133,12 @@ func (repo *Repository) GetDomain(domain string) (int, error) {
 }
 
 // GetDomainIDFromKey returns the ID of the domain from the domains_tb table given the key.
-func (repo *Repository) GetDomainIDFromKey(key string) (int, error) {
+func (repo *Repository) GetDomainIDFromKey(ctx context.Context, key string) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
 	var id int
-	err := repo.db.QueryRow("SELECT id FROM domains_tb WHERE siteKey = ?", key).Scan(&id)
+	err := repo.db.QueryRowContext(ctx, "SELECT id FROM domains_tb WHERE siteKey = ?", key).Scan(&id)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return 0, nil
@@ -89,9 +156,12 @@ type DomainKeyPair struct {
 }
 
 // GetDomainKeyPair returns the key of the domain from the domains_tb table.
-func (repo *Repository) GetDomainKeyPair(domain string) (DomainKeyPair, error) {
+func (repo *Repository) GetDomainKeyPair(ctx context.Context, domain string) (DomainKeyPair, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
 	var keyPair DomainKeyPair
-	err := repo.db.QueryRow("SELECT domain, siteKey FROM domains_tb WHERE domain = ?", domain).Scan(&keyPair.Domain, &keyPair.SiteKey)
+	err := repo.db.QueryRowContext(ctx, "SELECT domain, siteKey FROM domains_tb WHERE domain = ?", domain).Scan(&keyPair.Domain, &keyPair.SiteKey)
 	if errors.Is(err, sql.ErrNoRows) {
 		return DomainKeyPair{}, sql.ErrNoRows
 	} else if err != nil {
@@ -102,9 +172,12 @@ func (repo *Repository) GetDomainKeyPair(domain string) (DomainKeyPair, error) {
 }
 
 // GetPage returns the ID of the page from the pages_tb table.
-func (repo *Repository) GetPage(domainID int, pageURL string) (int, error) {
+func (repo *Repository) GetPage(ctx context.Context, domainID int, pageURL string) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
 	var id int
-	err := repo.db.QueryRow("SELECT id FROM pages_tb WHERE domain_id = ? AND page_url = ?", domainID, pageURL).Scan(&id)
+	err := repo.db.QueryRowContext(ctx, "SELECT id FROM pages_tb WHERE domain_id = ? AND page_url = ?", domainID, pageURL).Scan(&id)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return 0, nil
@@ -117,8 +190,11 @@ func (repo *Repository) GetPage(domainID int, pageURL string) (int, error) {
 }
 
 // SavePage saves a new page to the pages_tb table.
-func (repo *Repository) CreatePage(domainID int, pageURL string) (int64, error) {
-	result, err := repo.db.Exec("INSERT INTO pages_tb (domain_id, page_url) VALUES (?, ?)", domainID, pageURL)
+func (repo *Repository) CreatePage(ctx context.Context, domainID int, pageURL string) (int64, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	result, err := repo.db.ExecContext(ctx, "INSERT INTO pages_tb (domain_id, page_url) VALUES (?, ?)", domainID, pageURL)
 	if err != nil {
 		return 0, err
 	}
@@ -131,30 +207,74 @@ func (repo *Repository) CreatePage(domainID int, pageURL string) (int64, error)
 	return id, nil
 }
 
-// SaveIPAddress saves a new IP address to the ip_addresses_tb table.
-func (repo *Repository) SaveIPAddress(ipAddress string) (int64, error) {
-	result, err := repo.db.Exec("INSERT INTO ip_addresses_tb (ip_address) VALUES (?)", ipAddress)
+// GetOrCreatePage returns the ID of the page from the pages_tb table, creating it if it doesn't exist.
+// The unique index on (domain_id, page_url) makes concurrent creates of a page resolve to the same row.
+func (repo *Repository) GetOrCreatePage(ctx context.Context, domainID int, pageURL string) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	id, err := repo.GetPage(ctx, domainID, pageURL)
+	if err != nil || id != 0 {
+		return id, err
+	}
+
+	// LAST_INSERT_ID(id) makes LastInsertId return the existing row's ID if another request created the page first
+	result, err := repo.db.ExecContext(ctx, "INSERT INTO pages_tb (domain_id, page_url) VALUES (?, ?) ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id)", domainID, pageURL)
 	if err != nil {
 		return 0, err
 	}
 
-	id, err := result.LastInsertId()
+	newId, err := result.LastInsertId()
 	if err != nil {
 		return 0, err
 	}
 
-	return id, nil
+	return int(newId), nil
 }
 
-// SaveUTM saves a new UTM req to the utm_tb table.
-func (repo *Repository) SaveUTM(pageID int, utmSource, utmMedium, utmCampaign, track string) (int64, error) {
-	result, err := repo.db.Exec("INSERT INTO utm_tb (page_id, utm_source, utm_medium, utm_campaign, track) VALUES (?, ?, ?, ?, ?)",
-		pageID, utmSource, utmMedium, utmCampaign, track)
+// SaveIPAddress saves an IP address to the ip_addresses_tb table unless it is already stored, and returns its ID.
+func (repo *Repository) SaveIPAddress(ctx context.Context, ipAddress string) (int64, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	id, err := queryIPAddressID(ctx, repo.db, dialectMySQL, ipAddress)
+	if err != nil || id != 0 {
+		return id, err
+	}
+
+	// LAST_INSERT_ID(id) makes LastInsertId return the existing row's ID if another request saved the address first
+	result, err := repo.db.ExecContext(ctx, "INSERT INTO ip_addresses_tb (ip_address) VALUES (?) ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id)", ipAddress)
 	if err != nil {
 		return 0, err
 	}
 
-	id, err := result.LastInsertId()
+	return result.LastInsertId()
+}
+
+// SaveUTM saves a new UTM req to the utm_tb table and its rollup.
+func (repo *Repository) SaveUTM(ctx context.Context, pageID int, utmSource, utmMedium, utmCampaign, track string, visitor Visitor) (int64, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	utm := UTM{PageID: pageID, UTMSource: utmSource, UTMMedium: utmMedium, UTMCampaign: utmCampaign, Track: track, CreatedAt: time.Now(), Visitor: visitor}
+
+	var id int64
+	err := withTx(ctx, repo.db, func(tx *sql.Tx) error {
+		if err := tx.QueryRowContext(ctx, "SELECT domain_id FROM pages_tb WHERE id = ?", pageID).Scan(&utm.DomainID); err != nil {
+			return err
+		}
+
+		query, args := utmsQuery(dialectMySQL, []UTM{utm})
+		result, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		if id, err = result.LastInsertId(); err != nil {
+			return err
+		}
+
+		return writeUTMRollups(ctx, tx, dialectMySQL, []UTM{utm})
+	})
 	if err != nil {
 		return 0, err
 	}
@@ -163,24 +283,300 @@ func (repo *Repository) SaveUTM(pageID int, utmSource, utmMedium, utmCampaign, t
 }
 
 // SaveClick saves a new click data to the clicks_tb table.
-func (repo *Repository) SaveClick(pageID int, element map[string]interface{}) (int64, error) {
-	// Convert the map to a JSON string
-	elementJSON, err := json.Marshal(element)
+func (repo *Repository) SaveClick(ctx context.Context, pageID int, element map[string]interface{}, visitor Visitor, target ClickTarget) (int64, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	query, args, err := clicksQuery(dialectMySQL, []Click{{PageID: pageID, Element: element, CreatedAt: time.Now(), Visitor: visitor, ClickTarget: target}})
 	if err != nil {
 		return 0, err
 	}
 
-	// Use the JSON_UNQUOTE function to ensure the stored JSON data is valid
-	stmt := "INSERT INTO clicks_tb (page_id, element) VALUES (?, JSON_UNQUOTE(?))"
-	result, err := repo.db.Exec(stmt, pageID, elementJSON)
+	result, err := repo.db.ExecContext(ctx, query, args...)
 	if err != nil {
 		return 0, err
 	}
 
-	id, err := result.LastInsertId()
+	return result.LastInsertId()
+}
+
+// SavePageViews saves a batch of page views to the page_views_tb table,
+// and adds them to the rollups in the same transaction.
+func (repo *Repository) SavePageViews(ctx context.Context, pageViews []PageView) error {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return savePageViews(ctx, repo.db, dialectMySQL, pageViews)
+}
+
+// SaveClicks saves a batch of clicks to the clicks_tb table in a single transaction.
+func (repo *Repository) SaveClicks(ctx context.Context, clicks []Click) error {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return saveClicks(ctx, repo.db, dialectMySQL, clicks)
+}
+
+// SaveUTMs saves a batch of UTM reqs to the utm_tb table,
+// and adds them to the rollup in the same transaction.
+func (repo *Repository) SaveUTMs(ctx context.Context, utms []UTM) error {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return saveUTMs(ctx, repo.db, dialectMySQL, utms)
+}
+
+// GetRetentionPolicies returns the retention policy of every domain in the domains_tb table.
+func (repo *Repository) GetRetentionPolicies(ctx context.Context) ([]RetentionPolicy, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	rows, err := repo.db.QueryContext(ctx, "SELECT id, domain, "+retentionColumns+" FROM domains_tb")
+	if err != nil {
+		return nil, err
+	}
+
+	return scanRetentionPolicies(rows)
+}
+
+// SetRetentionPolicy updates the retention policy of a domain in the domains_tb table.
+func (repo *Repository) SetRetentionPolicy(ctx context.Context, policy RetentionPolicy) error {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	_, err := repo.db.ExecContext(ctx, "UPDATE domains_tb SET page_views_retention_days = ?, clicks_retention_days = ?, utm_retention_days = ?, events_retention_days = ?, form_submissions_retention_days = ?, js_errors_retention_days = ? WHERE id = ?",
+		nullRetentionDays(policy.PageViewsDays), nullRetentionDays(policy.ClicksDays), nullRetentionDays(policy.UTMsDays),
+		nullRetentionDays(policy.EventsDays), nullRetentionDays(policy.FormsDays), nullRetentionDays(policy.JSErrorsDays), policy.DomainID)
+	return err
+}
+
+// DeleteExpired deletes up to limit events of the given type created before the cutoff for a domain.
+func (repo *Repository) DeleteExpired(ctx context.Context, eventType EventType, domainID int, before time.Time, limit int) (int64, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	table, filter, err := eventTableFilter(eventType)
 	if err != nil {
 		return 0, err
 	}
 
-	return id, nil
+	result, err := repo.db.ExecContext(ctx, "DELETE FROM "+table+" WHERE "+filter+" AND created_at < ? ORDER BY created_at LIMIT ?", domainID, before.UTC(), limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// RebuildRollups recalculates the rollups for the UTC days in [from, to) from the event tables.
+func (repo *Repository) RebuildRollups(ctx context.Context, from, to time.Time) error {
+	return rebuildRollups(ctx, repo.db, dialectMySQL, from, to)
+}
+
+// SaveCustomEvent saves a new custom event to the events_tb table.
+func (repo *Repository) SaveCustomEvent(ctx context.Context, domainID, pageID int, name string, props map[string]interface{}, visitor Visitor) (int64, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	query, args, err := customEventsQuery(dialectMySQL, []CustomEvent{{DomainID: domainID, PageID: pageID, Name: name, Props: props, CreatedAt: time.Now(), Visitor: visitor}})
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := repo.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// SaveCustomEvents saves a batch of custom events to the events_tb table in a single transaction.
+func (repo *Repository) SaveCustomEvents(ctx context.Context, events []CustomEvent) error {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return saveCustomEvents(ctx, repo.db, dialectMySQL, events)
+}
+
+// GetEventPropertyBreakdown counts the custom events with the name for a domain created in [from, to)
+// by the value of the property, most common first.
+func (repo *Repository) GetEventPropertyBreakdown(ctx context.Context, domainID int, name, property string, from, to time.Time) ([]EventPropertyCount, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return queryEventPropertyBreakdown(ctx, repo.db, dialectMySQL, domainID, name, property, from, to)
+}
+
+// GetOrCreateVisitorSalt stores the visitor salt for the UTC day unless one already is, and returns the stored salt.
+// The salts of earlier days are deleted.
+func (repo *Repository) GetOrCreateVisitorSalt(ctx context.Context, day, salt string) (string, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return getOrCreateVisitorSalt(ctx, repo.db, dialectMySQL, day, salt)
+}
+
+// GetVisitorCounts counts the page views, visitors and sessions of a domain created in [from, to).
+func (repo *Repository) GetVisitorCounts(ctx context.Context, domainID int, from, to time.Time) (VisitorCounts, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return queryVisitorCounts(ctx, repo.db, dialectMySQL, domainID, from, to)
+}
+
+// GetReferrerCounts counts the page views of a domain created in [from, to) by referrer channel and host.
+func (repo *Repository) GetReferrerCounts(ctx context.Context, domainID int, from, to time.Time) ([]ReferrerCount, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return queryReferrerCounts(ctx, repo.db, dialectMySQL, domainID, from, to)
+}
+
+// GetDimensionCounts counts the page views of a domain created in [from, to) by browser, browser version, OS, device, country, region or city.
+func (repo *Repository) GetDimensionCounts(ctx context.Context, domainID int, dimension string, from, to time.Time) ([]DimensionCount, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return queryDimensionCounts(ctx, repo.db, dialectMySQL, domainID, dimension, from, to)
+}
+
+// GetBotMode returns how a domain in the domains_tb table handles events from bots.
+func (repo *Repository) GetBotMode(ctx context.Context, domainID int) (string, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return queryBotMode(ctx, repo.db, dialectMySQL, domainID)
+}
+
+// SetBotMode sets whether a domain in the domains_tb table drops or flags events from bots.
+func (repo *Repository) SetBotMode(ctx context.Context, domainID int, mode string) error {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return updateBotMode(ctx, repo.db, dialectMySQL, domainID, mode)
+}
+
+// GetIPPolicy returns how a domain in the domains_tb table stores the IP addresses of its events.
+func (repo *Repository) GetIPPolicy(ctx context.Context, domainID int) (IPPolicy, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return queryIPPolicy(ctx, repo.db, dialectMySQL, domainID)
+}
+
+// SetIPMode sets how a domain in the domains_tb table stores the IP addresses of its events.
+func (repo *Repository) SetIPMode(ctx context.Context, domainID int, mode string) error {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return updateIPMode(ctx, repo.db, dialectMySQL, domainID, mode)
+}
+
+// AddEngagements adds the engaged time of each engagement to its page view in the page_views_tb table.
+func (repo *Repository) AddEngagements(ctx context.Context, engagements []Engagement) error {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return addEngagements(ctx, repo.db, dialectMySQL, engagements)
+}
+
+// GetPageEngagement averages the engaged time of a domain's page views created in [from, to) by path.
+func (repo *Repository) GetPageEngagement(ctx context.Context, domainID int, from, to time.Time) ([]PageEngagement, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return queryPageEngagement(ctx, repo.db, dialectMySQL, domainID, from, to)
+}
+
+// SaveScrollDepths keeps the depth of each scroll on its page view in the page_views_tb table, unless it has scrolled deeper.
+func (repo *Repository) SaveScrollDepths(ctx context.Context, scrolls []ScrollDepth) error {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return saveScrollDepths(ctx, repo.db, dialectMySQL, scrolls)
+}
+
+// GetScrollDepths counts a domain's page views created in [from, to) by path and the deepest scroll depth they reached.
+func (repo *Repository) GetScrollDepths(ctx context.Context, domainID int, from, to time.Time) ([]PageScrollDepth, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return queryScrollDepths(ctx, repo.db, dialectMySQL, domainID, from, to)
+}
+
+// GetClickTargets counts the clicks of a class on a domain's pages created in [from, to) by target, most clicked first.
+func (repo *Repository) GetClickTargets(ctx context.Context, domainID int, class string, from, to time.Time) ([]ClickTargetCount, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return queryClickTargets(ctx, repo.db, dialectMySQL, domainID, class, from, to)
+}
+
+// SaveFormSubmission saves a new form submission to the form_submissions_tb table.
+func (repo *Repository) SaveFormSubmission(ctx context.Context, domainID, pageID int, form Form, visitor Visitor) (int64, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	query, args := formSubmissionsQuery(dialectMySQL, []FormSubmission{{DomainID: domainID, PageID: pageID, CreatedAt: time.Now(), Visitor: visitor, Form: form}})
+	result, err := repo.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// SaveFormSubmissions saves a batch of form submissions to the form_submissions_tb table in a single transaction.
+func (repo *Repository) SaveFormSubmissions(ctx context.Context, submissions []FormSubmission) error {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return saveFormSubmissions(ctx, repo.db, dialectMySQL, submissions)
+}
+
+// GetFormSubmissionCounts counts a domain's form submissions created in [from, to) by page and form, most submitted first.
+func (repo *Repository) GetFormSubmissionCounts(ctx context.Context, domainID int, from, to time.Time) ([]FormSubmissionCount, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return queryFormSubmissionCounts(ctx, repo.db, dialectMySQL, domainID, from, to)
+}
+
+// SaveJSError saves a new JavaScript error to the js_errors_tb table.
+func (repo *Repository) SaveJSError(ctx context.Context, domainID, pageID int, jsError JSError, visitor Visitor) (int64, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	query, args := jsErrorsQuery(dialectMySQL, []JSErrorEvent{{DomainID: domainID, PageID: pageID, CreatedAt: time.Now(), Visitor: visitor, JSError: jsError}})
+	result, err := repo.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// SaveJSErrors saves a batch of JavaScript errors to the js_errors_tb table in a single transaction.
+func (repo *Repository) SaveJSErrors(ctx context.Context, events []JSErrorEvent) error {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return saveJSErrors(ctx, repo.db, dialectMySQL, events)
+}
+
+// GetTopJSErrors groups a domain's JavaScript errors thrown in [from, to) by fingerprint, most thrown first.
+func (repo *Repository) GetTopJSErrors(ctx context.Context, domainID int, from, to time.Time) ([]JSErrorGroup, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return queryTopJSErrors(ctx, repo.db, dialectMySQL, domainID, from, to)
+}
+
+// GetJSErrorCounts counts the times a JavaScript error was thrown on a domain in [from, to) by page and browser.
+func (repo *Repository) GetJSErrorCounts(ctx context.Context, domainID int, fingerprint string, from, to time.Time) ([]JSErrorCount, error) {
+	ctx, cancel := withQueryTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	return queryJSErrorCounts(ctx, repo.db, dialectMySQL, domainID, fingerprint, from, to)
 }
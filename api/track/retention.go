@@ -0,0 +1,206 @@
+package track
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jwtly10/simple-site-tracker/utils/logger"
+)
+
+// RetentionPolicy is how many days of each event type are kept for a site.
+// A value of 0 keeps that event type forever.
+type RetentionPolicy struct {
+	DomainID      int
+	Domain        string
+	PageViewsDays int
+	ClicksDays    int
+	UTMsDays      int
+	EventsDays    int
+	FormsDays     int
+	JSErrorsDays  int
+}
+
+// Days returns the retention period for the event type, or 0 if it is kept forever.
+func (p RetentionPolicy) Days(eventType EventType) int {
+	switch eventType {
+	case EventPageView:
+		return p.PageViewsDays
+	case EventClick:
+		return p.ClicksDays
+	case EventUTM:
+		return p.UTMsDays
+	case EventCustom:
+		return p.EventsDays
+	case EventForm:
+		return p.FormsDays
+	case EventError:
+		return p.JSErrorsDays
+	}
+	return 0
+}
+
+// retentionDays converts a nullable retention column to days, where 0 keeps rows forever.
+func retentionDays(days sql.NullInt64) int {
+	if !days.Valid {
+		return 0
+	}
+	return int(days.Int64)
+}
+
+// nullRetentionDays converts days to a nullable retention column, storing NULL to keep rows forever.
+func nullRetentionDays(days int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(days), Valid: days > 0}
+}
+
+// eventTableFilter returns the table holding the event type, and a condition with a
+// single placeholder matching that table's rows for a domain.
+func eventTableFilter(eventType EventType) (string, string, error) {
+	switch eventType {
+	case EventPageView:
+		return "page_views_tb", "domain_id = ?", nil
+	case EventClick:
+		return "clicks_tb", "page_id IN (SELECT id FROM pages_tb WHERE domain_id = ?)", nil
+	case EventUTM:
+		return "utm_tb", "page_id IN (SELECT id FROM pages_tb WHERE domain_id = ?)", nil
+	case EventCustom:
+		return "events_tb", "domain_id = ?", nil
+	case EventForm:
+		return "form_submissions_tb", "domain_id = ?", nil
+	case EventError:
+		return "js_errors_tb", "domain_id = ?", nil
+	}
+	return "", "", fmt.Errorf("unknown event type %s", eventType)
+}
+
+// retentionColumns are the domains_tb columns holding each event type's retention, in the order scanned.
+const retentionColumns = "page_views_retention_days, clicks_retention_days, utm_retention_days, events_retention_days, form_submissions_retention_days, js_errors_retention_days"
+
+// scanRetentionPolicies reads rows of id, domain and the retentionColumns.
+func scanRetentionPolicies(rows *sql.Rows) ([]RetentionPolicy, error) {
+	defer rows.Close()
+
+	var policies []RetentionPolicy
+	for rows.Next() {
+		var policy RetentionPolicy
+		var pageViews, clicks, utms, events, forms, jsErrors sql.NullInt64
+		if err := rows.Scan(&policy.DomainID, &policy.Domain, &pageViews, &clicks, &utms, &events, &forms, &jsErrors); err != nil {
+			return nil, err
+		}
+		policy.PageViewsDays = retentionDays(pageViews)
+		policy.ClicksDays = retentionDays(clicks)
+		policy.UTMsDays = retentionDays(utms)
+		policy.EventsDays = retentionDays(events)
+		policy.FormsDays = retentionDays(forms)
+		policy.JSErrorsDays = retentionDays(jsErrors)
+		policies = append(policies, policy)
+	}
+
+	return policies, rows.Err()
+}
+
+type RetentionConfig struct {
+	// Interval is how often the retention job runs.
+	Interval time.Duration
+	// BatchSize is the most rows deleted by a single statement, keeping table locks short.
+	BatchSize int
+	// BatchPause is how long to wait between batches, so other writers can acquire locks.
+	BatchPause time.Duration
+}
+
+// RetentionJob periodically deletes events older than each site's retention policy.
+type RetentionJob struct {
+	repo   RepositoryInterface
+	config RetentionConfig
+}
+
+func NewRetentionJob(repo RepositoryInterface, config RetentionConfig) *RetentionJob {
+	if config.Interval <= 0 {
+		config.Interval = time.Hour
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 1000
+	}
+
+	return &RetentionJob{repo: repo, config: config}
+}
+
+// Run runs the retention job every interval until the context is cancelled.
+func (j *RetentionJob) Run(ctx context.Context) {
+	l := logger.Get()
+
+	ticker := time.NewTicker(j.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := j.RunOnce(ctx); err != nil {
+			l.Error().Err(err).Msg("Error pruning expired events")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce deletes all expired events for every site, returning the number of rows deleted.
+func (j *RetentionJob) RunOnce(ctx context.Context) (int64, error) {
+	l := logger.Get()
+
+	policies, err := j.repo.GetRetentionPolicies(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, policy := range policies {
+		for _, eventType := range []EventType{EventPageView, EventClick, EventUTM, EventCustom, EventForm, EventError} {
+			days := policy.Days(eventType)
+			if days <= 0 {
+				continue
+			}
+
+			before := time.Now().UTC().AddDate(0, 0, -days)
+			pruned, err := j.prune(ctx, eventType, policy.DomainID, before)
+			total += pruned
+			if pruned > 0 {
+				l.Info().Msgf("Pruned %d %s events older than %d days for %s", pruned, eventType, days, policy.Domain)
+			}
+			if err != nil {
+				return total, err
+			}
+		}
+	}
+
+	return total, nil
+}
+
+// prune deletes expired events in batches until none are left.
+func (j *RetentionJob) prune(ctx context.Context, eventType EventType, domainID int, before time.Time) (int64, error) {
+	var total int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		deleted, err := j.repo.DeleteExpired(ctx, eventType, domainID, before, j.config.BatchSize)
+		total += deleted
+		if err != nil {
+			return total, err
+		}
+		if deleted < int64(j.config.BatchSize) {
+			return total, nil
+		}
+
+		if j.config.BatchPause > 0 {
+			select {
+			case <-ctx.Done():
+				return total, ctx.Err()
+			case <-time.After(j.config.BatchPause):
+			}
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package track
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	rollupHourFormat = "2006-01-02 15:00:00"
+	rollupDayFormat  = "2006-01-02"
+)
+
+// PageViewRollup is the number of views of a page in an hour or day bucket.
+type PageViewRollup struct {
+	DomainID int
+	PageID   int
+	Bucket   string
+	Views    int
+}
+
+// UTMRollup is the number of hits for a campaign, source and medium in a day.
+type UTMRollup struct {
+	DomainID    int
+	Day         string
+	UTMCampaign string
+	UTMSource   string
+	UTMMedium   string
+	Hits        int
+}
+
+type pageViewBucket struct {
+	domainID int
+	pageID   int
+	bucket   string
+}
+
+type utmBucket struct {
+	domainID int
+	day      string
+	campaign string
+	source   string
+	medium   string
+}
+
+// rollupPageViews counts the page views per domain, page and UTC hour and day.
+// Page views from bots aren't counted.
+func rollupPageViews(pageViews []PageView) (map[pageViewBucket]int, map[pageViewBucket]int) {
+	hourly := make(map[pageViewBucket]int)
+	daily := make(map[pageViewBucket]int)
+	for _, pv := range pageViews {
+		if pv.Bot {
+			continue
+		}
+		createdAt := pv.CreatedAt.UTC()
+		hourly[pageViewBucket{pv.DomainID, pv.PageID, createdAt.Format(rollupHourFormat)}]++
+		daily[pageViewBucket{pv.DomainID, pv.PageID, createdAt.Format(rollupDayFormat)}]++
+	}
+	return hourly, daily
+}
+
+// rollupUTMs counts the UTM hits per domain, UTC day, campaign, source and medium.
+// UTM hits from bots aren't counted.
+func rollupUTMs(utms []UTM) map[utmBucket]int {
+	daily := make(map[utmBucket]int)
+	for _, u := range utms {
+		if u.Bot {
+			continue
+		}
+		daily[utmBucket{u.DomainID, u.CreatedAt.UTC().Format(rollupDayFormat), u.UTMCampaign, u.UTMSource, u.UTMMedium}]++
+	}
+	return daily
+}
+
+// dialect holds the SQL differences between the database backends.
+type dialect int
+
+const (
+	dialectMySQL dialect = iota
+	dialectSQLite
+	dialectPostgres
+)
+
+// rebind rewrites ? placeholders as $1, $2, ... for Postgres.
+func (d dialect) rebind(query string) string {
+	if d != dialectPostgres {
+		return query
+	}
+
+	var sb strings.Builder
+	n := 1
+	for _, r := range query {
+		if r == '?' {
+			sb.WriteString("$" + strconv.Itoa(n))
+			n++
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// timeArg formats a time as a query argument comparable with the created_at columns.
+func (d dialect) timeArg(t time.Time) interface{} {
+	if d == dialectSQLite {
+		return sqliteTime(t)
+	}
+	return t.UTC()
+}
+
+// hourExpr truncates a timestamp column to the hour.
+func (d dialect) hourExpr(column string) string {
+	switch d {
+	case dialectSQLite:
+		return "strftime('%Y-%m-%d %H:00:00', " + column + ")"
+	case dialectPostgres:
+		return "date_trunc('hour', " + column + ")"
+	}
+	return "DATE_FORMAT(" + column + ", '%Y-%m-%d %H:00:00')"
+}
+
+// dayExpr truncates a timestamp column to the date.
+func (d dialect) dayExpr(column string) string {
+	switch d {
+	case dialectSQLite:
+		return "date(" + column + ")"
+	case dialectPostgres:
+		return "CAST(" + column + " AS DATE)"
+	}
+	return "DATE(" + column + ")"
+}
+
+// upsertCountQuery builds a multi-row INSERT which adds countColumn onto existing rows with the same key.
+func (d dialect) upsertCountQuery(table string, keyColumns []string, countColumn string, rows int) string {
+	columns := append(append([]string{}, keyColumns...), countColumn)
+	query := bulkInsertQuery(table, columns, nil, rows, d == dialectPostgres)
+
+	if d == dialectMySQL {
+		return query + " ON DUPLICATE KEY UPDATE " + countColumn + " = " + countColumn + " + VALUES(" + countColumn + ")"
+	}
+	return query + " ON CONFLICT (" + strings.Join(keyColumns, ", ") + ") DO UPDATE SET " +
+		countColumn + " = " + table + "." + countColumn + " + excluded." + countColumn
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// withTx runs fn in a transaction, committing it if fn succeeds.
+// The transaction is rolled back if the context is cancelled before it commits.
+func withTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// writePageViewRollups adds the page views onto the hourly and daily rollup tables.
+func writePageViewRollups(ctx context.Context, tx execer, d dialect, pageViews []PageView) error {
+	if len(pageViews) == 0 {
+		return nil
+	}
+
+	hourly, daily := rollupPageViews(pageViews)
+	if len(daily) == 0 {
+		return nil
+	}
+	for table, buckets := range map[string]map[pageViewBucket]int{"page_views_hourly_tb": hourly, "page_views_daily_tb": daily} {
+		bucketColumn := "hour"
+		if table == "page_views_daily_tb" {
+			bucketColumn = "day"
+		}
+
+		// Sort the rows so concurrent upserts lock them in the same order
+		keys := make([]pageViewBucket, 0, len(buckets))
+		for key := range buckets {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].domainID != keys[j].domainID {
+				return keys[i].domainID < keys[j].domainID
+			}
+			if keys[i].pageID != keys[j].pageID {
+				return keys[i].pageID < keys[j].pageID
+			}
+			return keys[i].bucket < keys[j].bucket
+		})
+
+		args := make([]interface{}, 0, len(keys)*4)
+		for _, key := range keys {
+			args = append(args, key.domainID, key.pageID, key.bucket, buckets[key])
+		}
+
+		query := d.upsertCountQuery(table, []string{"domain_id", "page_id", bucketColumn}, "views", len(keys))
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeUTMRollups adds the UTM hits onto the daily UTM rollup table.
+func writeUTMRollups(ctx context.Context, tx execer, d dialect, utms []UTM) error {
+	if len(utms) == 0 {
+		return nil
+	}
+
+	daily := rollupUTMs(utms)
+	if len(daily) == 0 {
+		return nil
+	}
+	keys := make([]utmBucket, 0, len(daily))
+	for key := range daily {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.domainID != b.domainID {
+			return a.domainID < b.domainID
+		}
+		if a.day != b.day {
+			return a.day < b.day
+		}
+		if a.campaign != b.campaign {
+			return a.campaign < b.campaign
+		}
+		if a.source != b.source {
+			return a.source < b.source
+		}
+		return a.medium < b.medium
+	})
+
+	args := make([]interface{}, 0, len(keys)*6)
+	for _, key := range keys {
+		args = append(args, key.domainID, key.day, key.campaign, key.source, key.medium, daily[key])
+	}
+
+	query := d.upsertCountQuery("utm_daily_tb", []string{"domain_id", "day", "utm_campaign", "utm_source", "utm_medium"}, "hits", len(keys))
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// rebuildRollups replaces the rollups for the UTC days in [from, to) with counts from the raw event tables.
+// It is not bounded by the query timeout, as backfilling a long range can take a while.
+func rebuildRollups(ctx context.Context, db *sql.DB, d dialect, from, to time.Time) error {
+	from = from.UTC().Truncate(24 * time.Hour)
+	to = to.UTC().Truncate(24 * time.Hour)
+	fromDay, toDay := from.Format(rollupDayFormat), to.Format(rollupDayFormat)
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"DELETE FROM page_views_hourly_tb WHERE hour >= ? AND hour < ?", []interface{}{from.Format(rollupHourFormat), to.Format(rollupHourFormat)}},
+		{"DELETE FROM page_views_daily_tb WHERE day >= ? AND day < ?", []interface{}{fromDay, toDay}},
+		{"DELETE FROM utm_daily_tb WHERE day >= ? AND day < ?", []interface{}{fromDay, toDay}},
+		{
+			"INSERT INTO page_views_hourly_tb (domain_id, page_id, hour, views) " +
+				"SELECT domain_id, page_id, " + d.hourExpr("created_at") + ", COUNT(*) FROM page_views_tb " +
+				"WHERE created_at >= ? AND created_at < ? AND NOT is_bot GROUP BY domain_id, page_id, " + d.hourExpr("created_at"),
+			[]interface{}{d.timeArg(from), d.timeArg(to)},
+		},
+		{
+			"INSERT INTO page_views_daily_tb (domain_id, page_id, day, views) " +
+				"SELECT domain_id, page_id, " + d.dayExpr("created_at") + ", COUNT(*) FROM page_views_tb " +
+				"WHERE created_at >= ? AND created_at < ? AND NOT is_bot GROUP BY domain_id, page_id, " + d.dayExpr("created_at"),
+			[]interface{}{d.timeArg(from), d.timeArg(to)},
+		},
+		{
+			"INSERT INTO utm_daily_tb (domain_id, day, utm_campaign, utm_source, utm_medium, hits) " +
+				"SELECT p.domain_id, " + d.dayExpr("u.created_at") + ", COALESCE(u.utm_campaign, ''), COALESCE(u.utm_source, ''), COALESCE(u.utm_medium, ''), COUNT(*) " +
+				"FROM utm_tb u JOIN pages_tb p ON p.id = u.page_id WHERE u.created_at >= ? AND u.created_at < ? AND NOT u.is_bot " +
+				"GROUP BY p.domain_id, " + d.dayExpr("u.created_at") + ", COALESCE(u.utm_campaign, ''), COALESCE(u.utm_source, ''), COALESCE(u.utm_medium, '')",
+			[]interface{}{d.timeArg(from), d.timeArg(to)},
+		},
+	}
+
+	return withTx(ctx, db, func(tx *sql.Tx) error {
+		for _, stmt := range statements {
+			if _, err := tx.ExecContext(ctx, d.rebind(stmt.query), stmt.args...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
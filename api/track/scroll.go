@@ -0,0 +1,168 @@
+package track
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/jwtly10/simple-site-tracker/utils/logger"
+)
+
+// ScrollDepths are the buckets of how far down a page a page view scrolled, as percentages.
+// A page view which hasn't reached the first bucket has a depth of 0.
+var ScrollDepths = []int{25, 50, 75, 100}
+
+// ScrollDepth is a bucket reached by the page view with the view ID on the domain,
+// ready to be kept on the page view if it is deeper than it has reached before.
+type ScrollDepth struct {
+	DomainID int
+	ViewID   string
+	Depth    int
+}
+
+// PageScrollDepth is how far down a path its page views scrolled.
+// Depths counts the page views by the deepest bucket they reached, keyed by 0 and each of ScrollDepths.
+// Only page views with a view ID, whose script reports scroll depth, are counted.
+type PageScrollDepth struct {
+	Page   string
+	Views  int64
+	Depths map[int]int64
+}
+
+// ValidateScrollDepth checks a scroll event has a view ID and a depth of one of ScrollDepths.
+func ValidateScrollDepth(viewID string, depth int) error {
+	if viewID == "" {
+		return fmt.Errorf("missing view ID")
+	}
+	if err := ValidateViewID(viewID); err != nil {
+		return err
+	}
+	for _, d := range ScrollDepths {
+		if depth == d {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid scroll depth %d, expected one of %v", depth, ScrollDepths)
+}
+
+type TrackScrollRequest struct {
+	ViewID      string `json:"view_id"`
+	ScrollDepth int    `json:"scroll_depth"`
+	URL         string `json:"url"`
+}
+
+// TrackScrollHandler handles a scroll depth bucket reached by a page view, which is kept on the page view with its view ID
+// if it is the deepest it has reached.
+// It returns a 400 status code if the view ID or depth are invalid.
+func (h *Handlers) TrackScrollHandler(w http.ResponseWriter, r *http.Request) {
+	l := logger.Get()
+	if r.Method != http.MethodPost {
+		l.Warn().Msgf("Invalid method: %s", r.Method)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var scroll TrackScrollRequest
+	if !decodeRequest(w, r, &scroll) {
+		return
+	}
+
+	if err := ValidateScrollDepth(scroll.ViewID, scroll.ScrollDepth); err != nil {
+		l.Error().Msgf("Invalid scroll depth: %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		l.Error().Msg("Missing Origin header")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	h.handleEvent(w, r, Event{
+		Type:        EventScroll,
+		Domain:      getDomainFromOrigin(origin),
+		Page:        getPageFromURL(scroll.URL),
+		CreatedAt:   time.Now(),
+		ViewID:      scroll.ViewID,
+		ScrollDepth: scroll.ScrollDepth,
+	})
+}
+
+// saveScrollDepths keeps the depth of each scroll on its page view in one transaction, unless the page view has already
+// scrolled deeper, so reports arriving out of order can't lower it.
+// Scrolls for page views which don't exist, such as those of dropped bots, are ignored.
+func saveScrollDepths(ctx context.Context, db *sql.DB, d dialect, scrolls []ScrollDepth) error {
+	return withTx(ctx, db, func(tx *sql.Tx) error {
+		query := d.rebind("UPDATE page_views_tb SET scroll_depth = ? WHERE domain_id = ? AND view_id = ? AND scroll_depth < ?")
+		for _, s := range scrolls {
+			if _, err := tx.ExecContext(ctx, query, s.Depth, s.DomainID, s.ViewID, s.Depth); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// queryScrollDepths counts a domain's page views created in [from, to) by path and the deepest bucket they reached,
+// most viewed paths first.
+func queryScrollDepths(ctx context.Context, db *sql.DB, d dialect, domainID int, from, to time.Time) ([]PageScrollDepth, error) {
+	query := "SELECT p.page_url, pv.scroll_depth, COUNT(*) FROM page_views_tb pv JOIN pages_tb p ON p.id = pv.page_id " +
+		"WHERE pv.domain_id = ? AND pv.created_at >= ? AND pv.created_at < ? AND pv.view_id IS NOT NULL AND NOT pv.is_bot " +
+		"GROUP BY p.page_url, pv.scroll_depth"
+
+	rows, err := db.QueryContext(ctx, d.rebind(query), domainID, d.timeArg(from), d.timeArg(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pages := make(map[string]*PageScrollDepth)
+	for rows.Next() {
+		var page string
+		var depth int
+		var views int64
+		if err := rows.Scan(&page, &depth, &views); err != nil {
+			return nil, err
+		}
+		addScrollDepth(pages, page, depth, views)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sortedScrollDepths(pages), nil
+}
+
+// addScrollDepth adds page views of a path which reached a depth to its distribution.
+func addScrollDepth(pages map[string]*PageScrollDepth, page string, depth int, views int64) {
+	p, ok := pages[page]
+	if !ok {
+		p = &PageScrollDepth{Page: page, Depths: map[int]int64{0: 0}}
+		for _, d := range ScrollDepths {
+			p.Depths[d] = 0
+		}
+		pages[page] = p
+	}
+	p.Views += views
+	p.Depths[depth] += views
+}
+
+// sortedScrollDepths returns the distribution of each path, most viewed first, then by path.
+func sortedScrollDepths(pages map[string]*PageScrollDepth) []PageScrollDepth {
+	var depths []PageScrollDepth
+	for _, p := range pages {
+		depths = append(depths, *p)
+	}
+	sort.Slice(depths, func(i, j int) bool {
+		if depths[i].Views != depths[j].Views {
+			return depths[i].Views > depths[j].Views
+		}
+		return depths[i].Page < depths[j].Page
+	})
+	return depths
+}
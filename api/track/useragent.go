@@ -0,0 +1,229 @@
+package track
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mssola/useragent"
+)
+
+// Device classes of a user agent.
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+)
+
+// maxUserAgentFieldLength is the longest parsed user agent field stored, matching the column sizes.
+const maxUserAgentFieldLength = 64
+
+// UserAgent is the browser, operating system and device class parsed from a User-Agent header.
+// BrowserVersion is the major version only, so versions group together in breakdowns.
+// Every field is empty if the header couldn't be parsed.
+type UserAgent struct {
+	Browser        string `json:"browser,omitempty"`
+	BrowserVersion string `json:"browser_version,omitempty"`
+	OS             string `json:"os,omitempty"`
+	Device         string `json:"device,omitempty"`
+}
+
+// browserOverrides are browsers the parser reports as another browser, matched by their product token.
+var browserOverrides = []struct {
+	token, name string
+}{
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"YaBrowser/", "Yandex Browser"},
+	{"Vivaldi/", "Vivaldi"},
+	{"DuckDuckGo/", "DuckDuckGo"},
+}
+
+// osNames maps the operating system names reported by the parser to their common names.
+var osNames = map[string]string{
+	"Mac OS X":  "macOS",
+	"iPhone OS": "iOS",
+}
+
+// ParseUserAgent parses the browser, operating system and device class from a User-Agent header.
+// It runs offline, without any lookups.
+func ParseUserAgent(header string) UserAgent {
+	ua := useragent.New(header)
+
+	browser, version := ua.Browser()
+	for _, override := range browserOverrides {
+		if i := strings.Index(header, override.token); i >= 0 {
+			browser = override.name
+			version, _, _ = strings.Cut(header[i+len(override.token):], " ")
+			break
+		}
+	}
+	if browser == "" {
+		return UserAgent{}
+	}
+
+	return UserAgent{
+		Browser:        truncate(browser, maxUserAgentFieldLength),
+		BrowserVersion: truncate(majorVersion(version), maxUserAgentFieldLength),
+		OS:             truncate(osName(ua), maxUserAgentFieldLength),
+		Device:         deviceClass(ua, header),
+	}
+}
+
+// osName returns the common name of the user agent's operating system.
+func osName(ua *useragent.UserAgent) string {
+	switch ua.Platform() {
+	case "iPhone", "iPad", "iPod", "iPod touch":
+		return "iOS"
+	}
+
+	name := ua.OSInfo().Name
+	if common, ok := osNames[name]; ok {
+		return common
+	}
+	if strings.HasPrefix(name, "CrOS") {
+		return "Chrome OS"
+	}
+	return name
+}
+
+// deviceClass returns whether the user agent is a desktop, mobile or tablet browser.
+// Android tablets are told apart from phones by the Mobile token, which only phones send.
+// iPads which request desktop sites send a macOS user agent, so they count as desktops.
+func deviceClass(ua *useragent.UserAgent, header string) string {
+	switch {
+	case ua.Platform() == "iPad",
+		strings.Contains(header, "Android") && !strings.Contains(header, "Mobile"),
+		strings.Contains(header, "Tablet"),
+		strings.Contains(header, "Kindle"),
+		strings.Contains(header, "Silk/"):
+		return DeviceTablet
+	case ua.Mobile():
+		return DeviceMobile
+	}
+	return DeviceDesktop
+}
+
+func majorVersion(version string) string {
+	major, _, _ := strings.Cut(version, ".")
+	return major
+}
+
+// Dimensions page views can be broken down by.
+const (
+	DimensionBrowser        = "browser"
+	DimensionBrowserVersion = "browser_version"
+	DimensionOS             = "os"
+	DimensionDevice         = "device"
+	DimensionCountry        = "country"
+	DimensionRegion         = "region"
+	DimensionCity           = "city"
+)
+
+// DimensionCount is the number of page views with a value of a dimension.
+// Value is empty for page views the dimension is unknown for.
+type DimensionCount struct {
+	Value string
+	Count int64
+}
+
+// dimensionColumns returns the columns of page_views_tb grouped by for a dimension.
+// Browser versions are grouped with their browser, so the same version of different browsers is kept apart,
+// and regions and cities are grouped with the places they are in, most specific first.
+func dimensionColumns(dimension string) ([]string, error) {
+	switch dimension {
+	case DimensionBrowser, DimensionOS, DimensionDevice, DimensionCountry:
+		return []string{dimension}, nil
+	case DimensionBrowserVersion:
+		return []string{DimensionBrowser, DimensionBrowserVersion}, nil
+	case DimensionRegion:
+		return []string{DimensionRegion, DimensionCountry}, nil
+	case DimensionCity:
+		return []string{DimensionCity, DimensionRegion, DimensionCountry}, nil
+	}
+	return nil, fmt.Errorf("unknown dimension %q", dimension)
+}
+
+// dimensionValue joins the values of a dimension's columns, e.g. Chrome 120 or London, England, GB.
+// A region or city is unknown if the region or city itself is, even if the country is known.
+func dimensionValue(dimension string, values ...string) string {
+	switch dimension {
+	case DimensionRegion, DimensionCity:
+		if values[0] == "" {
+			return ""
+		}
+		var known []string
+		for _, value := range values {
+			if value != "" {
+				known = append(known, value)
+			}
+		}
+		return strings.Join(known, ", ")
+	}
+	return strings.TrimSpace(strings.Join(values, " "))
+}
+
+// queryDimensionCounts counts the page views of a domain created in [from, to) by the value of the dimension,
+// most common first.
+func queryDimensionCounts(ctx context.Context, db *sql.DB, d dialect, domainID int, dimension string, from, to time.Time) ([]DimensionCount, error) {
+	columns, err := dimensionColumns(dimension)
+	if err != nil {
+		return nil, err
+	}
+
+	selects := make([]string, len(columns))
+	for i, column := range columns {
+		selects[i] = "COALESCE(" + column + ", '')"
+	}
+	query := "SELECT " + strings.Join(selects, ", ") + ", COUNT(*) AS hits FROM page_views_tb " +
+		"WHERE domain_id = ? AND created_at >= ? AND created_at < ? AND NOT is_bot " +
+		"GROUP BY " + strings.Join(selects, ", ") + " ORDER BY hits DESC, " + strings.Join(selects, ", ")
+
+	rows, err := db.QueryContext(ctx, d.rebind(query), domainID, d.timeArg(from), d.timeArg(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Groups with an unknown region or city all have the empty value, so they are merged
+	var counts []DimensionCount
+	index := make(map[string]int)
+	values := make([]string, len(columns))
+	dest := make([]interface{}, len(columns)+1)
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		var hits int64
+		dest[len(columns)] = &hits
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		value := dimensionValue(dimension, values...)
+		if i, ok := index[value]; ok {
+			counts[i].Count += hits
+			continue
+		}
+		index[value] = len(counts)
+		counts = append(counts, DimensionCount{Value: value, Count: hits})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sortDimensionCounts(counts)
+	return counts, nil
+}
+
+// sortDimensionCounts sorts the counts most common first, then by value.
+func sortDimensionCounts(counts []DimensionCount) {
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Value < counts[j].Value
+	})
+}
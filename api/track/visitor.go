@@ -0,0 +1,177 @@
+package track
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/jwtly10/simple-site-tracker/utils/logger"
+)
+
+// VisitorCounts is the number of page views, distinct visitors and distinct sessions of a site.
+// Visitor IDs rotate daily, so a person who visits on several days is counted once per day.
+type VisitorCounts struct {
+	PageViews int64
+	Visitors  int64
+	Sessions  int64
+}
+
+type VisitorConfig struct {
+	// SessionTimeout is how long a visitor can be inactive before their next event starts a new session.
+	SessionTimeout time.Duration
+}
+
+type session struct {
+	id       string
+	lastSeen time.Time
+}
+
+// Visitors assigns cookieless visitor and session IDs to events.
+// The salt for each UTC day is stored in the repository, so every server agrees on it and it survives
+// restarts, and the previous days' salts are deleted so visitor IDs can't be linked across days.
+// Sessions are tracked in memory.
+type Visitors struct {
+	repo   RepositoryInterface
+	config VisitorConfig
+
+	mu        sync.Mutex
+	day       string
+	salt      string
+	saltSaved bool
+	saltRetry time.Time
+	sessions  map[string]*session
+	lastSweep time.Time
+}
+
+// saltRetryInterval is how long to wait before trying to store the day's salt again after an error.
+const saltRetryInterval = time.Minute
+
+func NewVisitors(repo RepositoryInterface, config VisitorConfig) *Visitors {
+	if config.SessionTimeout <= 0 {
+		config.SessionTimeout = 30 * time.Minute
+	}
+
+	return &Visitors{
+		repo:     repo,
+		config:   config,
+		sessions: make(map[string]*session),
+	}
+}
+
+// Identify returns the visitor and session IDs for an event from the client IP address and user agent on a site.
+func (v *Visitors) Identify(ctx context.Context, domain, ip, userAgent string, at time.Time) Visitor {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	hash := sha256.Sum256([]byte(v.daySalt(ctx, at) + "\x00" + domain + "\x00" + ip + "\x00" + userAgent))
+	visitorID := hex.EncodeToString(hash[:16])
+
+	v.sweep(at)
+
+	s, ok := v.sessions[visitorID]
+	if !ok || at.Sub(s.lastSeen) > v.config.SessionTimeout {
+		s = &session{id: randomHex(16)}
+		v.sessions[visitorID] = s
+	}
+	if at.After(s.lastSeen) {
+		s.lastSeen = at
+	}
+
+	return Visitor{VisitorID: visitorID, SessionID: s.id}
+}
+
+// daySalt returns the salt for the UTC day of at. v.mu must be held.
+// If the salt can't be stored, a local salt is used until it can be, so events are still identified.
+func (v *Visitors) daySalt(ctx context.Context, at time.Time) string {
+	l := logger.Get()
+
+	day := at.UTC().Format(rollupDayFormat)
+	if day != v.day {
+		v.day = day
+		v.salt = randomHex(32)
+		v.saltSaved = false
+		v.saltRetry = time.Time{}
+	}
+
+	if !v.saltSaved && !at.Before(v.saltRetry) {
+		salt, err := v.repo.GetOrCreateVisitorSalt(ctx, day, v.salt)
+		if err != nil {
+			l.Error().Err(err).Msgf("Error getting visitor salt for %s, using a local salt", day)
+			v.saltRetry = at.Add(saltRetryInterval)
+			return v.salt
+		}
+		v.salt = salt
+		v.saltSaved = true
+	}
+
+	return v.salt
+}
+
+// sweep forgets expired sessions, at most once per session timeout. v.mu must be held.
+func (v *Visitors) sweep(at time.Time) {
+	if at.Sub(v.lastSweep) < v.config.SessionTimeout {
+		return
+	}
+	v.lastSweep = at
+
+	for visitorID, s := range v.sessions {
+		if at.Sub(s.lastSeen) > v.config.SessionTimeout {
+			delete(v.sessions, visitorID)
+		}
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// nullString converts a string to a nullable column, storing NULL for an empty string.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// getOrCreateVisitorSalt stores the salt for the day unless one already is, and deletes the salts of earlier days.
+// It returns the stored salt.
+func getOrCreateVisitorSalt(ctx context.Context, db *sql.DB, d dialect, day, salt string) (string, error) {
+	insert := "INSERT INTO visitor_salts_tb (day, salt) VALUES (?, ?) ON CONFLICT (day) DO NOTHING"
+	if d == dialectMySQL {
+		insert = "INSERT IGNORE INTO visitor_salts_tb (day, salt) VALUES (?, ?)"
+	}
+
+	var stored string
+	err := withTx(ctx, db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, d.rebind(insert), day, salt); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, d.rebind("DELETE FROM visitor_salts_tb WHERE day < ?"), day); err != nil {
+			return err
+		}
+		return tx.QueryRowContext(ctx, d.rebind("SELECT salt FROM visitor_salts_tb WHERE day = ?"), day).Scan(&stored)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return stored, nil
+}
+
+// queryVisitorCounts counts the page views, visitors and sessions of a domain created in [from, to).
+func queryVisitorCounts(ctx context.Context, db *sql.DB, d dialect, domainID int, from, to time.Time) (VisitorCounts, error) {
+	var counts VisitorCounts
+	err := db.QueryRowContext(ctx, d.rebind("SELECT COUNT(*), COUNT(DISTINCT visitor_id), COUNT(DISTINCT session_id) FROM page_views_tb "+
+		"WHERE domain_id = ? AND created_at >= ? AND created_at < ? AND NOT is_bot"), domainID, d.timeArg(from), d.timeArg(to)).
+		Scan(&counts.PageViews, &counts.Visitors, &counts.Sessions)
+	if err != nil {
+		return VisitorCounts{}, err
+	}
+
+	return counts, nil
+}
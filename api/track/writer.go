@@ -0,0 +1,460 @@
+package track
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jwtly10/simple-site-tracker/utils/logger"
+)
+
+var (
+	ErrQueueFull        = errors.New("event queue is full")
+	ErrWriterClosed     = errors.New("event writer is closed")
+	ErrUnknownDomain    = errors.New("unknown domain")
+	ErrUnknownEventType = errors.New("unknown event type")
+	ErrBotDropped       = errors.New("event from bot dropped")
+)
+
+type WriterConfig struct {
+	// QueueSize is the number of events which can be buffered before Enqueue rejects new events.
+	QueueSize int
+	// BatchSize is the number of events which triggers a flush.
+	BatchSize int
+	// FlushInterval is the longest an event waits in the buffer before being flushed.
+	FlushInterval time.Duration
+	// Spooler, if set, stores events which fail to save so they can be replayed.
+	Spooler Spooler
+}
+
+// WriterStats is a snapshot of the EventWriter counters.
+type WriterStats struct {
+	QueueDepth    int   `json:"queue_depth"`
+	QueueCapacity int   `json:"queue_capacity"`
+	Enqueued      int64 `json:"enqueued"`
+	Rejected      int64 `json:"rejected"`
+	Written       int64 `json:"written"`
+	Spooled       int64 `json:"spooled"`
+	Dropped       int64 `json:"dropped"`
+	Failed        int64 `json:"failed"`
+}
+
+// EventWriter buffers tracking events from the handlers and writes them to the
+// repository in multi-row batches, flushing when a batch is full or the flush interval passes.
+type EventWriter struct {
+	repo   RepositoryInterface
+	config WriterConfig
+
+	mu      sync.RWMutex
+	started bool
+	closed  bool
+	queue   chan Event
+	done    chan struct{}
+
+	// ctx is passed to the repository, and cancelled if Close gives up waiting for the drain
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	enqueued atomic.Int64
+	rejected atomic.Int64
+	written  atomic.Int64
+	spooled  atomic.Int64
+	dropped  atomic.Int64
+	failed   atomic.Int64
+}
+
+func NewEventWriter(repo RepositoryInterface, config WriterConfig) *EventWriter {
+	if config.QueueSize <= 0 {
+		config.QueueSize = 10000
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &EventWriter{
+		repo:   repo,
+		config: config,
+		queue:  make(chan Event, config.QueueSize),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Start starts the background flush loop.
+func (w *EventWriter) Start() {
+	w.mu.Lock()
+	w.started = true
+	w.mu.Unlock()
+
+	go w.run()
+}
+
+// Enqueue adds an event to the buffer without blocking.
+// It returns ErrQueueFull if the buffer is full, so callers can shed load.
+func (w *EventWriter) Enqueue(event Event) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return ErrWriterClosed
+	}
+
+	select {
+	case w.queue <- event:
+		w.enqueued.Add(1)
+		return nil
+	default:
+		w.rejected.Add(1)
+		return ErrQueueFull
+	}
+}
+
+// Close stops accepting events and waits for the buffered events to be flushed.
+// If the context is done before the drain finishes, the in-flight queries are cancelled
+// and the remaining events are spooled, and the context error is returned.
+func (w *EventWriter) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	started := w.started
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		w.cancel()
+		return nil
+	case <-ctx.Done():
+		w.cancel()
+		if started {
+			<-w.done
+		}
+		return ctx.Err()
+	}
+}
+
+// Stats returns the current queue depth and event counters.
+func (w *EventWriter) Stats() WriterStats {
+	return WriterStats{
+		QueueDepth:    len(w.queue),
+		QueueCapacity: cap(w.queue),
+		Enqueued:      w.enqueued.Load(),
+		Rejected:      w.rejected.Load(),
+		Written:       w.written.Load(),
+		Spooled:       w.spooled.Load(),
+		Dropped:       w.dropped.Load(),
+		Failed:        w.failed.Load(),
+	}
+}
+
+func (w *EventWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Event, 0, w.config.BatchSize)
+	for {
+		select {
+		case event, ok := <-w.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+
+			batch = append(batch, event)
+			if len(batch) >= w.config.BatchSize {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// flush writes the batch, spooling any events which failed because the repository was unavailable.
+func (w *EventWriter) flush(batch []Event) {
+	l := logger.Get()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	written, dropped, retry, rejected := WriteEvents(w.ctx, w.repo, batch)
+	w.written.Add(int64(written))
+	w.dropped.Add(int64(dropped))
+	w.failed.Add(int64(len(rejected)))
+
+	if len(retry) == 0 {
+		return
+	}
+
+	if w.config.Spooler == nil {
+		w.failed.Add(int64(len(retry)))
+		return
+	}
+
+	if err := w.config.Spooler.Spool(eventsAt(batch, retry)); err != nil {
+		l.Error().Err(err).Msgf("Error spooling %d events, events lost", len(retry))
+		w.failed.Add(int64(len(retry)))
+		return
+	}
+
+	l.Warn().Msgf("Spooled %d events for replay", len(retry))
+	w.spooled.Add(int64(len(retry)))
+}
+
+// WriteEvents resolves the domain and page of each event and bulk inserts them by type.
+// It returns the number of events written and dropped, the indexes of the events which failed because the
+// repository was unavailable and may succeed if retried, and the indexes of the events the
+// repository rejected, which would fail again. Events for unknown domains or of unknown types are dropped,
+// as are events from bots for domains which drop them. Client IP addresses are anonymised in place
+// once the site's IP mode is known, so retried events can be spooled without them.
+func WriteEvents(ctx context.Context, repo RepositoryInterface, events []Event) (written, dropped int, retry, rejected []int) {
+	for i, err := range writeEvents(ctx, repo, events) {
+		switch {
+		case err == nil:
+			written++
+		case errors.Is(err, ErrUnknownDomain) || errors.Is(err, ErrUnknownEventType) || errors.Is(err, ErrBotDropped):
+			dropped++
+		case isTransient(err):
+			retry = append(retry, i)
+		default:
+			rejected = append(rejected, i)
+		}
+	}
+
+	return written, dropped, retry, rejected
+}
+
+// eventsAt returns the events at the indexes.
+func eventsAt(events []Event, indexes []int) []Event {
+	picked := make([]Event, len(indexes))
+	for j, i := range indexes {
+		picked[j] = events[i]
+	}
+	return picked
+}
+
+// writeEvents is WriteEvents, returning the outcome of each event.
+// Events which were dropped fail with ErrUnknownDomain, ErrUnknownEventType or ErrBotDropped,
+// and any other error is a repository error.
+func writeEvents(ctx context.Context, repo RepositoryInterface, events []Event) []error {
+	l := logger.Get()
+
+	domains := make(map[string]int)
+	pages := make(map[pageKey]int)
+	botModes := make(map[int]string)
+	ipPolicies := make(map[int]IPPolicy)
+	ipAddresses := make(map[string]int)
+
+	errs := make([]error, len(events))
+	var pageViews, clicks, utms, customEvents, forms, jsErrors, engagements, scrolls []int
+	var pageViewRows []PageView
+	var clickRows []Click
+	var utmRows []UTM
+	var customEventRows []CustomEvent
+	var formRows []FormSubmission
+	var jsErrorRows []JSErrorEvent
+	var engagementRows []Engagement
+	var scrollRows []ScrollDepth
+
+	for i, event := range events {
+		domainId, ok := domains[event.Domain]
+		if !ok {
+			id, err := repo.GetDomain(ctx, event.Domain)
+			if errors.Is(err, sql.ErrNoRows) || (err == nil && id == 0) {
+				l.Error().Msgf("Dropping %s event for unknown domain %s", event.Type, event.Domain)
+				errs[i] = ErrUnknownDomain
+				continue
+			} else if err != nil {
+				l.Error().Err(err).Msgf("Error getting domain %s", event.Domain)
+				errs[i] = err
+				continue
+			}
+			domainId = id
+			domains[event.Domain] = id
+		}
+
+		if event.Bot {
+			mode, ok := botModes[domainId]
+			if !ok {
+				m, err := repo.GetBotMode(ctx, domainId)
+				if err != nil {
+					l.Error().Err(err).Msgf("Error getting bot mode of domain %s", event.Domain)
+					errs[i] = err
+					continue
+				}
+				mode = m
+				botModes[domainId] = m
+			}
+			if mode == BotModeDrop {
+				l.Info().Msgf("Dropping %s event from bot for domain %s", event.Type, event.Domain)
+				errs[i] = ErrBotDropped
+				continue
+			}
+		}
+
+		// Engagement and scroll events only update their page view, so don't need an IP address or page
+		switch event.Type {
+		case EventEngagement:
+			engagements = append(engagements, i)
+			engagementRows = append(engagementRows, Engagement{DomainID: domainId, ViewID: event.ViewID, Seconds: event.EngagedSeconds})
+			continue
+		case EventScroll:
+			scrolls = append(scrolls, i)
+			scrollRows = append(scrollRows, ScrollDepth{DomainID: domainId, ViewID: event.ViewID, Depth: event.ScrollDepth})
+			continue
+		}
+
+		if event.ClientIP != "" {
+			policy, ok := ipPolicies[domainId]
+			if !ok {
+				p, err := repo.GetIPPolicy(ctx, domainId)
+				if err != nil {
+					l.Error().Err(err).Msgf("Error getting IP policy of domain %s", event.Domain)
+					errs[i] = err
+					continue
+				}
+				policy = p
+				ipPolicies[domainId] = p
+			}
+
+			// Anonymise the caller's event, so it doesn't hold the raw address if it is spooled for retry
+			anonymiseClientIP(&events[i], policy)
+			event = events[i]
+		}
+
+		// Replayed events were anonymised before they were spooled
+		if event.IPAddress != "" {
+			ipAddressId, ok := ipAddresses[event.IPAddress]
+			if !ok {
+				id, err := saveIPAddress(ctx, repo, event.IPAddress)
+				if err != nil {
+					l.Error().Err(err).Msg("Error saving IP address")
+					errs[i] = err
+					continue
+				}
+				ipAddressId = id
+				ipAddresses[event.IPAddress] = id
+			}
+			event.IPAddressID = ipAddressId
+		}
+
+		key := pageKey{domainId: domainId, page: event.Page}
+		pageId, ok := pages[key]
+		if !ok {
+			id, err := repo.GetOrCreatePage(ctx, domainId, event.Page)
+			if err != nil {
+				l.Error().Err(err).Msgf("Error resolving page %s", event.Page)
+				errs[i] = err
+				continue
+			}
+			pageId = id
+			pages[key] = id
+		}
+
+		switch event.Type {
+		case EventPageView:
+			pageViews = append(pageViews, i)
+			pageViewRows = append(pageViewRows, PageView{DomainID: domainId, PageID: pageId, ViewID: event.ViewID, CreatedAt: event.CreatedAt, Visitor: event.Visitor, Referrer: event.Referrer})
+		case EventClick:
+			clicks = append(clicks, i)
+			clickRows = append(clickRows, Click{PageID: pageId, Element: event.Element, CreatedAt: event.CreatedAt, Visitor: event.Visitor, ClickTarget: event.ClickTarget})
+		case EventUTM:
+			utms = append(utms, i)
+			utmRows = append(utmRows, UTM{
+				DomainID:    domainId,
+				PageID:      pageId,
+				UTMSource:   event.UTMSource,
+				UTMMedium:   event.UTMMedium,
+				UTMCampaign: event.UTMCampaign,
+				Track:       event.Track,
+				CreatedAt:   event.CreatedAt,
+				Visitor:     event.Visitor,
+			})
+		case EventCustom:
+			customEvents = append(customEvents, i)
+			customEventRows = append(customEventRows, CustomEvent{
+				DomainID:  domainId,
+				PageID:    pageId,
+				Name:      event.Name,
+				Props:     event.Props,
+				CreatedAt: event.CreatedAt,
+				Visitor:   event.Visitor,
+			})
+		case EventForm:
+			forms = append(forms, i)
+			formRows = append(formRows, FormSubmission{DomainID: domainId, PageID: pageId, CreatedAt: event.CreatedAt, Visitor: event.Visitor, Form: event.Form})
+		case EventError:
+			jsErrors = append(jsErrors, i)
+			jsErrorRows = append(jsErrorRows, JSErrorEvent{DomainID: domainId, PageID: pageId, CreatedAt: event.CreatedAt, Visitor: event.Visitor, JSError: event.JSError})
+		default:
+			l.Error().Msgf("Dropping event with unknown type %s", event.Type)
+			errs[i] = ErrUnknownEventType
+		}
+	}
+
+	for _, group := range []struct {
+		kind   string
+		events []int
+		// save saves the rows of events[i:j]
+		save func(i, j int) error
+	}{
+		{"page view", pageViews, func(i, j int) error { return repo.SavePageViews(ctx, pageViewRows[i:j]) }},
+		{"click", clicks, func(i, j int) error { return repo.SaveClicks(ctx, clickRows[i:j]) }},
+		{"UTM", utms, func(i, j int) error { return repo.SaveUTMs(ctx, utmRows[i:j]) }},
+		{"custom", customEvents, func(i, j int) error { return repo.SaveCustomEvents(ctx, customEventRows[i:j]) }},
+		{"form", forms, func(i, j int) error { return repo.SaveFormSubmissions(ctx, formRows[i:j]) }},
+		{"error", jsErrors, func(i, j int) error { return repo.SaveJSErrors(ctx, jsErrorRows[i:j]) }},
+		// After the page views, so engagement and scrolls sent in the same batch as their page view update it
+		{"engagement", engagements, func(i, j int) error { return repo.AddEngagements(ctx, engagementRows[i:j]) }},
+		{"scroll", scrolls, func(i, j int) error { return repo.SaveScrollDepths(ctx, scrollRows[i:j]) }},
+	} {
+		if len(group.events) == 0 {
+			continue
+		}
+
+		err := group.save(0, len(group.events))
+		if err == nil {
+			l.Info().Msgf("Saved batch of %d %s events", len(group.events), group.kind)
+			continue
+		}
+
+		l.Error().Err(err).Msgf("Error saving batch of %d %s events", len(group.events), group.kind)
+		if isTransient(err) || len(group.events) == 1 {
+			for _, i := range group.events {
+				errs[i] = err
+			}
+			continue
+		}
+
+		// The repository rejected the batch, so save its events one at a time for only the events it rejects to fail
+		for j, i := range group.events {
+			if errs[i] = group.save(j, j+1); errs[i] != nil {
+				l.Error().Err(errs[i]).Msgf("Error saving %s event", group.kind)
+			}
+		}
+	}
+
+	return errs
+}
+
+type pageKey struct {
+	domainId int
+	page     string
+}
@@ -2,41 +2,188 @@ package config
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
 )
 
+const (
+	DriverMySQL    = "mysql"
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// MaxIngestBatchSize is the largest INGEST_BATCH_SIZE accepted.
+const MaxIngestBatchSize = 10000
+
+var downloadExtensionPattern = regexp.MustCompile(`^[a-z0-9]{1,16}$`)
+
 type Config struct {
+	DBDriver   string
 	DBURL      string
 	DBUsername string
 	DBPassword string
 	DBPort     string
+	DBName     string
+	DBPath     string
+	DBSSLMode  string
+	// DBQueryTimeout bounds each repository call, so stuck queries are cancelled. 0 disables it.
+	DBQueryTimeout time.Duration
+
+	IngestAsync         bool
+	IngestQueueSize     int
+	IngestBatchSize     int
+	IngestFlushInterval time.Duration
+	IngestDrainTimeout  time.Duration
+	MetricsAddr         string
+
+	SpoolEnabled        bool
+	SpoolDir            string
+	SpoolMaxBytes       int64
+	SpoolSegmentBytes   int64
+	SpoolReplayInterval time.Duration
+
+	RetentionInterval   time.Duration
+	RetentionBatchSize  int
+	RetentionBatchPause time.Duration
+
+	IDCacheSize int
+	// SettingsCacheTTL is how long each site's bot mode and IP mode are cached. 0 disables caching them.
+	SettingsCacheTTL time.Duration
+
+	// SessionTimeout is how long a visitor can be inactive before their next event starts a new session.
+	SessionTimeout time.Duration
+	// ReferrerRulesPath is a referrer rules file to use instead of the bundled rules.
+	ReferrerRulesPath string
+	// GeoIPPath is a MaxMind-format .mmdb database to locate clients with. Events aren't located if it is empty.
+	GeoIPPath string
+	// TrustedProxies are the networks of reverse proxies whose forwarding headers are believed when resolving client IP addresses.
+	TrustedProxies []*net.IPNet
+	// DownloadExtensions are the file extensions of links whose clicks are classified as downloads. Empty keeps the defaults.
+	DownloadExtensions []string
 }
 
 func LoadConfig() (*Config, error) {
+	// A .env file is optional, so the tracker can be configured from the environment alone
 	err := godotenv.Load()
-	if err != nil {
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		return nil, fmt.Errorf("Error loading .env file: %v", err)
 	}
 
 	config := &Config{
+		DBDriver:   getEnvOrDefault("DB_DRIVER", DriverMySQL),
 		DBURL:      os.Getenv("DB_URL"),
 		DBUsername: os.Getenv("DB_USERNAME"),
 		DBPassword: os.Getenv("DB_PASSWORD"),
 		DBPort:     os.Getenv("DB_PORT"),
+		DBName:     getEnvOrDefault("DB_NAME", "tracker_db"),
+		DBPath:     getEnvOrDefault("DB_PATH", "tracker.db"),
+		DBSSLMode:  getEnvOrDefault("DB_SSL_MODE", "disable"),
+
+		MetricsAddr: os.Getenv("METRICS_ADDR"),
+
+		SpoolDir: getEnvOrDefault("SPOOL_DIR", "data/spool"),
+
+		ReferrerRulesPath: os.Getenv("REFERRER_RULES_PATH"),
+		GeoIPPath:         os.Getenv("GEOIP_DB_PATH"),
+	}
+
+	if config.DBQueryTimeout, err = time.ParseDuration(getEnvOrDefault("DB_QUERY_TIMEOUT", "5s")); err != nil {
+		return nil, fmt.Errorf("Invalid DB_QUERY_TIMEOUT: %v", err)
+	}
+	if config.IngestAsync, err = strconv.ParseBool(getEnvOrDefault("INGEST_ASYNC", "true")); err != nil {
+		return nil, fmt.Errorf("Invalid INGEST_ASYNC: %v", err)
+	}
+	if config.IngestQueueSize, err = strconv.Atoi(getEnvOrDefault("INGEST_QUEUE_SIZE", "10000")); err != nil {
+		return nil, fmt.Errorf("Invalid INGEST_QUEUE_SIZE: %v", err)
+	}
+	if config.IngestQueueSize < 1 {
+		return nil, fmt.Errorf("Invalid INGEST_QUEUE_SIZE: %d, expected at least 1", config.IngestQueueSize)
+	}
+	if config.IngestBatchSize, err = strconv.Atoi(getEnvOrDefault("INGEST_BATCH_SIZE", "100")); err != nil {
+		return nil, fmt.Errorf("Invalid INGEST_BATCH_SIZE: %v", err)
+	}
+	if config.IngestBatchSize < 1 || config.IngestBatchSize > MaxIngestBatchSize {
+		return nil, fmt.Errorf("Invalid INGEST_BATCH_SIZE: %d, expected 1 to %d", config.IngestBatchSize, MaxIngestBatchSize)
+	}
+	if config.IngestFlushInterval, err = time.ParseDuration(getEnvOrDefault("INGEST_FLUSH_INTERVAL", "1s")); err != nil {
+		return nil, fmt.Errorf("Invalid INGEST_FLUSH_INTERVAL: %v", err)
+	}
+	if config.IngestDrainTimeout, err = time.ParseDuration(getEnvOrDefault("INGEST_DRAIN_TIMEOUT", "10s")); err != nil {
+		return nil, fmt.Errorf("Invalid INGEST_DRAIN_TIMEOUT: %v", err)
+	}
+
+	if config.SpoolEnabled, err = strconv.ParseBool(getEnvOrDefault("SPOOL_ENABLED", "true")); err != nil {
+		return nil, fmt.Errorf("Invalid SPOOL_ENABLED: %v", err)
+	}
+	if config.SpoolMaxBytes, err = strconv.ParseInt(getEnvOrDefault("SPOOL_MAX_BYTES", "104857600"), 10, 64); err != nil {
+		return nil, fmt.Errorf("Invalid SPOOL_MAX_BYTES: %v", err)
+	}
+	if config.SpoolSegmentBytes, err = strconv.ParseInt(getEnvOrDefault("SPOOL_SEGMENT_BYTES", "1048576"), 10, 64); err != nil {
+		return nil, fmt.Errorf("Invalid SPOOL_SEGMENT_BYTES: %v", err)
+	}
+	if config.SpoolReplayInterval, err = time.ParseDuration(getEnvOrDefault("SPOOL_REPLAY_INTERVAL", "30s")); err != nil {
+		return nil, fmt.Errorf("Invalid SPOOL_REPLAY_INTERVAL: %v", err)
+	}
+	if config.RetentionInterval, err = time.ParseDuration(getEnvOrDefault("RETENTION_INTERVAL", "1h")); err != nil {
+		return nil, fmt.Errorf("Invalid RETENTION_INTERVAL: %v", err)
+	}
+	if config.RetentionBatchSize, err = strconv.Atoi(getEnvOrDefault("RETENTION_BATCH_SIZE", "1000")); err != nil {
+		return nil, fmt.Errorf("Invalid RETENTION_BATCH_SIZE: %v", err)
+	}
+	if config.RetentionBatchPause, err = time.ParseDuration(getEnvOrDefault("RETENTION_BATCH_PAUSE", "100ms")); err != nil {
+		return nil, fmt.Errorf("Invalid RETENTION_BATCH_PAUSE: %v", err)
+	}
+
+	if config.IDCacheSize, err = strconv.Atoi(getEnvOrDefault("ID_CACHE_SIZE", "10000")); err != nil {
+		return nil, fmt.Errorf("Invalid ID_CACHE_SIZE: %v", err)
+	}
+	if config.SettingsCacheTTL, err = time.ParseDuration(getEnvOrDefault("SETTINGS_CACHE_TTL", "1m")); err != nil {
+		return nil, fmt.Errorf("Invalid SETTINGS_CACHE_TTL: %v", err)
+	}
+
+	if config.SessionTimeout, err = time.ParseDuration(getEnvOrDefault("SESSION_TIMEOUT", "30m")); err != nil {
+		return nil, fmt.Errorf("Invalid SESSION_TIMEOUT: %v", err)
+	}
+
+	if config.TrustedProxies, err = ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES")); err != nil {
+		return nil, fmt.Errorf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	if config.DownloadExtensions, err = ParseDownloadExtensions(os.Getenv("DOWNLOAD_EXTENSIONS")); err != nil {
+		return nil, fmt.Errorf("Invalid DOWNLOAD_EXTENSIONS: %v", err)
 	}
 
 	return config, nil
 }
 
 func OpenDB(config *Config) (*sql.DB, error) {
-	dataSourceName := fmt.Sprintf("%s:%s@tcp(%s:%s)/tracker_db", config.DBUsername, config.DBPassword, config.DBURL, config.DBPort)
-	db, err := sql.Open("mysql", dataSourceName)
+	var db *sql.DB
+	var err error
+
+	switch config.DBDriver {
+	case DriverMySQL, "":
+		db, err = openMySQL(config)
+	case DriverSQLite:
+		db, err = openSQLite(config)
+	case DriverPostgres:
+		db, err = openPostgres(config)
+	default:
+		return nil, fmt.Errorf("Unsupported database driver: %s", config.DBDriver)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("Error opening database connection: %v", err)
+		return nil, err
 	}
 
 	err = db.Ping()
@@ -46,3 +193,114 @@ func OpenDB(config *Config) (*sql.DB, error) {
 
 	return db, nil
 }
+
+func openMySQL(config *Config) (*sql.DB, error) {
+	// Migration scripts contain several statements, which the MySQL driver rejects by default
+	dataSourceName := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?multiStatements=true", config.DBUsername, config.DBPassword, config.DBURL, config.DBPort, dbName(config))
+	db, err := sql.Open("mysql", dataSourceName)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening database connection: %v", err)
+	}
+
+	return db, nil
+}
+
+func openPostgres(config *Config) (*sql.DB, error) {
+	sslMode := config.DBSSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+
+	dataSourceName := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(config.DBUsername, config.DBPassword),
+		Host:     net.JoinHostPort(config.DBURL, config.DBPort),
+		Path:     dbName(config),
+		RawQuery: url.Values{"sslmode": {sslMode}}.Encode(),
+	}
+	db, err := sql.Open("postgres", dataSourceName.String())
+	if err != nil {
+		return nil, fmt.Errorf("Error opening database connection: %v", err)
+	}
+
+	return db, nil
+}
+
+// openSQLite opens the SQLite database file at config.DBPath, creating it if needed.
+// Foreign keys are enforced and writers wait on a locked database rather than failing.
+func openSQLite(config *Config) (*sql.DB, error) {
+	dataSourceName := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", config.DBPath)
+	db, err := sql.Open("sqlite", dataSourceName)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening database connection: %v", err)
+	}
+
+	// SQLite only allows a single writer, so serialise access through one connection
+	db.SetMaxOpenConns(1)
+
+	return db, nil
+}
+
+// dbName returns the configured database name, falling back to tracker_db as created by schema.sql.
+func dbName(config *Config) string {
+	if config.DBName == "" {
+		return "tracker_db"
+	}
+	return config.DBName
+}
+
+func getEnvOrDefault(key, fallback string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// ParseTrustedProxies parses a comma separated list of CIDRs, such as 10.0.0.0/8,fd00::/8.
+// A bare IP address is a network of just that address.
+func ParseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, cidr := range strings.Split(s, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", cidr)
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// ParseDownloadExtensions parses a comma separated list of file extensions, e.g. "pdf,.zip,DMG".
+// Extensions are lowercased and their leading dot is optional. An empty list returns nil, keeping the default extensions.
+func ParseDownloadExtensions(s string) ([]string, error) {
+	var extensions []string
+	for _, ext := range strings.Split(s, ",") {
+		ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		if ext == "" {
+			continue
+		}
+		if !downloadExtensionPattern.MatchString(ext) {
+			return nil, fmt.Errorf("invalid file extension %q", ext)
+		}
+		extensions = append(extensions, ext)
+	}
+	return extensions, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/jwtly10/simple-site-tracker/config"
+	"github.com/jwtly10/simple-site-tracker/utils/logger"
+)
+
+// runIPs handles the `ips list|set` subcommands.
+func runIPs(cfg *config.Config, args []string) error {
+	l := logger.Get()
+
+	if len(args) == 0 {
+		return fmt.Errorf("Usage: ips list | set <domain> <disabled|full|truncated|hash>")
+	}
+
+	db, err := config.OpenDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	repo := newDBRepository(cfg, db)
+
+	switch args[0] {
+	case "list":
+		policies, err := repo.GetRetentionPolicies(context.Background())
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "DOMAIN\tIP ADDRESSES")
+		for _, p := range policies {
+			ipPolicy, err := repo.GetIPPolicy(context.Background(), p.DomainID)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%s\t%s\n", p.Domain, ipPolicy.Mode)
+		}
+		return w.Flush()
+	case "set":
+		if len(args) != 3 {
+			return fmt.Errorf("Usage: ips set <domain> <disabled|full|truncated|hash>")
+		}
+
+		domainID, err := repo.GetDomain(context.Background(), args[1])
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && domainID == 0) {
+			return fmt.Errorf("Unknown domain %s", args[1])
+		} else if err != nil {
+			return err
+		}
+
+		if err := repo.SetIPMode(context.Background(), domainID, args[2]); err != nil {
+			return err
+		}
+		l.Info().Msgf("Set IP mode for %s to %s", args[1], args[2])
+	default:
+		return fmt.Errorf("Unknown ips command %q, expected list or set", args[0])
+	}
+
+	return nil
+}
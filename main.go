@@ -2,9 +2,15 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"expvar"
+	"flag"
+	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
 	"github.com/jwtly10/simple-site-tracker/api/middleware"
@@ -12,10 +18,16 @@ import (
 	"github.com/jwtly10/simple-site-tracker/api/service"
 	"github.com/jwtly10/simple-site-tracker/api/track"
 	"github.com/jwtly10/simple-site-tracker/config"
+	"github.com/jwtly10/simple-site-tracker/spool"
 	"github.com/jwtly10/simple-site-tracker/utils/logger"
 )
 
 func main() {
+	store := flag.String("store", "db", "Storage backend: db (configured by DB_DRIVER) or memory")
+	seed := flag.String("seed", "", "Comma separated domain=siteKey pairs to register when using -store=memory")
+	autoMigrate := flag.Bool("auto-migrate", false, "Apply pending schema migrations on startup instead of refusing to start")
+	flag.Parse()
+
 	l := logger.Get()
 
 	cfg, err := config.LoadConfig()
@@ -23,24 +35,157 @@ func main() {
 		l.Fatal().Err(err).Msg("Error loading config")
 	}
 
-	db, err := config.OpenDB(cfg)
-	if err != nil {
-		l.Fatal().Err(err).Msg("Error opening database connection")
+	switch flag.Arg(0) {
+	case "migrate":
+		if err := runMigrate(cfg, flag.Args()[1:]); err != nil {
+			l.Fatal().Err(err).Msg("Error running migrations")
+		}
+		return
+	case "spool":
+		if err := runSpool(cfg, flag.Args()[1:]); err != nil {
+			l.Fatal().Err(err).Msg("Error running spool command")
+		}
+		return
+	case "retention":
+		if err := runRetention(cfg, flag.Args()[1:]); err != nil {
+			l.Fatal().Err(err).Msg("Error running retention command")
+		}
+		return
+	case "bots":
+		if err := runBots(cfg, flag.Args()[1:]); err != nil {
+			l.Fatal().Err(err).Msg("Error running bots command")
+		}
+		return
+	case "ips":
+		if err := runIPs(cfg, flag.Args()[1:]); err != nil {
+			l.Fatal().Err(err).Msg("Error running ips command")
+		}
+		return
+	case "rollup":
+		if err := runRollup(cfg, flag.Args()[1:]); err != nil {
+			l.Fatal().Err(err).Msg("Error running rollup command")
+		}
+		return
 	}
-	defer db.Close()
 
-	// Load repository and handlers
-	repo := track.NewRepository(db)
+	var repo track.RepositoryInterface
+	switch *store {
+	case "memory":
+		memRepo := track.NewMemoryRepository()
+		if err := seedDomains(memRepo, *seed); err != nil {
+			l.Fatal().Err(err).Msg("Error seeding in-memory store")
+		}
+		l.Warn().Msg("Using in-memory store, tracked data will be lost on shutdown")
+		repo = memRepo
+	case "db":
+		db, err := config.OpenDB(cfg)
+		if err != nil {
+			l.Fatal().Err(err).Msg("Error opening database connection")
+		}
+		defer db.Close()
+
+		if err := checkSchema(cfg, db, *autoMigrate); err != nil {
+			l.Fatal().Err(err).Msg("Database schema is not up to date")
+		}
+
+		repo = newDBRepository(cfg, db)
+
+		// Cache domain, page and IP address IDs and site settings so saving an event doesn't look them up
+		if cfg.IDCacheSize > 0 {
+			cachedRepo := track.NewCachedRepository(repo, cfg.IDCacheSize)
+			cachedRepo.SetSettingsTTL(cfg.SettingsCacheTTL)
+			expvar.Publish("id_cache", expvar.Func(func() any { return cachedRepo.Stats() }))
+			repo = cachedRepo
+		}
+	default:
+		l.Fatal().Msgf("Unknown store %q, expected db or memory", *store)
+	}
+
+	// Load handlers
 	th := track.NewHandlers(repo)
+	th.SetVisitors(track.NewVisitors(repo, track.VisitorConfig{SessionTimeout: cfg.SessionTimeout}))
+	if cfg.ReferrerRulesPath != "" {
+		rules, err := track.LoadReferrerRules(cfg.ReferrerRulesPath)
+		if err != nil {
+			l.Fatal().Err(err).Msg("Error loading referrer rules")
+		}
+		th.SetReferrerRules(rules)
+	}
+	if len(cfg.DownloadExtensions) > 0 {
+		th.SetDownloadExtensions(cfg.DownloadExtensions)
+	}
+	if cfg.GeoIPPath != "" {
+		geoIP, err := track.OpenGeoIP(cfg.GeoIPPath)
+		if err != nil {
+			l.Fatal().Err(err).Msg("Error opening GeoIP database")
+		}
+		defer geoIP.Close()
+		th.SetGeoIP(geoIP)
+	}
+
+	// Background jobs run until the server has shut down
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	// Spool events which fail to save so they can be replayed once the database recovers
+	var sp *spool.Spool
+	var spooler track.Spooler
+	if *store == "db" && cfg.SpoolEnabled {
+		sp, err = openSpool(cfg, false)
+		if err != nil {
+			l.Fatal().Err(err).Msg("Error opening spool")
+		}
+		defer sp.Close()
+
+		spooler = sp
+		th.SetSpooler(sp)
+		go sp.RunReplayer(backgroundCtx, cfg.SpoolReplayInterval, replayTo(backgroundCtx, repo))
+	}
+
+	retention := track.NewRetentionJob(repo, track.RetentionConfig{
+		Interval:   cfg.RetentionInterval,
+		BatchSize:  cfg.RetentionBatchSize,
+		BatchPause: cfg.RetentionBatchPause,
+	})
+	go retention.Run(backgroundCtx)
+
+	var writer *track.EventWriter
+	if cfg.IngestAsync {
+		writer = track.NewEventWriter(repo, track.WriterConfig{
+			QueueSize:     cfg.IngestQueueSize,
+			BatchSize:     cfg.IngestBatchSize,
+			FlushInterval: cfg.IngestFlushInterval,
+			Spooler:       spooler,
+		})
+		writer.Start()
+		th.SetEventWriter(writer)
+
+		expvar.Publish("ingest", expvar.Func(func() any { return writer.Stats() }))
+	}
+
+	if cfg.MetricsAddr != "" {
+		go func() {
+			l.Info().Msgf("Serving metrics on %s/debug/vars", cfg.MetricsAddr)
+			if err := http.ListenAndServe(cfg.MetricsAddr, expvar.Handler()); err != nil {
+				l.Error().Err(err).Msg("Error serving metrics")
+			}
+		}()
+	}
 
 	svc := service.NewService(repo)
 	mw := middleware.NewMiddleware(svc)
+	mw.SetTrustedProxies(cfg.TrustedProxies)
 
 	router := NewRouter(th, mw)
 
+	// Requests get their context from requestCtx, so in-flight queries can be cancelled if shutdown takes too long
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
 	server := &http.Server{
-		Addr:    ":8080",
-		Handler: router,
+		Addr:        ":8080",
+		Handler:     router,
+		BaseContext: func(net.Listener) context.Context { return requestCtx },
 	}
 
 	go func() {
@@ -59,8 +204,58 @@ func main() {
 	defer cancel()
 
 	if err := server.Shutdown(ctx); err != nil {
-		l.Fatal().Err(err).Msg("Error shutting down server")
+		l.Error().Err(err).Msg("Error shutting down server, cancelling in-flight requests")
+		cancelRequests()
+	}
+
+	// Drain queued events now that no more requests can arrive.
+	// The drain gets its own timeout, as shutting down the server may have used up ctx
+	if writer != nil {
+		drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.IngestDrainTimeout)
+		defer cancelDrain()
+
+		if err := writer.Close(drainCtx); err != nil {
+			l.Error().Err(err).Msgf("Error draining event queue, %d events not saved", writer.Stats().QueueDepth)
+		}
 	}
+	stopBackground()
 
 	l.Info().Msg("Server gracefully stopped")
 }
+
+// newDBRepository returns the repository implementation for the configured database driver.
+func newDBRepository(cfg *config.Config, db *sql.DB) track.RepositoryInterface {
+	switch cfg.DBDriver {
+	case config.DriverSQLite:
+		repo := track.NewSQLiteRepository(db)
+		repo.SetQueryTimeout(cfg.DBQueryTimeout)
+		return repo
+	case config.DriverPostgres:
+		repo := track.NewPostgresRepository(db)
+		repo.SetQueryTimeout(cfg.DBQueryTimeout)
+		return repo
+	default:
+		repo := track.NewRepository(db)
+		repo.SetQueryTimeout(cfg.DBQueryTimeout)
+		return repo
+	}
+}
+
+// seedDomains registers the domain=siteKey pairs in seed with the repository.
+func seedDomains(repo track.RepositoryInterface, seed string) error {
+	if seed == "" {
+		return nil
+	}
+
+	for _, pair := range strings.Split(seed, ",") {
+		domain, key, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || domain == "" || key == "" {
+			return fmt.Errorf("Invalid seed %q, expected domain=siteKey", pair)
+		}
+		if _, err := repo.SaveDomain(context.Background(), domain, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/jwtly10/simple-site-tracker/config"
+	"github.com/jwtly10/simple-site-tracker/migrations"
+	"github.com/jwtly10/simple-site-tracker/utils/logger"
+)
+
+// runMigrate handles the `migrate up|down|status` subcommands.
+func runMigrate(cfg *config.Config, args []string) error {
+	l := logger.Get()
+
+	if len(args) != 1 {
+		return fmt.Errorf("Usage: migrate up|down|status")
+	}
+
+	db, err := config.OpenDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := migrations.NewMigrator(db, cfg.DBDriver)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up()
+		for _, m := range applied {
+			l.Info().Msgf("Applied migration %d_%s", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			l.Info().Msg("Schema is already up to date")
+		}
+	case "down":
+		reverted, err := migrator.Down()
+		if err != nil {
+			return err
+		}
+		if reverted == nil {
+			l.Info().Msg("No migrations to revert")
+		} else {
+			l.Info().Msgf("Reverted migration %d_%s", reverted.Version, reverted.Name)
+		}
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+		for _, s := range statuses {
+			status := "pending"
+			if s.Applied {
+				status = "applied"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", s.Version, s.Name, status, s.AppliedAt)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("Unknown migrate command %q, expected up, down or status", args[0])
+	}
+
+	return nil
+}
+
+// checkSchema ensures the database schema matches the migrations embedded in this build.
+// Pending migrations are applied if autoMigrate is set, otherwise an error is returned.
+func checkSchema(cfg *config.Config, db *sql.DB, autoMigrate bool) error {
+	l := logger.Get()
+
+	migrator, err := migrations.NewMigrator(db, cfg.DBDriver)
+	if err != nil {
+		return err
+	}
+
+	pending, err := migrator.Pending()
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if !autoMigrate {
+		return fmt.Errorf("%d pending migration(s), expected schema version %d. Run `migrate up` or start with -auto-migrate",
+			len(pending), migrator.Latest())
+	}
+
+	applied, err := migrator.Up()
+	for _, m := range applied {
+		l.Info().Msgf("Applied migration %d_%s", m.Version, m.Name)
+	}
+
+	return err
+}
@@ -0,0 +1,257 @@
+package migrations
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jwtly10/simple-site-tracker/config"
+)
+
+//go:embed mysql/*.sql sqlite/*.sql postgres/*.sql
+var files embed.FS
+
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+)`
+
+// Migration is a numbered schema change, loaded from <version>_<name>.up.sql and .down.sql.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration and whether it has been applied to the database.
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt string
+}
+
+type Migrator struct {
+	db         *sql.DB
+	driver     string
+	migrations []Migration
+}
+
+// NewMigrator loads the embedded migrations for the given database driver.
+func NewMigrator(db *sql.DB, driver string) (*Migrator, error) {
+	if driver == "" {
+		driver = config.DriverMySQL
+	}
+
+	migrations, err := load(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, driver: driver, migrations: migrations}, nil
+}
+
+// load parses the migrations in the driver's directory, sorted by version.
+func load(driver string) ([]Migration, error) {
+	entries, err := files.ReadDir(driver)
+	if err != nil {
+		return nil, fmt.Errorf("No migrations for driver %s: %v", driver, err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		versionStr, migrationName, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("Invalid migration filename %s", name)
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid migration version in %s: %v", name, err)
+		}
+
+		content, err := files.ReadFile(path.Join(driver, name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: migrationName}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("Migration %d_%s is missing its up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Latest returns the version of the newest embedded migration, which is the
+// schema version the repositories in this build expect.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Status returns every embedded migration along with whether it has been applied.
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		appliedAt, ok := applied[migration.Version]
+		statuses = append(statuses, Status{Migration: migration, Applied: ok, AppliedAt: appliedAt})
+	}
+
+	return statuses, nil
+}
+
+// Pending returns the migrations which have not yet been applied, oldest first.
+func (m *Migrator) Pending() ([]Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// Up applies all pending migrations in order and returns the ones applied.
+func (m *Migrator) Up() ([]Migration, error) {
+	pending, err := m.Pending()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, migration := range pending {
+		err := m.exec(migration.Up, "INSERT INTO schema_migrations (version, name) VALUES ("+m.placeholder(1)+", "+m.placeholder(2)+")",
+			migration.Version, migration.Name)
+		if err != nil {
+			return pending[:i], fmt.Errorf("Error applying migration %d_%s: %v", migration.Version, migration.Name, err)
+		}
+	}
+
+	return pending, nil
+}
+
+// Down reverts the most recently applied migration and returns it.
+// It returns nil if no migrations have been applied.
+func (m *Migrator) Down() (*Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		if migration.Down == "" {
+			return nil, fmt.Errorf("Migration %d_%s cannot be reverted", migration.Version, migration.Name)
+		}
+
+		err := m.exec(migration.Down, "DELETE FROM schema_migrations WHERE version = "+m.placeholder(1), migration.Version)
+		if err != nil {
+			return nil, fmt.Errorf("Error reverting migration %d_%s: %v", migration.Version, migration.Name, err)
+		}
+
+		return &migration, nil
+	}
+
+	return nil, nil
+}
+
+// exec runs a migration script and its schema_migrations bookkeeping in one transaction.
+// MySQL commits DDL implicitly, so there a failed script can leave partial changes behind.
+func (m *Migrator) exec(script, bookkeeping string, args ...interface{}) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(script); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(bookkeeping, args...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// applied returns the applied migration versions and when they were applied.
+func (m *Migrator) applied() (map[int]string, error) {
+	if _, err := m.db.Exec(createMigrationsTable); err != nil {
+		return nil, fmt.Errorf("Error creating schema_migrations table: %v", err)
+	}
+
+	rows, err := m.db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]string)
+	for rows.Next() {
+		var version int
+		var appliedAt sql.NullString
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt.String
+	}
+
+	return applied, rows.Err()
+}
+
+func (m *Migrator) placeholder(n int) string {
+	if m.driver == config.DriverPostgres {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
@@ -1,6 +1,4 @@
-CREATE SCHEMA IF NOT EXISTS tracker_db;
-
-USE tracker_db;
+-- Initial tracker tables, previously applied by hand from schema.sql
 
 CREATE TABLE IF NOT EXISTS domains_tb (
     id INT AUTO_INCREMENT PRIMARY KEY,
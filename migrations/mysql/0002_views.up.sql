@@ -1,7 +1,7 @@
 -- Collection of generic views for creating dashboards
 
 -- Create a view for clicks per domain/page
-CREATE VIEW click_tracking_view AS
+CREATE OR REPLACE VIEW click_tracking_view AS
 SELECT
     d.domain,
     p.page_url,
@@ -17,7 +17,7 @@ FROM
     domains_tb d ON p.domain_id = d.id;
 
 -- Create a view for page views per domain/page
-CREATE VIEW page_views_view AS
+CREATE OR REPLACE VIEW page_views_view AS
 SELECT
     d.domain,
     p.page_url,
@@ -30,7 +30,7 @@ FROM
     domains_tb d ON p.domain_id = d.id;
 
 -- Create a view for the number of utms and which pages they led to
-CREATE VIEW utm_tracking_view AS
+CREATE OR REPLACE VIEW utm_tracking_view AS
 SELECT
     d.domain,
     p.page_url,
@@ -0,0 +1,8 @@
+DROP INDEX utm_created_at_idx ON utm_tb;
+DROP INDEX clicks_created_at_idx ON clicks_tb;
+DROP INDEX page_views_created_at_idx ON page_views_tb;
+
+ALTER TABLE domains_tb
+    DROP COLUMN utm_retention_days,
+    DROP COLUMN clicks_retention_days,
+    DROP COLUMN page_views_retention_days;
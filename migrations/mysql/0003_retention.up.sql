@@ -0,0 +1,10 @@
+-- Per-site retention, in days. NULL keeps rows forever.
+ALTER TABLE domains_tb
+    ADD COLUMN page_views_retention_days INT DEFAULT NULL,
+    ADD COLUMN clicks_retention_days INT DEFAULT NULL,
+    ADD COLUMN utm_retention_days INT DEFAULT NULL;
+
+-- Let the pruning job find expired rows without scanning the event tables
+CREATE INDEX page_views_created_at_idx ON page_views_tb (created_at);
+CREATE INDEX clicks_created_at_idx ON clicks_tb (created_at);
+CREATE INDEX utm_created_at_idx ON utm_tb (created_at);
@@ -0,0 +1 @@
+DROP INDEX pages_domain_url_idx ON pages_tb;
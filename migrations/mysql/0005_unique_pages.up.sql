@@ -0,0 +1,41 @@
+-- Concurrent first hits on a page could create duplicate page rows. Merge each set of
+-- duplicates into the oldest row, then prevent new duplicates with a unique index.
+CREATE TABLE page_dupes_tmp (
+    dup_id INT PRIMARY KEY,
+    keep_id INT NOT NULL
+);
+
+INSERT INTO page_dupes_tmp (dup_id, keep_id)
+SELECT p.id, k.keep_id
+FROM pages_tb p
+JOIN (SELECT domain_id, page_url, MIN(id) AS keep_id FROM pages_tb GROUP BY domain_id, page_url) k
+    ON k.domain_id = p.domain_id AND k.page_url = p.page_url
+WHERE p.id <> k.keep_id;
+
+UPDATE page_views_tb t JOIN page_dupes_tmp d ON d.dup_id = t.page_id SET t.page_id = d.keep_id;
+UPDATE clicks_tb t JOIN page_dupes_tmp d ON d.dup_id = t.page_id SET t.page_id = d.keep_id;
+UPDATE utm_tb t JOIN page_dupes_tmp d ON d.dup_id = t.page_id SET t.page_id = d.keep_id;
+
+INSERT INTO page_views_hourly_tb (domain_id, page_id, hour, views)
+SELECT * FROM (
+    SELECT r.domain_id, d.keep_id, r.hour, SUM(r.views) AS merged_views
+    FROM page_views_hourly_tb r JOIN page_dupes_tmp d ON d.dup_id = r.page_id
+    GROUP BY r.domain_id, d.keep_id, r.hour
+) merged
+ON DUPLICATE KEY UPDATE views = views + merged.merged_views;
+
+INSERT INTO page_views_daily_tb (domain_id, page_id, day, views)
+SELECT * FROM (
+    SELECT r.domain_id, d.keep_id, r.day, SUM(r.views) AS merged_views
+    FROM page_views_daily_tb r JOIN page_dupes_tmp d ON d.dup_id = r.page_id
+    GROUP BY r.domain_id, d.keep_id, r.day
+) merged
+ON DUPLICATE KEY UPDATE views = views + merged.merged_views;
+
+DELETE FROM page_views_hourly_tb WHERE page_id IN (SELECT dup_id FROM page_dupes_tmp);
+DELETE FROM page_views_daily_tb WHERE page_id IN (SELECT dup_id FROM page_dupes_tmp);
+DELETE FROM pages_tb WHERE id IN (SELECT dup_id FROM page_dupes_tmp);
+
+DROP TABLE page_dupes_tmp;
+
+CREATE UNIQUE INDEX pages_domain_url_idx ON pages_tb (domain_id, page_url);
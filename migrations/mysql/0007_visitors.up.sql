@@ -0,0 +1,21 @@
+-- Cookieless visitor and session IDs. Visitor IDs are a hash of the day's salt, the site,
+-- the client IP address and the user agent, so they can't be linked across days.
+ALTER TABLE page_views_tb
+    ADD COLUMN visitor_id VARCHAR(64) DEFAULT NULL,
+    ADD COLUMN session_id VARCHAR(64) DEFAULT NULL;
+ALTER TABLE clicks_tb
+    ADD COLUMN visitor_id VARCHAR(64) DEFAULT NULL,
+    ADD COLUMN session_id VARCHAR(64) DEFAULT NULL;
+ALTER TABLE utm_tb
+    ADD COLUMN visitor_id VARCHAR(64) DEFAULT NULL,
+    ADD COLUMN session_id VARCHAR(64) DEFAULT NULL;
+ALTER TABLE events_tb
+    ADD COLUMN visitor_id VARCHAR(64) DEFAULT NULL,
+    ADD COLUMN session_id VARCHAR(64) DEFAULT NULL;
+
+-- The salt for each UTC day, shared by every server. Earlier days are deleted.
+CREATE TABLE IF NOT EXISTS visitor_salts_tb (
+    day VARCHAR(10) PRIMARY KEY,
+    salt VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,6 @@
+-- Where each page view came from. Only the referring host and path are kept, and the
+-- channel (direct, internal, search, social, email or referral) is classified at ingest.
+ALTER TABLE page_views_tb
+    ADD COLUMN referrer_host VARCHAR(255) DEFAULT NULL,
+    ADD COLUMN referrer_path VARCHAR(255) DEFAULT NULL,
+    ADD COLUMN referrer_channel VARCHAR(16) DEFAULT NULL;
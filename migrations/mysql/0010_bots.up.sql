@@ -0,0 +1,9 @@
+-- Whether each event came from a bot, crawler, headless browser or prefetch.
+-- Bot events are only stored for sites which flag rather than drop them.
+ALTER TABLE page_views_tb ADD COLUMN is_bot BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE clicks_tb ADD COLUMN is_bot BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE utm_tb ADD COLUMN is_bot BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE events_tb ADD COLUMN is_bot BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- How a site handles bot events: drop or flag, where NULL drops them
+ALTER TABLE domains_tb ADD COLUMN bot_mode VARCHAR(8) DEFAULT NULL;
@@ -0,0 +1,16 @@
+ALTER TABLE events_tb
+    DROP COLUMN city,
+    DROP COLUMN region,
+    DROP COLUMN country;
+ALTER TABLE utm_tb
+    DROP COLUMN city,
+    DROP COLUMN region,
+    DROP COLUMN country;
+ALTER TABLE clicks_tb
+    DROP COLUMN city,
+    DROP COLUMN region,
+    DROP COLUMN country;
+ALTER TABLE page_views_tb
+    DROP COLUMN city,
+    DROP COLUMN region,
+    DROP COLUMN country;
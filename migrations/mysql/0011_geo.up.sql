@@ -0,0 +1,17 @@
+-- Where each event's client is located, looked up offline from its IP address, which is not stored.
+ALTER TABLE page_views_tb
+    ADD COLUMN country VARCHAR(2) DEFAULT NULL,
+    ADD COLUMN region VARCHAR(64) DEFAULT NULL,
+    ADD COLUMN city VARCHAR(64) DEFAULT NULL;
+ALTER TABLE clicks_tb
+    ADD COLUMN country VARCHAR(2) DEFAULT NULL,
+    ADD COLUMN region VARCHAR(64) DEFAULT NULL,
+    ADD COLUMN city VARCHAR(64) DEFAULT NULL;
+ALTER TABLE utm_tb
+    ADD COLUMN country VARCHAR(2) DEFAULT NULL,
+    ADD COLUMN region VARCHAR(64) DEFAULT NULL,
+    ADD COLUMN city VARCHAR(64) DEFAULT NULL;
+ALTER TABLE events_tb
+    ADD COLUMN country VARCHAR(2) DEFAULT NULL,
+    ADD COLUMN region VARCHAR(64) DEFAULT NULL,
+    ADD COLUMN city VARCHAR(64) DEFAULT NULL;
@@ -0,0 +1,16 @@
+ALTER TABLE domains_tb
+    DROP COLUMN ip_hash_key,
+    DROP COLUMN ip_mode;
+
+ALTER TABLE events_tb
+    DROP FOREIGN KEY events_ip_address_fk,
+    DROP COLUMN ip_address_id;
+ALTER TABLE utm_tb
+    DROP FOREIGN KEY utm_ip_address_fk,
+    DROP COLUMN ip_address_id;
+ALTER TABLE clicks_tb
+    DROP FOREIGN KEY clicks_ip_address_fk,
+    DROP COLUMN ip_address_id;
+ALTER TABLE page_views_tb
+    DROP FOREIGN KEY page_views_ip_address_fk,
+    DROP COLUMN ip_address_id;
@@ -0,0 +1,19 @@
+-- The anonymised IP address of each event, stored in the form set by its site's IP mode
+ALTER TABLE page_views_tb
+    ADD COLUMN ip_address_id INT DEFAULT NULL,
+    ADD CONSTRAINT page_views_ip_address_fk FOREIGN KEY (ip_address_id) REFERENCES ip_addresses_tb(id);
+ALTER TABLE clicks_tb
+    ADD COLUMN ip_address_id INT DEFAULT NULL,
+    ADD CONSTRAINT clicks_ip_address_fk FOREIGN KEY (ip_address_id) REFERENCES ip_addresses_tb(id);
+ALTER TABLE utm_tb
+    ADD COLUMN ip_address_id INT DEFAULT NULL,
+    ADD CONSTRAINT utm_ip_address_fk FOREIGN KEY (ip_address_id) REFERENCES ip_addresses_tb(id);
+ALTER TABLE events_tb
+    ADD COLUMN ip_address_id INT DEFAULT NULL,
+    ADD CONSTRAINT events_ip_address_fk FOREIGN KEY (ip_address_id) REFERENCES ip_addresses_tb(id);
+
+-- How a site stores IP addresses: disabled, full, truncated or hash, where NULL is disabled.
+-- The hash key is the site's secret for keyed hashes.
+ALTER TABLE domains_tb
+    ADD COLUMN ip_mode VARCHAR(16) DEFAULT NULL,
+    ADD COLUMN ip_hash_key VARCHAR(64) DEFAULT NULL;
@@ -0,0 +1,4 @@
+ALTER TABLE clicks_tb
+    DROP COLUMN target_path,
+    DROP COLUMN target_host,
+    DROP COLUMN click_class;
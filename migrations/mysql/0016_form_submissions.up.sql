@@ -0,0 +1,28 @@
+-- Forms submitted on each page, recorded by the script on submit, including submissions from the keyboard.
+-- Only the form's id, name, action and number of fields are kept, never the values entered.
+CREATE TABLE IF NOT EXISTS form_submissions_tb (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    domain_id INT NOT NULL,
+    page_id INT NOT NULL,
+    form_id VARCHAR(255) DEFAULT NULL,
+    form_name VARCHAR(255) DEFAULT NULL,
+    form_action VARCHAR(255) DEFAULT NULL,
+    field_count INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    visitor_id VARCHAR(64) DEFAULT NULL,
+    session_id VARCHAR(64) DEFAULT NULL,
+    browser VARCHAR(64) DEFAULT NULL,
+    browser_version VARCHAR(64) DEFAULT NULL,
+    os VARCHAR(64) DEFAULT NULL,
+    device VARCHAR(16) DEFAULT NULL,
+    country VARCHAR(2) DEFAULT NULL,
+    region VARCHAR(64) DEFAULT NULL,
+    city VARCHAR(64) DEFAULT NULL,
+    is_bot BOOLEAN NOT NULL DEFAULT FALSE,
+    ip_address_id INT DEFAULT NULL,
+    FOREIGN KEY (domain_id) REFERENCES domains_tb(id),
+    FOREIGN KEY (page_id) REFERENCES pages_tb(id),
+    FOREIGN KEY (ip_address_id) REFERENCES ip_addresses_tb(id)
+);
+
+CREATE INDEX form_submissions_domain_created_at_idx ON form_submissions_tb (domain_id, created_at);
@@ -0,0 +1,3 @@
+DROP INDEX events_domain_created_at_idx ON events_tb;
+
+ALTER TABLE domains_tb DROP COLUMN events_retention_days;
@@ -0,0 +1,5 @@
+-- Per-site retention of custom events, in days. NULL keeps rows forever.
+ALTER TABLE domains_tb ADD COLUMN events_retention_days INT DEFAULT NULL;
+
+-- Let the pruning job find a site's expired events without scanning every name
+CREATE INDEX events_domain_created_at_idx ON events_tb (domain_id, created_at);
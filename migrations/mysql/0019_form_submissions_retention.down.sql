@@ -0,0 +1 @@
+ALTER TABLE domains_tb DROP COLUMN form_submissions_retention_days;
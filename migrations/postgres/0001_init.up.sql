@@ -0,0 +1,46 @@
+-- Initial tracker tables
+
+CREATE TABLE IF NOT EXISTS domains_tb (
+    id SERIAL PRIMARY KEY,
+    domain VARCHAR(255) NOT NULL UNIQUE,
+    siteKey VARCHAR(255) NOT NULL,
+    active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS pages_tb (
+    id SERIAL PRIMARY KEY,
+    domain_id INT REFERENCES domains_tb(id),
+    page_url VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS page_views_tb (
+    id SERIAL PRIMARY KEY,
+    domain_id INT NOT NULL REFERENCES domains_tb(id),
+    page_id INT NOT NULL REFERENCES pages_tb(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS ip_addresses_tb (
+    id SERIAL PRIMARY KEY,
+    ip_address VARCHAR(255) NOT NULL UNIQUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS utm_tb (
+    id SERIAL PRIMARY KEY,
+    page_id INT REFERENCES pages_tb(id),
+    utm_source VARCHAR(255) DEFAULT NULL,
+    utm_medium VARCHAR(255) DEFAULT NULL,
+    utm_campaign VARCHAR(255) DEFAULT NULL,
+    track VARCHAR(255) DEFAULT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS clicks_tb (
+    id SERIAL PRIMARY KEY,
+    element JSONB,
+    page_id INT REFERENCES pages_tb(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,3 @@
+DROP VIEW IF EXISTS utm_tracking_view;
+DROP VIEW IF EXISTS page_views_view;
+DROP VIEW IF EXISTS click_tracking_view;
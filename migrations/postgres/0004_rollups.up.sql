@@ -0,0 +1,32 @@
+-- Pre-aggregated view and UTM counts, kept up to date as events are written.
+-- Buckets are in UTC.
+CREATE TABLE IF NOT EXISTS page_views_hourly_tb (
+    domain_id INT NOT NULL,
+    page_id INT NOT NULL,
+    hour TIMESTAMP NOT NULL,
+    views INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (domain_id, page_id, hour),
+    FOREIGN KEY (domain_id) REFERENCES domains_tb(id),
+    FOREIGN KEY (page_id) REFERENCES pages_tb(id)
+);
+
+CREATE TABLE IF NOT EXISTS page_views_daily_tb (
+    domain_id INT NOT NULL,
+    page_id INT NOT NULL,
+    day DATE NOT NULL,
+    views INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (domain_id, page_id, day),
+    FOREIGN KEY (domain_id) REFERENCES domains_tb(id),
+    FOREIGN KEY (page_id) REFERENCES pages_tb(id)
+);
+
+CREATE TABLE IF NOT EXISTS utm_daily_tb (
+    domain_id INT NOT NULL,
+    day DATE NOT NULL,
+    utm_campaign VARCHAR(255) NOT NULL DEFAULT '',
+    utm_source VARCHAR(255) NOT NULL DEFAULT '',
+    utm_medium VARCHAR(255) NOT NULL DEFAULT '',
+    hits INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (domain_id, day, utm_campaign, utm_source, utm_medium),
+    FOREIGN KEY (domain_id) REFERENCES domains_tb(id)
+);
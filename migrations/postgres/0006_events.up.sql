@@ -0,0 +1,12 @@
+-- Custom events recorded with tracker.track(name, props).
+-- props holds at most 20 string, number or bool values, keyed by property name.
+CREATE TABLE IF NOT EXISTS events_tb (
+    id SERIAL PRIMARY KEY,
+    domain_id INT NOT NULL REFERENCES domains_tb(id),
+    page_id INT NOT NULL REFERENCES pages_tb(id),
+    name VARCHAR(64) NOT NULL,
+    props JSONB,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX events_domain_name_created_at_idx ON events_tb (domain_id, name, created_at);
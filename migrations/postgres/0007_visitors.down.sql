@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS visitor_salts_tb;
+
+ALTER TABLE events_tb
+    DROP COLUMN session_id,
+    DROP COLUMN visitor_id;
+ALTER TABLE utm_tb
+    DROP COLUMN session_id,
+    DROP COLUMN visitor_id;
+ALTER TABLE clicks_tb
+    DROP COLUMN session_id,
+    DROP COLUMN visitor_id;
+ALTER TABLE page_views_tb
+    DROP COLUMN session_id,
+    DROP COLUMN visitor_id;
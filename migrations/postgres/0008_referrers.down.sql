@@ -0,0 +1,4 @@
+ALTER TABLE page_views_tb
+    DROP COLUMN referrer_channel,
+    DROP COLUMN referrer_path,
+    DROP COLUMN referrer_host;
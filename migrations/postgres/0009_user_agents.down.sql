@@ -0,0 +1,22 @@
+DROP INDEX page_views_domain_created_at_idx;
+
+ALTER TABLE events_tb
+    DROP COLUMN device,
+    DROP COLUMN os,
+    DROP COLUMN browser_version,
+    DROP COLUMN browser;
+ALTER TABLE utm_tb
+    DROP COLUMN device,
+    DROP COLUMN os,
+    DROP COLUMN browser_version,
+    DROP COLUMN browser;
+ALTER TABLE clicks_tb
+    DROP COLUMN device,
+    DROP COLUMN os,
+    DROP COLUMN browser_version,
+    DROP COLUMN browser;
+ALTER TABLE page_views_tb
+    DROP COLUMN device,
+    DROP COLUMN os,
+    DROP COLUMN browser_version,
+    DROP COLUMN browser;
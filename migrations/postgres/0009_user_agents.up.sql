@@ -0,0 +1,25 @@
+-- The browser, operating system and device class parsed from each event's User-Agent header.
+-- The header itself is not stored.
+ALTER TABLE page_views_tb
+    ADD COLUMN browser VARCHAR(64) DEFAULT NULL,
+    ADD COLUMN browser_version VARCHAR(64) DEFAULT NULL,
+    ADD COLUMN os VARCHAR(64) DEFAULT NULL,
+    ADD COLUMN device VARCHAR(16) DEFAULT NULL;
+ALTER TABLE clicks_tb
+    ADD COLUMN browser VARCHAR(64) DEFAULT NULL,
+    ADD COLUMN browser_version VARCHAR(64) DEFAULT NULL,
+    ADD COLUMN os VARCHAR(64) DEFAULT NULL,
+    ADD COLUMN device VARCHAR(16) DEFAULT NULL;
+ALTER TABLE utm_tb
+    ADD COLUMN browser VARCHAR(64) DEFAULT NULL,
+    ADD COLUMN browser_version VARCHAR(64) DEFAULT NULL,
+    ADD COLUMN os VARCHAR(64) DEFAULT NULL,
+    ADD COLUMN device VARCHAR(16) DEFAULT NULL;
+ALTER TABLE events_tb
+    ADD COLUMN browser VARCHAR(64) DEFAULT NULL,
+    ADD COLUMN browser_version VARCHAR(64) DEFAULT NULL,
+    ADD COLUMN os VARCHAR(64) DEFAULT NULL,
+    ADD COLUMN device VARCHAR(16) DEFAULT NULL;
+
+-- Break down a site's page views over a range without scanning other sites' rows
+CREATE INDEX page_views_domain_created_at_idx ON page_views_tb (domain_id, created_at);
@@ -0,0 +1,4 @@
+DROP INDEX page_views_domain_view_idx;
+ALTER TABLE page_views_tb
+    DROP COLUMN engaged_seconds,
+    DROP COLUMN view_id;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS js_errors_tb;
@@ -0,0 +1,29 @@
+-- JavaScript errors thrown on each page, captured by the script when the site opts in.
+-- fingerprint groups identical errors, hashing the kind, message, source URL, line and column.
+CREATE TABLE IF NOT EXISTS js_errors_tb (
+    id SERIAL PRIMARY KEY,
+    domain_id INT NOT NULL REFERENCES domains_tb(id),
+    page_id INT NOT NULL REFERENCES pages_tb(id),
+    fingerprint VARCHAR(32) NOT NULL,
+    kind VARCHAR(32) NOT NULL,
+    message VARCHAR(512) NOT NULL,
+    source_url VARCHAR(255) DEFAULT NULL,
+    line_number INT NOT NULL DEFAULT 0,
+    column_number INT NOT NULL DEFAULT 0,
+    stack TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    visitor_id VARCHAR(64) DEFAULT NULL,
+    session_id VARCHAR(64) DEFAULT NULL,
+    browser VARCHAR(64) DEFAULT NULL,
+    browser_version VARCHAR(64) DEFAULT NULL,
+    os VARCHAR(64) DEFAULT NULL,
+    device VARCHAR(16) DEFAULT NULL,
+    country VARCHAR(2) DEFAULT NULL,
+    region VARCHAR(64) DEFAULT NULL,
+    city VARCHAR(64) DEFAULT NULL,
+    is_bot BOOLEAN NOT NULL DEFAULT FALSE,
+    ip_address_id INT DEFAULT NULL REFERENCES ip_addresses_tb(id)
+);
+
+CREATE INDEX js_errors_domain_created_at_idx ON js_errors_tb (domain_id, created_at);
+CREATE INDEX js_errors_domain_fingerprint_idx ON js_errors_tb (domain_id, fingerprint, created_at);
@@ -0,0 +1,2 @@
+-- Per-site retention of form submissions, in days. NULL keeps rows forever.
+ALTER TABLE domains_tb ADD COLUMN form_submissions_retention_days INT DEFAULT NULL;
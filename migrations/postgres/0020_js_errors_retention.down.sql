@@ -0,0 +1 @@
+ALTER TABLE domains_tb DROP COLUMN js_errors_retention_days;
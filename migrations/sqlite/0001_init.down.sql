@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS clicks_tb;
+DROP TABLE IF EXISTS utm_tb;
+DROP TABLE IF EXISTS ip_addresses_tb;
+DROP TABLE IF EXISTS page_views_tb;
+DROP TABLE IF EXISTS pages_tb;
+DROP TABLE IF EXISTS domains_tb;
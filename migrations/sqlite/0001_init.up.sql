@@ -0,0 +1,52 @@
+-- Initial tracker tables
+
+CREATE TABLE IF NOT EXISTS domains_tb (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    domain VARCHAR(255) NOT NULL UNIQUE,
+    siteKey VARCHAR(255) NOT NULL,
+    active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS pages_tb (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    domain_id INTEGER,
+    page_url VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (domain_id) REFERENCES domains_tb(id)
+);
+
+CREATE TABLE IF NOT EXISTS page_views_tb (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    domain_id INTEGER NOT NULL,
+    page_id INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (domain_id) REFERENCES domains_tb(id),
+    FOREIGN KEY (page_id) REFERENCES pages_tb(id)
+);
+
+CREATE TABLE IF NOT EXISTS ip_addresses_tb (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    ip_address VARCHAR(255) NOT NULL UNIQUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS utm_tb (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    page_id INTEGER,
+    utm_source VARCHAR(255) DEFAULT NULL,
+    utm_medium VARCHAR(255) DEFAULT NULL,
+    utm_campaign VARCHAR(255) DEFAULT NULL,
+    track VARCHAR(255) DEFAULT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (page_id) REFERENCES pages_tb(id)
+);
+
+-- SQLite has no JSON column type, so elements are stored as validated JSON text
+CREATE TABLE IF NOT EXISTS clicks_tb (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    element TEXT CHECK (element IS NULL OR json_valid(element)),
+    page_id INTEGER,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (page_id) REFERENCES pages_tb(id)
+);
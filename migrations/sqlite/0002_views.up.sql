@@ -0,0 +1,47 @@
+-- SQLite has no CREATE OR REPLACE VIEW, so drop any views left over from a manual install first
+DROP VIEW IF EXISTS click_tracking_view;
+DROP VIEW IF EXISTS page_views_view;
+DROP VIEW IF EXISTS utm_tracking_view;
+
+CREATE VIEW click_tracking_view AS
+SELECT
+    d.domain,
+    p.page_url,
+    json_extract(c.element, '$.tag') as tag,
+    json_extract(c.element, '$.href') as href,
+    json_extract(c.element, '$.textContent') as content,
+    c.created_at as timestamp
+FROM
+    clicks_tb c
+        JOIN
+    pages_tb p ON c.page_id = p.id
+        JOIN
+    domains_tb d ON p.domain_id = d.id;
+
+CREATE VIEW page_views_view AS
+SELECT
+    d.domain,
+    p.page_url,
+    pv.created_at as timestamp
+FROM
+    page_views_tb pv
+        JOIN
+    pages_tb p ON pv.page_id = p.id
+        JOIN
+    domains_tb d ON p.domain_id = d.id;
+
+CREATE VIEW utm_tracking_view AS
+SELECT
+    d.domain,
+    p.page_url,
+    u.track,
+    u.utm_campaign,
+    u.utm_medium,
+    u.utm_source,
+    u.created_at as timestamp
+FROM
+    utm_tb u
+        JOIN
+    pages_tb p ON p.id = u.page_id
+        JOIN
+    domains_tb d ON p.domain_id = d.id;
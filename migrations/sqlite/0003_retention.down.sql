@@ -0,0 +1,7 @@
+DROP INDEX utm_created_at_idx;
+DROP INDEX clicks_created_at_idx;
+DROP INDEX page_views_created_at_idx;
+
+ALTER TABLE domains_tb DROP COLUMN utm_retention_days;
+ALTER TABLE domains_tb DROP COLUMN clicks_retention_days;
+ALTER TABLE domains_tb DROP COLUMN page_views_retention_days;
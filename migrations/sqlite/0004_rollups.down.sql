@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS utm_daily_tb;
+DROP TABLE IF EXISTS page_views_daily_tb;
+DROP TABLE IF EXISTS page_views_hourly_tb;
@@ -0,0 +1,14 @@
+-- Custom events recorded with tracker.track(name, props).
+-- props holds at most 20 string, number or bool values, keyed by property name.
+CREATE TABLE IF NOT EXISTS events_tb (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    domain_id INTEGER NOT NULL,
+    page_id INTEGER NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    props TEXT CHECK (props IS NULL OR json_valid(props)),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (domain_id) REFERENCES domains_tb(id),
+    FOREIGN KEY (page_id) REFERENCES pages_tb(id)
+);
+
+CREATE INDEX events_domain_name_created_at_idx ON events_tb (domain_id, name, created_at);
@@ -0,0 +1,3 @@
+ALTER TABLE page_views_tb DROP COLUMN referrer_channel;
+ALTER TABLE page_views_tb DROP COLUMN referrer_path;
+ALTER TABLE page_views_tb DROP COLUMN referrer_host;
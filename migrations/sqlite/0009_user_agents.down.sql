@@ -0,0 +1,18 @@
+DROP INDEX page_views_domain_created_at_idx;
+
+ALTER TABLE events_tb DROP COLUMN device;
+ALTER TABLE events_tb DROP COLUMN os;
+ALTER TABLE events_tb DROP COLUMN browser_version;
+ALTER TABLE events_tb DROP COLUMN browser;
+ALTER TABLE utm_tb DROP COLUMN device;
+ALTER TABLE utm_tb DROP COLUMN os;
+ALTER TABLE utm_tb DROP COLUMN browser_version;
+ALTER TABLE utm_tb DROP COLUMN browser;
+ALTER TABLE clicks_tb DROP COLUMN device;
+ALTER TABLE clicks_tb DROP COLUMN os;
+ALTER TABLE clicks_tb DROP COLUMN browser_version;
+ALTER TABLE clicks_tb DROP COLUMN browser;
+ALTER TABLE page_views_tb DROP COLUMN device;
+ALTER TABLE page_views_tb DROP COLUMN os;
+ALTER TABLE page_views_tb DROP COLUMN browser_version;
+ALTER TABLE page_views_tb DROP COLUMN browser;
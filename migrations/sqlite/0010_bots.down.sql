@@ -0,0 +1,6 @@
+ALTER TABLE domains_tb DROP COLUMN bot_mode;
+
+ALTER TABLE events_tb DROP COLUMN is_bot;
+ALTER TABLE utm_tb DROP COLUMN is_bot;
+ALTER TABLE clicks_tb DROP COLUMN is_bot;
+ALTER TABLE page_views_tb DROP COLUMN is_bot;
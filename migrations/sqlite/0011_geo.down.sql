@@ -0,0 +1,12 @@
+ALTER TABLE events_tb DROP COLUMN city;
+ALTER TABLE events_tb DROP COLUMN region;
+ALTER TABLE events_tb DROP COLUMN country;
+ALTER TABLE utm_tb DROP COLUMN city;
+ALTER TABLE utm_tb DROP COLUMN region;
+ALTER TABLE utm_tb DROP COLUMN country;
+ALTER TABLE clicks_tb DROP COLUMN city;
+ALTER TABLE clicks_tb DROP COLUMN region;
+ALTER TABLE clicks_tb DROP COLUMN country;
+ALTER TABLE page_views_tb DROP COLUMN city;
+ALTER TABLE page_views_tb DROP COLUMN region;
+ALTER TABLE page_views_tb DROP COLUMN country;
@@ -0,0 +1,13 @@
+-- Where each event's client is located, looked up offline from its IP address, which is not stored.
+ALTER TABLE page_views_tb ADD COLUMN country VARCHAR(2) DEFAULT NULL;
+ALTER TABLE page_views_tb ADD COLUMN region VARCHAR(64) DEFAULT NULL;
+ALTER TABLE page_views_tb ADD COLUMN city VARCHAR(64) DEFAULT NULL;
+ALTER TABLE clicks_tb ADD COLUMN country VARCHAR(2) DEFAULT NULL;
+ALTER TABLE clicks_tb ADD COLUMN region VARCHAR(64) DEFAULT NULL;
+ALTER TABLE clicks_tb ADD COLUMN city VARCHAR(64) DEFAULT NULL;
+ALTER TABLE utm_tb ADD COLUMN country VARCHAR(2) DEFAULT NULL;
+ALTER TABLE utm_tb ADD COLUMN region VARCHAR(64) DEFAULT NULL;
+ALTER TABLE utm_tb ADD COLUMN city VARCHAR(64) DEFAULT NULL;
+ALTER TABLE events_tb ADD COLUMN country VARCHAR(2) DEFAULT NULL;
+ALTER TABLE events_tb ADD COLUMN region VARCHAR(64) DEFAULT NULL;
+ALTER TABLE events_tb ADD COLUMN city VARCHAR(64) DEFAULT NULL;
@@ -0,0 +1,7 @@
+ALTER TABLE domains_tb DROP COLUMN ip_hash_key;
+ALTER TABLE domains_tb DROP COLUMN ip_mode;
+
+ALTER TABLE events_tb DROP COLUMN ip_address_id;
+ALTER TABLE utm_tb DROP COLUMN ip_address_id;
+ALTER TABLE clicks_tb DROP COLUMN ip_address_id;
+ALTER TABLE page_views_tb DROP COLUMN ip_address_id;
@@ -0,0 +1,10 @@
+-- The anonymised IP address of each event, stored in the form set by its site's IP mode
+ALTER TABLE page_views_tb ADD COLUMN ip_address_id INTEGER DEFAULT NULL;
+ALTER TABLE clicks_tb ADD COLUMN ip_address_id INTEGER DEFAULT NULL;
+ALTER TABLE utm_tb ADD COLUMN ip_address_id INTEGER DEFAULT NULL;
+ALTER TABLE events_tb ADD COLUMN ip_address_id INTEGER DEFAULT NULL;
+
+-- How a site stores IP addresses: disabled, full, truncated or hash, where NULL is disabled.
+-- The hash key is the site's secret for keyed hashes.
+ALTER TABLE domains_tb ADD COLUMN ip_mode VARCHAR(16) DEFAULT NULL;
+ALTER TABLE domains_tb ADD COLUMN ip_hash_key VARCHAR(64) DEFAULT NULL;
@@ -0,0 +1,3 @@
+DROP INDEX page_views_domain_view_idx;
+ALTER TABLE page_views_tb DROP COLUMN engaged_seconds;
+ALTER TABLE page_views_tb DROP COLUMN view_id;
@@ -0,0 +1,5 @@
+-- How long each page view was engaged with. The script gives each page view a random ID,
+-- which its heartbeats and visibility changes send to add to the page view's engaged time.
+ALTER TABLE page_views_tb ADD COLUMN view_id VARCHAR(36) DEFAULT NULL;
+ALTER TABLE page_views_tb ADD COLUMN engaged_seconds INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX page_views_domain_view_idx ON page_views_tb (domain_id, view_id);
@@ -0,0 +1 @@
+ALTER TABLE page_views_tb DROP COLUMN scroll_depth;
@@ -0,0 +1,2 @@
+-- The deepest scroll depth bucket each page view reached, as a percentage of the page: 0, 25, 50, 75 or 100.
+ALTER TABLE page_views_tb ADD COLUMN scroll_depth INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+ALTER TABLE clicks_tb DROP COLUMN target_path;
+ALTER TABLE clicks_tb DROP COLUMN target_host;
+ALTER TABLE clicks_tb DROP COLUMN click_class;
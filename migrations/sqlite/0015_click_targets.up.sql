@@ -0,0 +1,5 @@
+-- Where each clicked link leads. Only the link's host and path are kept, and the class
+-- (outbound, download or internal) is classified at ingest.
+ALTER TABLE clicks_tb ADD COLUMN click_class VARCHAR(16) DEFAULT NULL;
+ALTER TABLE clicks_tb ADD COLUMN target_host VARCHAR(255) DEFAULT NULL;
+ALTER TABLE clicks_tb ADD COLUMN target_path VARCHAR(255) DEFAULT NULL;
@@ -0,0 +1,2 @@
+-- Per-site retention of JavaScript errors, in days. NULL keeps rows forever.
+ALTER TABLE domains_tb ADD COLUMN js_errors_retention_days INTEGER DEFAULT NULL;
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jwtly10/simple-site-tracker/api/track"
+	"github.com/jwtly10/simple-site-tracker/config"
+	"github.com/jwtly10/simple-site-tracker/utils/logger"
+)
+
+// runRetention handles the `retention list|set|run` subcommands.
+func runRetention(cfg *config.Config, args []string) error {
+	l := logger.Get()
+
+	if len(args) == 0 {
+		return fmt.Errorf("Usage: retention list | set <domain> [page_views=<days>] [clicks=<days>] [utms=<days>] [events=<days>] [forms=<days>] [errors=<days>] | run")
+	}
+
+	db, err := config.OpenDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	repo := newDBRepository(cfg, db)
+
+	switch args[0] {
+	case "list":
+		policies, err := repo.GetRetentionPolicies(context.Background())
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "DOMAIN\tPAGE VIEWS\tCLICKS\tUTMS\tEVENTS\tFORMS\tERRORS")
+		for _, p := range policies {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", p.Domain, formatRetention(p.PageViewsDays), formatRetention(p.ClicksDays), formatRetention(p.UTMsDays),
+				formatRetention(p.EventsDays), formatRetention(p.FormsDays), formatRetention(p.JSErrorsDays))
+		}
+		return w.Flush()
+	case "set":
+		if len(args) < 3 {
+			return fmt.Errorf("Usage: retention set <domain> [page_views=<days>] [clicks=<days>] [utms=<days>] [events=<days>] [forms=<days>] [errors=<days>]")
+		}
+
+		policies, err := repo.GetRetentionPolicies(context.Background())
+		if err != nil {
+			return err
+		}
+
+		var policy *track.RetentionPolicy
+		for i := range policies {
+			if policies[i].Domain == args[1] {
+				policy = &policies[i]
+			}
+		}
+		if policy == nil {
+			return fmt.Errorf("Unknown domain %s", args[1])
+		}
+
+		for _, arg := range args[2:] {
+			key, value, ok := strings.Cut(arg, "=")
+			days, err := strconv.Atoi(value)
+			if !ok || err != nil || days < 0 {
+				return fmt.Errorf("Invalid retention %q, expected <event>=<days> where 0 keeps forever", arg)
+			}
+
+			switch key {
+			case "page_views":
+				policy.PageViewsDays = days
+			case "clicks":
+				policy.ClicksDays = days
+			case "utms":
+				policy.UTMsDays = days
+			case "events":
+				policy.EventsDays = days
+			case "forms":
+				policy.FormsDays = days
+			case "errors":
+				policy.JSErrorsDays = days
+			default:
+				return fmt.Errorf("Unknown event %q, expected page_views, clicks, utms, events, forms or errors", key)
+			}
+		}
+
+		if err := repo.SetRetentionPolicy(context.Background(), *policy); err != nil {
+			return err
+		}
+		l.Info().Msgf("Updated retention for %s", policy.Domain)
+	case "run":
+		job := track.NewRetentionJob(repo, track.RetentionConfig{
+			BatchSize:  cfg.RetentionBatchSize,
+			BatchPause: cfg.RetentionBatchPause,
+		})
+
+		pruned, err := job.RunOnce(context.Background())
+		l.Info().Msgf("Pruned %d expired events", pruned)
+		return err
+	default:
+		return fmt.Errorf("Unknown retention command %q, expected list, set or run", args[0])
+	}
+
+	return nil
+}
+
+func formatRetention(days int) string {
+	if days == 0 {
+		return "forever"
+	}
+	return fmt.Sprintf("%d days", days)
+}
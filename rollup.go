@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jwtly10/simple-site-tracker/config"
+	"github.com/jwtly10/simple-site-tracker/utils/logger"
+)
+
+// runRollup handles the `rollup backfill <from> <to>` subcommand.
+func runRollup(cfg *config.Config, args []string) error {
+	l := logger.Get()
+
+	if len(args) != 3 || args[0] != "backfill" {
+		return fmt.Errorf("Usage: rollup backfill <from YYYY-MM-DD> <to YYYY-MM-DD>")
+	}
+
+	from, err := time.Parse("2006-01-02", args[1])
+	if err != nil {
+		return fmt.Errorf("Invalid from date: %v", err)
+	}
+	to, err := time.Parse("2006-01-02", args[2])
+	if err != nil {
+		return fmt.Errorf("Invalid to date: %v", err)
+	}
+	if to.Before(from) {
+		return fmt.Errorf("The to date %s is before the from date %s", args[2], args[1])
+	}
+
+	db, err := config.OpenDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	repo := newDBRepository(cfg, db)
+
+	// Both dates are inclusive, so rebuild up to the start of the day after to
+	if err := repo.RebuildRollups(context.Background(), from, to.AddDate(0, 0, 1)); err != nil {
+		return err
+	}
+	l.Info().Msgf("Rebuilt rollups from %s to %s", args[1], args[2])
+
+	return nil
+}
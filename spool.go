@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jwtly10/simple-site-tracker/api/track"
+	"github.com/jwtly10/simple-site-tracker/config"
+	"github.com/jwtly10/simple-site-tracker/spool"
+	"github.com/jwtly10/simple-site-tracker/utils/logger"
+)
+
+// runSpool handles the `spool inspect|replay` subcommands.
+func runSpool(cfg *config.Config, args []string) error {
+	l := logger.Get()
+
+	if len(args) != 1 {
+		return fmt.Errorf("Usage: spool inspect|replay")
+	}
+
+	// Inspecting doesn't lock the spool, so it works while the server is running
+	sp, err := openSpool(cfg, args[0] == "inspect")
+	if err != nil {
+		return err
+	}
+	defer sp.Close()
+
+	switch args[0] {
+	case "inspect":
+		segments, err := sp.Inspect()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SEGMENT\tBYTES\tEVENTS\tREPLAYED\tOLDEST\tNEWEST")
+		total := 0
+		for _, s := range segments {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\t%s\n", s.Name, s.Size, s.Events, s.Replayed,
+				s.Oldest.Format(time.RFC3339), s.Newest.Format(time.RFC3339))
+			total += s.Events - s.Replayed
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		fmt.Printf("%d events waiting to be replayed (%d of %d bytes used)\n", total, sp.Size(), cfg.SpoolMaxBytes)
+
+		dead, err := sp.DeadLetters()
+		if err != nil {
+			return err
+		}
+		if dead > 0 {
+			fmt.Printf("%d events which can't be replayed are in the dead letter file\n", dead)
+		}
+	case "replay":
+		db, err := config.OpenDB(cfg)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		n, err := sp.Replay(replayTo(context.Background(), newDBRepository(cfg, db)))
+		l.Info().Msgf("Replayed %d spooled events", n)
+		return err
+	default:
+		return fmt.Errorf("Unknown spool command %q, expected inspect or replay", args[0])
+	}
+
+	return nil
+}
+
+// openSpool opens the configured spool, locking it unless readOnly is set.
+func openSpool(cfg *config.Config, readOnly bool) (*spool.Spool, error) {
+	return spool.Open(spool.Config{
+		Dir:          cfg.SpoolDir,
+		MaxBytes:     cfg.SpoolMaxBytes,
+		SegmentBytes: cfg.SpoolSegmentBytes,
+		ReadOnly:     readOnly,
+	})
+}
+
+// replayTo returns a spool.ApplyFunc which writes replayed events to the repository,
+// until the context is cancelled.
+func replayTo(ctx context.Context, repo track.RepositoryInterface) spool.ApplyFunc {
+	return func(events []track.Event) ([]int, []int) {
+		_, _, retry, rejected := track.WriteEvents(ctx, repo, events)
+		return retry, rejected
+	}
+}
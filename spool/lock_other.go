@@ -0,0 +1,10 @@
+//go:build !unix
+
+package spool
+
+import "os"
+
+// lockFileExclusive is a no-op where flock isn't available, so the spool directory must not be shared between processes.
+func lockFileExclusive(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package spool
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFileExclusive takes an exclusive lock on the file without waiting, returning ErrSpoolLocked if another process holds it.
+// The lock is released when the file is closed.
+func lockFileExclusive(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return ErrSpoolLocked
+	}
+	return err
+}
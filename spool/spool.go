@@ -0,0 +1,609 @@
+package spool
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jwtly10/simple-site-tracker/api/track"
+	"github.com/jwtly10/simple-site-tracker/utils/logger"
+)
+
+const (
+	segmentExt     = ".jsonl"
+	checkpointFile = "checkpoint"
+	lockFile       = "lock"
+	deadLetterFile = "dead-letter.log"
+	maxLineBytes   = 1 << 20
+)
+
+var (
+	ErrSpoolFull   = errors.New("spool is full")
+	ErrSpoolLocked = errors.New("spool is in use by another process")
+	ErrReadOnly    = errors.New("spool is read-only")
+	ErrReplayStall = errors.New("no spooled events could be replayed")
+)
+
+type Config struct {
+	// Dir is the directory the segment files are written to.
+	Dir string
+	// MaxBytes bounds the total size of all segments. Events are rejected once it is reached.
+	MaxBytes int64
+	// SegmentBytes is the size at which the current segment is closed and a new one started.
+	SegmentBytes int64
+	// ReplayBatchSize is the number of events applied to the repository at a time during replay.
+	ReplayBatchSize int
+	// MaxAttempts is the number of times an event is replayed before it is moved to the dead letter file.
+	// Only replays which save some of their batch count, so events aren't given up on while the database is down.
+	MaxAttempts int
+	// ReadOnly opens the spool without locking it, so it can be inspected while another process uses it.
+	// Spool and Replay fail with ErrReadOnly.
+	ReadOnly bool
+}
+
+// ApplyFunc saves replayed events, returning the indexes of the events which failed and should be retried,
+// and of the events which were rejected and would fail again.
+type ApplyFunc func(events []track.Event) (retry, rejected []int)
+
+// record is a spooled event, with the number of times replaying it has failed.
+type record struct {
+	track.Event
+	Attempts int `json:"spool_attempts,omitempty"`
+}
+
+// Spool is a write-ahead log of events which could not be saved to the repository.
+// Events are appended as JSON lines to numbered segment files, and replayed oldest first.
+// Events which are rejected or keep failing on replay are moved to a dead letter file, which isn't replayed.
+// The spool directory is locked while it is open, so only one process writes to and replays it.
+type Spool struct {
+	config Config
+	lock   *os.File
+
+	mu          sync.Mutex
+	current     *os.File
+	currentSize int64
+	nextSeq     int64
+	totalSize   int64
+
+	// replayMu ensures only one replay runs at a time
+	replayMu sync.Mutex
+}
+
+// SegmentInfo describes a spool segment file.
+type SegmentInfo struct {
+	Name     string
+	Size     int64
+	Events   int
+	Replayed int
+	Oldest   time.Time
+	Newest   time.Time
+}
+
+type checkpoint struct {
+	Segment string `json:"segment"`
+	Lines   int    `json:"lines"`
+}
+
+// Open opens the spool in config.Dir, creating the directory if needed.
+func Open(config Config) (*Spool, error) {
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = 100 << 20
+	}
+	if config.SegmentBytes <= 0 {
+		config.SegmentBytes = 1 << 20
+	}
+	if config.ReplayBatchSize <= 0 {
+		config.ReplayBatchSize = 500
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 5
+	}
+
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("Error creating spool directory: %v", err)
+	}
+
+	s := &Spool{config: config, nextSeq: 1}
+
+	if !config.ReadOnly {
+		lock, err := os.OpenFile(filepath.Join(config.Dir, lockFile), os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("Error opening spool lock: %v", err)
+		}
+		if err := lockFileExclusive(lock); err != nil {
+			lock.Close()
+			return nil, err
+		}
+		s.lock = lock
+	}
+
+	segments, err := s.segmentNames()
+	if err != nil {
+		s.unlock()
+		return nil, err
+	}
+	for _, name := range segments {
+		info, err := os.Stat(filepath.Join(config.Dir, name))
+		if err != nil {
+			s.unlock()
+			return nil, err
+		}
+		s.totalSize += info.Size()
+
+		seq, _ := strconv.ParseInt(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if seq >= s.nextSeq {
+			s.nextSeq = seq + 1
+		}
+	}
+
+	return s, nil
+}
+
+// Spool appends the events to the current segment and syncs it to disk.
+// It returns ErrSpoolFull if the events would take the spool over its size limit.
+func (s *Spool) Spool(events []track.Event) error {
+	records := make([]record, len(events))
+	for i, event := range events {
+		records[i] = record{Event: event}
+	}
+	return s.spool(records)
+}
+
+func (s *Spool) spool(records []record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	if s.config.ReadOnly {
+		return ErrReadOnly
+	}
+
+	data, err := marshalRecords(records)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.totalSize+int64(len(data)) > s.config.MaxBytes {
+		return ErrSpoolFull
+	}
+
+	if s.current == nil || s.currentSize >= s.config.SegmentBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.current.Write(data)
+	s.currentSize += int64(n)
+	s.totalSize += int64(n)
+	if err != nil {
+		return err
+	}
+
+	return s.current.Sync()
+}
+
+// deadLetter appends the records to the dead letter file, where they are kept for inspection but not replayed.
+func (s *Spool) deadLetter(records []record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	data, err := marshalRecords(records)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(s.config.Dir, deadLetterFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if stat, err := f.Stat(); err != nil {
+		return err
+	} else if stat.Size()+int64(len(data)) > s.config.MaxBytes {
+		return ErrSpoolFull
+	}
+
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// marshalRecords encodes the records as JSON lines.
+func marshalRecords(records []record) ([]byte, error) {
+	var data []byte
+	for _, r := range records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, line...)
+		data = append(data, '\n')
+	}
+	return data, nil
+}
+
+// Size returns the total size of the spooled segments in bytes.
+func (s *Spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.totalSize
+}
+
+// Close closes the current segment and unlocks the spool directory.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.seal()
+	s.unlock()
+	return err
+}
+
+// unlock releases the lock on the spool directory, if held.
+func (s *Spool) unlock() {
+	if s.lock == nil {
+		return
+	}
+	s.lock.Close()
+	s.lock = nil
+}
+
+// rotate seals the current segment and starts a new one. s.mu must be held.
+func (s *Spool) rotate() error {
+	if err := s.seal(); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%020d%s", s.nextSeq, segmentExt)
+	f, err := os.OpenFile(filepath.Join(s.config.Dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("Error creating spool segment: %v", err)
+	}
+
+	s.nextSeq++
+	s.current = f
+	s.currentSize = 0
+
+	return nil
+}
+
+// seal closes the current segment so the next Spool starts a new one. s.mu must be held.
+func (s *Spool) seal() error {
+	if s.current == nil {
+		return nil
+	}
+
+	err := s.current.Close()
+	s.current = nil
+	return err
+}
+
+// segmentNames returns the segment file names, oldest first.
+func (s *Spool) segmentNames() ([]string, error) {
+	entries, err := os.ReadDir(s.config.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), segmentExt) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// Inspect returns a summary of each segment in the spool.
+func (s *Spool) Inspect() ([]SegmentInfo, error) {
+	s.mu.Lock()
+	names, err := s.segmentNames()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	cp, err := s.readCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]SegmentInfo, 0, len(names))
+	for _, name := range names {
+		info := SegmentInfo{Name: name}
+		if cp.Segment == name {
+			info.Replayed = cp.Lines
+		}
+
+		err := s.scanSegment(name, 0, func(_ int, event record) error {
+			info.Events++
+			if info.Oldest.IsZero() || event.CreatedAt.Before(info.Oldest) {
+				info.Oldest = event.CreatedAt
+			}
+			if event.CreatedAt.After(info.Newest) {
+				info.Newest = event.CreatedAt
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		stat, err := os.Stat(filepath.Join(s.config.Dir, name))
+		if err != nil {
+			return nil, err
+		}
+		info.Size = stat.Size()
+
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+// DeadLetters returns the number of events in the dead letter file.
+func (s *Spool) DeadLetters() (int, error) {
+	f, err := os.Open(filepath.Join(s.config.Dir, deadLetterFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxLineBytes)
+
+	n := 0
+	for scanner.Scan() {
+		n++
+	}
+	return n, scanner.Err()
+}
+
+// Replay applies the spooled events to the repository, oldest first, deleting each
+// segment once it has been replayed. Progress is checkpointed after every batch, so an
+// interrupted replay resumes where it stopped rather than saving events twice.
+// Replay stops with ErrReplayStall if none of a batch could be saved and all of it should
+// be retried, e.g. because the database is still unavailable. Events which failed in a
+// partially saved batch are spooled again, until they have failed MaxAttempts times,
+// and events which were rejected are moved to the dead letter file.
+func (s *Spool) Replay(apply ApplyFunc) (int, error) {
+	if s.config.ReadOnly {
+		return 0, ErrReadOnly
+	}
+
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+
+	// Seal the current segment so new events go to a segment which isn't being replayed
+	s.mu.Lock()
+	if err := s.seal(); err != nil {
+		s.mu.Unlock()
+		return 0, err
+	}
+	names, err := s.segmentNames()
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for _, name := range names {
+		n, err := s.replaySegment(name, apply)
+		replayed += n
+		if err != nil {
+			return replayed, err
+		}
+	}
+
+	return replayed, nil
+}
+
+func (s *Spool) replaySegment(name string, apply ApplyFunc) (int, error) {
+	l := logger.Get()
+
+	cp, err := s.readCheckpoint()
+	if err != nil {
+		return 0, err
+	}
+	skip := 0
+	if cp.Segment == name {
+		skip = cp.Lines
+	}
+
+	replayed := 0
+	lastLine := skip
+	batch := make([]record, 0, s.config.ReplayBatchSize)
+
+	applyBatch := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		events := make([]track.Event, len(batch))
+		for i, r := range batch {
+			events[i] = r.Event
+		}
+
+		retry, rejected := apply(events)
+		if len(retry) == len(batch) {
+			return ErrReplayStall
+		}
+
+		var again, dead []record
+		for _, i := range retry {
+			r := batch[i]
+			r.Attempts++
+			if r.Attempts >= s.config.MaxAttempts {
+				dead = append(dead, r)
+				continue
+			}
+			again = append(again, r)
+		}
+		for _, i := range rejected {
+			dead = append(dead, batch[i])
+		}
+
+		if err := s.spool(again); err != nil {
+			l.Error().Err(err).Msgf("Error spooling %d events which failed to replay, events lost", len(again))
+		}
+		if len(dead) > 0 {
+			if err := s.deadLetter(dead); err != nil {
+				l.Error().Err(err).Msgf("Error moving %d events which can't be replayed to the dead letter file, events lost", len(dead))
+			} else {
+				l.Warn().Msgf("Moved %d events which can't be replayed to the dead letter file", len(dead))
+			}
+		}
+
+		replayed += len(batch) - len(retry) - len(rejected)
+		batch = batch[:0]
+
+		return s.writeCheckpoint(checkpoint{Segment: name, Lines: lastLine})
+	}
+
+	err = s.scanSegment(name, skip, func(line int, event record) error {
+		lastLine = line
+		batch = append(batch, event)
+		if len(batch) >= s.config.ReplayBatchSize {
+			return applyBatch()
+		}
+		return nil
+	})
+	if err == nil {
+		err = applyBatch()
+	}
+	if err != nil {
+		return replayed, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := filepath.Join(s.config.Dir, name)
+	stat, err := os.Stat(path)
+	if err != nil {
+		return replayed, err
+	}
+	if err := os.Remove(path); err != nil {
+		return replayed, err
+	}
+	s.totalSize -= stat.Size()
+
+	l.Info().Msgf("Replayed %d events from spool segment %s", replayed, name)
+
+	return replayed, s.writeCheckpoint(checkpoint{})
+}
+
+// scanSegment calls fn with the line number of each event in the segment after the first skip lines.
+// Lines which cannot be decoded are logged and skipped.
+func (s *Spool) scanSegment(name string, skip int, fn func(int, record) error) error {
+	l := logger.Get()
+
+	f, err := os.Open(filepath.Join(s.config.Dir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxLineBytes)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if line <= skip {
+			continue
+		}
+
+		var event record
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			l.Error().Err(err).Msgf("Skipping corrupt line %d in spool segment %s", line, name)
+			continue
+		}
+
+		if err := fn(line, event); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (s *Spool) readCheckpoint() (checkpoint, error) {
+	var cp checkpoint
+
+	data, err := os.ReadFile(filepath.Join(s.config.Dir, checkpointFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, nil
+	} else if err != nil {
+		return cp, err
+	}
+
+	if len(data) == 0 {
+		return cp, nil
+	}
+
+	return cp, json.Unmarshal(data, &cp)
+}
+
+// writeCheckpoint atomically replaces the checkpoint file.
+func (s *Spool) writeCheckpoint(cp checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(s.config.Dir, checkpointFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(s.config.Dir, checkpointFile))
+}
+
+// RunReplayer replays the spool every interval until the context is cancelled.
+func (s *Spool) RunReplayer(ctx context.Context, interval time.Duration, apply ApplyFunc) {
+	l := logger.Get()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if s.Size() == 0 {
+				continue
+			}
+
+			n, err := s.Replay(apply)
+			if errors.Is(err, ErrReplayStall) {
+				l.Warn().Msgf("Replayed %d spooled events before the repository stopped accepting them, retrying in %s", n, interval)
+			} else if err != nil {
+				l.Error().Err(err).Msg("Error replaying spool")
+			} else if n > 0 {
+				l.Info().Msgf("Replayed %d spooled events", n)
+			}
+		}
+	}
+}
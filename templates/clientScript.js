@@ -1,5 +1,9 @@
 const clientKey = '%s'
 const serverURL = '%s'
+// JavaScript errors are only captured if the script tag opts in with data-errors="true".
+// currentScript is only set while the script first runs, so it is read straight away
+const captureErrors =
+  !!document.currentScript && document.currentScript.getAttribute('data-errors') === 'true'
 
 if (document.readyState !== 'loading') {
     console.log('document is already ready')
@@ -21,14 +25,14 @@ function onReady() {
 
   // Send page view data to the server
   var pageURL = window.location.href
-  sendPageViewData(pageURL)
+  sendPageViewData(pageURL, document.referrer)
 
 }
 
-// Send page view data to the server navigation change
-window.addEventListener('hashchange', function () {
+// Send page view data to the server navigation change, referred by the previous URL
+window.addEventListener('hashchange', function (event) {
   var pageURL = window.location.href
-  sendPageViewData(pageURL)
+  sendPageViewData(pageURL, event.oldURL)
 })
 
 document.addEventListener('click', function (event) {
@@ -69,8 +73,96 @@ document.addEventListener('click', function (event) {
   }
 
   sendClickData(body)
+
+  // Following a link may unload the page before the next flush
+  if (clickedElement.tag === 'a' || parentElement.tag === 'a') {
+    flushEvents(true)
+  }
 })
 
+// Form submissions are recorded on submit rather than on click, so forms submitted with
+// the keyboard are counted too. Only the form's id, name, action and number of fields
+// are sent, never the values entered. Listening in the capture phase counts forms whose
+// own handlers stop the event, and submitting may unload the page, so the queue is flushed
+document.addEventListener(
+  'submit',
+  function (event) {
+    var form = event.target
+    if (!(form instanceof HTMLFormElement)) {
+      return
+    }
+
+    sendFormData({
+      form_id: form.getAttribute('id') || '',
+      form_name: form.getAttribute('name') || '',
+      form_action: new URL(form.getAttribute('action') || '', window.location.href).href,
+      field_count: countFormFields(form),
+      url: window.location.href,
+    })
+    flushEvents(true)
+  },
+  true
+)
+
+// Fields are the inputs, selects and textareas a visitor can fill in, not buttons or hidden inputs
+var ignoredFieldTypes = ['button', 'hidden', 'image', 'reset', 'submit']
+
+function countFormFields(form) {
+  return Array.from(form.elements).filter(function (element) {
+    var tag = element.tagName.toLowerCase()
+    if (tag === 'select' || tag === 'textarea') {
+      return true
+    }
+    return tag === 'input' && !ignoredFieldTypes.includes((element.getAttribute('type') || 'text').toLowerCase())
+  }).length
+}
+
+// Uncaught errors and unhandled promise rejections are sent with their message, source,
+// line, column and stack. At most maxErrors are sent per page load, so an error thrown in
+// a loop can't flood the server, and stacks are trimmed to maxStackLength characters
+const maxErrors = 10
+const maxStackLength = 2000
+var errorCount = 0
+
+if (captureErrors) {
+  window.addEventListener('error', function (event) {
+    // Resources which fail to load fire plain events rather than ErrorEvents
+    if (!(event instanceof ErrorEvent)) {
+      return
+    }
+
+    sendErrorData({
+      kind: 'error',
+      message: event.message,
+      source: event.filename,
+      line: event.lineno,
+      column: event.colno,
+      stack: event.error && event.error.stack,
+    })
+  })
+
+  window.addEventListener('unhandledrejection', function (event) {
+    var reason = event.reason
+    sendErrorData({
+      kind: 'unhandledrejection',
+      message: reason instanceof Error ? reason.name + ': ' + reason.message : String(reason),
+      stack: reason instanceof Error ? reason.stack : '',
+    })
+  })
+}
+
+// Expose tracker.track to record custom events, e.g.
+// tracker.track('signup_started', { plan: 'pro', seats: 5, annual: true })
+// Properties must be strings, numbers or booleans, and at most 20 can be sent
+window.tracker = window.tracker || {}
+window.tracker.track = function (name, props) {
+  sendEventData({
+    name: name,
+    props: props || {},
+    url: window.location.href,
+  })
+}
+
 // Function to parse UTM parameters from the URL
 function getUTMParameters() {
   var queryParams = new URLSearchParams(window.location.search)
@@ -91,52 +183,196 @@ function getUTMParameters() {
   }
 }
 
-// Function to send UTM data to the tracking server
-function sendUTMData(utmData) {
-  var pageURL = window.location.href.split('?')[0]
+// Events are coalesced and sent to the batch endpoint, flushing every
+// flushInterval ms, once maxBatchSize events are waiting, or when the page is hidden
+const flushInterval = 5000
+const maxBatchSize = 20
+var eventQueue = []
 
-  body = {
-    ...utmData,
-    page_url: pageURL,
+setInterval(function () {
+  flushEvents(false)
+}, flushInterval)
+
+document.addEventListener('visibilitychange', function () {
+  if (document.visibilityState === 'hidden') {
+    stopEngagement(Date.now())
+    reportEngagement()
+    flushEvents(true)
+  } else {
+    onActivity()
   }
+})
+window.addEventListener('pagehide', function () {
+  stopEngagement(Date.now())
+  reportEngagement()
+  flushEvents(true)
+})
 
-  fetch(serverURL + '/api/v1/track/utm', {
-    method: 'POST',
-    headers: {
-      'Content-Type': 'application/json',
-      'X-Site-Key': clientKey,
-      Origin: window.location.origin,
-    },
-    body: JSON.stringify({
-      ...body,
-    }),
+// Engaged time is how long the page is visible and has been interacted with in the
+// last idleTimeout ms. It is added to the current page view with a heartbeat every
+// heartbeatInterval ms, and whenever the page is hidden or another page is viewed
+const heartbeatInterval = 60000
+const idleTimeout = 30000
+var viewId = null
+var engagedMs = 0
+var engagedSince = null
+var lastActivity = Date.now()
+
+;['mousemove', 'mousedown', 'keydown', 'scroll', 'touchstart'].forEach(function (type) {
+  window.addEventListener(type, onActivity, { passive: true })
+})
+
+setInterval(function () {
+  if (engagedSince !== null && Date.now() - lastActivity > idleTimeout) {
+    stopEngagement(lastActivity + idleTimeout)
+  }
+}, 1000)
+
+setInterval(reportEngagement, heartbeatInterval)
+
+function onActivity() {
+  lastActivity = Date.now()
+  if (engagedSince === null && document.visibilityState === 'visible') {
+    engagedSince = lastActivity
+  }
+}
+
+// Function to add the current engaged stretch, which ended at the given time, to the engaged time
+function stopEngagement(at) {
+  if (engagedSince === null) {
+    return
+  }
+  engagedMs += Math.max(0, at - engagedSince)
+  engagedSince = null
+}
+
+// Function to queue the whole seconds of engaged time for the current page view
+function reportEngagement() {
+  if (engagedSince !== null) {
+    var now = Date.now()
+    stopEngagement(now)
+    engagedSince = now
+  }
+
+  var seconds = Math.floor(engagedMs / 1000)
+  if (!viewId || seconds <= 0) {
+    return
+  }
+  engagedMs -= seconds * 1000
+
+  queueEvent({
+    type: 'engagement',
+    view_id: viewId,
+    engaged_seconds: seconds,
+    url: window.location.href,
   })
-    .then((response) => {
-      if (!response.ok) {
-        throw new Error('Failed to send UTM data to the server')
-      }
-    })
-    .catch((error) => {
-      console.error(error)
+}
+
+// Scroll depth is the deepest of the scrollDepths buckets, as percentages of the page,
+// that the bottom of the window has reached during the current page view.
+// A bucket is reported the first time it is reached, and the server keeps the deepest
+const scrollDepths = [25, 50, 75, 100]
+var maxScrollDepth = 0
+var scrollCheckPending = false
+
+window.addEventListener(
+  'scroll',
+  function () {
+    if (scrollCheckPending) {
+      return
+    }
+    scrollCheckPending = true
+    window.requestAnimationFrame(function () {
+      scrollCheckPending = false
+      checkScrollDepth()
     })
+  },
+  { passive: true }
+)
+
+// Function to queue the scroll depth of the current page view if it has reached a deeper bucket
+function checkScrollDepth() {
+  var scrollHeight = document.documentElement.scrollHeight
+  if (!viewId || scrollHeight <= 0) {
+    return
+  }
+
+  // Allow a pixel of rounding, so the bottom of the page counts as the last bucket
+  var seen = ((window.scrollY + window.innerHeight + 1) / scrollHeight) * 100
+  var depth = 0
+  scrollDepths.forEach(function (bucket) {
+    if (seen >= bucket) {
+      depth = bucket
+    }
+  })
+  if (depth <= maxScrollDepth) {
+    return
+  }
+  maxScrollDepth = depth
+
+  queueEvent({
+    type: 'scroll',
+    view_id: viewId,
+    scroll_depth: depth,
+    url: window.location.href,
+  })
+}
+
+// Function to give a page view a random ID, which its engagement is reported against
+function newViewId() {
+  if (window.crypto && crypto.randomUUID) {
+    return crypto.randomUUID()
+  }
+  return Date.now().toString(36) + '-' + Math.random().toString(36).slice(2)
+}
+
+// Function to queue an event for the next batch
+function queueEvent(event) {
+  eventQueue.push(event)
+  if (eventQueue.length >= maxBatchSize) {
+    flushEvents()
+  }
 }
 
-// Function to send page view data to the tracking server
-function sendPageViewData(pageURL) {
-  fetch(serverURL + '/api/v1/track/pageview', {
+// Function to send the queued events to the tracking server.
+// When the page is being hidden or unloaded, the events are sent with sendBeacon, which
+// isn't cancelled by the navigation. Beacons can't set headers, so the site key is sent
+// in the body, which is sent as text/plain to avoid a CORS preflight.
+function flushEvents(unloading) {
+  if (eventQueue.length === 0) {
+    return
+  }
+
+  var events = eventQueue
+  eventQueue = []
+
+  var url = serverURL + '/api/v1/track/batch'
+  if (unloading && navigator.sendBeacon) {
+    var body = JSON.stringify({
+      site_key: clientKey,
+      events: events,
+    })
+    if (navigator.sendBeacon(url, new Blob([body], { type: 'text/plain' }))) {
+      return
+    }
+  }
+
+  fetch(url, {
     method: 'POST',
+    // keepalive lets the request finish if the page is being unloaded
+    keepalive: true,
     headers: {
       'Content-Type': 'application/json',
       'X-Site-Key': clientKey,
       Origin: window.location.origin,
     },
     body: JSON.stringify({
-      url: pageURL,
+      events: events,
     }),
   })
     .then((response) => {
       if (!response.ok) {
-        throw new Error('Failed to send page view data to the server')
+        throw new Error('Failed to send events to the server')
       }
     })
     .catch((error) => {
@@ -144,23 +380,75 @@ function sendPageViewData(pageURL) {
     })
 }
 
+// Function to send UTM data to the tracking server
+function sendUTMData(utmData) {
+  var pageURL = window.location.href.split('?')[0]
+
+  queueEvent({
+    ...utmData,
+    type: 'utm',
+    url: pageURL,
+  })
+}
+
+// Function to send page view data to the tracking server.
+// The previous page view's engaged time is reported first, and engagement and scroll depth start over
+// for the new one. Pages which fit in the window have their scroll depth reported straight away
+function sendPageViewData(pageURL, referrer) {
+  reportEngagement()
+  engagedMs = 0
+  maxScrollDepth = 0
+  viewId = newViewId()
+  onActivity()
+
+  queueEvent({
+    type: 'pageview',
+    url: pageURL,
+    referrer: referrer,
+    view_id: viewId,
+  })
+  checkScrollDepth()
+}
+
 // Function to send click data to the tracking server
 function sendClickData(data) {
-  fetch(serverURL + '/api/v1/track/click', {
-    method: 'POST',
-    headers: {
-      'Content-Type': 'application/json',
-      'X-Site-Key': clientKey,
-      Origin: window.location.origin,
-    },
-    body: JSON.stringify(data),
+  queueEvent({
+    ...data,
+    type: 'click',
+  })
+}
+
+// Function to send form submission data to the tracking server
+function sendFormData(data) {
+  queueEvent({
+    ...data,
+    type: 'form',
+  })
+}
+
+// Function to send JavaScript error data to the tracking server
+function sendErrorData(data) {
+  if (errorCount >= maxErrors) {
+    return
+  }
+  errorCount++
+
+  queueEvent({
+    type: 'error',
+    kind: data.kind,
+    message: String(data.message || ''),
+    source: data.source || '',
+    line: data.line || 0,
+    column: data.column || 0,
+    stack: String(data.stack || '').slice(0, maxStackLength),
+    url: window.location.href,
+  })
+}
+
+// Function to send custom event data to the tracking server
+function sendEventData(data) {
+  queueEvent({
+    ...data,
+    type: 'event',
   })
-    .then((response) => {
-      if (!response.ok) {
-        throw new Error('Failed to send click data to the server')
-      }
-    })
-    .catch((error) => {
-      console.error(error)
-    })
 }
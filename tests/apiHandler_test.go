@@ -17,7 +17,7 @@ func TestHandlers_TrackUTMHandler(t *testing.T) {
 	handlers := NewHandlers(mockRepo)
 
 	mockRepo.On("GetDomain", mock.Anything).Return(1, nil)
-	mockRepo.On("GetPage", mock.Anything, "/about").Return(1, nil)
+	mockRepo.On("GetOrCreatePage", mock.Anything, "/about").Return(1, nil)
 	mockRepo.On("SaveUTM", 1, "test_source", "test_medium", "test_campaign", "test_track").Return(42, nil)
 
 	data := `{"utm_source":"test_source","utm_medium":"test_medium","utm_campaign":"test_campaign","track":"test_track","page_url":"http://localhost:3000/about"}`
@@ -39,7 +39,7 @@ func TestHandlers_TrackPageViewHandler(t *testing.T) {
 	handlers := NewHandlers(mockRepo)
 
 	mockRepo.On("GetDomain", mock.Anything).Return(2, nil)
-	mockRepo.On("GetPage", mock.Anything, mock.Anything).Return(3, nil)
+	mockRepo.On("GetOrCreatePage", mock.Anything, mock.Anything).Return(3, nil)
 	mockRepo.On("SavePageView", 2, 3).Return(42, nil)
 
 	data := `{"page_url":"http://localhost:3000/about"}`
@@ -61,7 +61,7 @@ func TestHandlers_TrackClickHandler(t *testing.T) {
 	handlers := NewHandlers(mockRepo)
 
 	mockRepo.On("GetDomain", mock.Anything).Return(2, nil)
-	mockRepo.On("GetPage", mock.Anything, mock.Anything).Return(3, nil)
+	mockRepo.On("GetOrCreatePage", mock.Anything, mock.Anything).Return(3, nil)
 	mockRepo.On("SaveClick", 3, mock.Anything).Return(42, nil)
 
 	data := `{"element":{"tag":"span","id":"","classList":[],"textContent":"Generate video","parentElement":{"tag":"button","id":"","classList":["ant-btn","css-dev-only-do-not-override-6ynzfo","ant-btn-primary","generate"],"textContent":"Generate video"}},"url":"http://localhost:5173/generate"}`
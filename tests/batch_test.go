@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/jwtly10/simple-site-tracker/api/track"
+	"github.com/jwtly10/simple-site-tracker/spool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func trackBatch(handlers *Handlers, body string) (*httptest.ResponseRecorder, TrackBatchResponse) {
+	req := httptest.NewRequest("POST", "/api/v1/track/batch", strings.NewReader(body))
+	req.Header.Set("Origin", "http://localhost:3000")
+
+	recorder := httptest.NewRecorder()
+	handlers.TrackBatchHandler(recorder, req)
+
+	var response TrackBatchResponse
+	json.NewDecoder(recorder.Body).Decode(&response)
+	return recorder, response
+}
+
+func batchStatuses(response TrackBatchResponse) []string {
+	statuses := make([]string, len(response.Results))
+	for i, result := range response.Results {
+		statuses[i] = result.Status
+	}
+	return statuses
+}
+
+const mixedBatch = `{"events":[
+	{"type":"pageview","url":"http://localhost:3000/about"},
+	{"type":"click","url":"http://localhost:3000/about","element":{"tag":"a"}},
+	{"type":"utm","url":"http://localhost:3000/","utm_source":"newsletter"},
+	{"type":"event","url":"http://localhost:3000/pricing","name":"pricing_toggle","props":{"plan":"pro"}},
+	{"type":"event","url":"http://localhost:3000/pricing","name":"pricing toggle"},
+	{"type":"hover","url":"http://localhost:3000/about"}
+]}`
+
+func TestHandlers_TrackBatchHandler(t *testing.T) {
+	repo := NewMemoryRepository()
+	_, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+
+	recorder, response := trackBatch(NewHandlers(repo), mixedBatch)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, []string{BatchStatusSaved, BatchStatusSaved, BatchStatusSaved, BatchStatusSaved, BatchStatusInvalid, BatchStatusInvalid}, batchStatuses(response))
+	assert.NotEmpty(t, response.Results[4].Error)
+
+	assert.Len(t, repo.PageViews(), 1)
+	assert.Len(t, repo.Clicks(), 1)
+	assert.Equal(t, "newsletter", repo.UTMs()[0].UTMSource)
+	assert.Equal(t, "pricing_toggle", repo.CustomEvents()[0].Name)
+}
+
+func TestHandlers_TrackBatchHandler_Queued(t *testing.T) {
+	repo := NewMemoryRepository()
+	_, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+
+	handlers := NewHandlers(repo)
+	writer := NewEventWriter(repo, WriterConfig{QueueSize: 3, FlushInterval: time.Hour})
+	handlers.SetEventWriter(writer)
+
+	recorder, response := trackBatch(handlers, mixedBatch)
+
+	// Only three events fit in the queue, so the last valid event is rejected
+	assert.Equal(t, http.StatusAccepted, recorder.Code)
+	assert.Equal(t, "1", recorder.Header().Get("Retry-After"))
+	assert.Equal(t, []string{BatchStatusQueued, BatchStatusQueued, BatchStatusQueued, BatchStatusRejected, BatchStatusInvalid, BatchStatusInvalid}, batchStatuses(response))
+
+	writer.Start()
+	assert.NoError(t, writer.Close(context.Background()))
+	assert.Len(t, repo.PageViews(), 1)
+	assert.Len(t, repo.Clicks(), 1)
+	assert.Len(t, repo.UTMs(), 1)
+}
+
+func TestHandlers_TrackBatchHandler_SpoolsFailedEvents(t *testing.T) {
+	sp, err := spool.Open(spool.Config{Dir: t.TempDir()})
+	assert.NoError(t, err)
+
+	mockRepo := &MockRepository{}
+	mockRepo.On("GetDomain", "localhost").Return(1, nil)
+	mockRepo.On("GetIPPolicy", 1).Return(IPPolicy{Mode: IPModeDisabled}, nil)
+	mockRepo.On("GetOrCreatePage", 1, mock.Anything).Return(2, nil)
+	mockRepo.On("SavePageViews", mock.Anything).Return(syscall.ECONNREFUSED)
+	mockRepo.On("SaveClicks", mock.Anything).Return(nil)
+
+	handlers := NewHandlers(mockRepo)
+	handlers.SetSpooler(sp)
+
+	_, response := trackBatch(handlers, `{"events":[
+		{"type":"pageview","url":"http://localhost:3000/"},
+		{"type":"click","url":"http://localhost:3000/","element":{"tag":"a"}},
+		{"type":"pageview","url":"http://localhost:3000/about"}
+	]}`)
+
+	assert.Equal(t, []string{BatchStatusSpooled, BatchStatusSaved, BatchStatusSpooled}, batchStatuses(response))
+	// The domain is looked up once for the whole batch
+	mockRepo.AssertNumberOfCalls(t, "GetDomain", 1)
+
+	segments, err := sp.Inspect()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, segments[0].Events)
+}
+
+func TestHandlers_TrackBatchHandler_InvalidBatch(t *testing.T) {
+	handlers := NewHandlers(&MockRepository{})
+
+	events := make([]string, MaxBatchEvents+1)
+	for i := range events {
+		events[i] = `{"type":"pageview","url":"http://localhost:3000/"}`
+	}
+
+	for _, body := range []string{`{"events":[]}`, fmt.Sprintf(`{"events":[%s]}`, strings.Join(events, ","))} {
+		recorder, _ := trackBatch(handlers, body)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	}
+}
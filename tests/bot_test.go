@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/jwtly10/simple-site-tracker/api/track"
+	"github.com/stretchr/testify/assert"
+)
+
+const googlebotUA = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+
+func TestDetectBot(t *testing.T) {
+	for name, test := range map[string]struct {
+		headers  map[string]string
+		expected string
+	}{
+		"firefox":        {map[string]string{"User-Agent": firefoxUA}, ""},
+		"chrome":         {map[string]string{"User-Agent": chromeUA}, ""},
+		"iphone":         {map[string]string{"User-Agent": iPhoneSafariUA}, ""},
+		"cubot phone":    {map[string]string{"User-Agent": "Mozilla/5.0 (Linux; Android 10; CUBOT X30) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36"}, ""},
+		"googlebot":      {map[string]string{"User-Agent": googlebotUA}, BotReasonUserAgent},
+		"bingbot":        {map[string]string{"User-Agent": "Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)"}, BotReasonUserAgent},
+		"curl":           {map[string]string{"User-Agent": "curl/8.4.0"}, BotReasonUserAgent},
+		"uptime monitor": {map[string]string{"User-Agent": "Mozilla/5.0+(compatible; UptimeRobot/2.0; http://www.uptimerobot.com/)"}, BotReasonUserAgent},
+		"link preview":   {map[string]string{"User-Agent": "facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)"}, BotReasonUserAgent},
+		"headless chrome": {map[string]string{
+			"User-Agent": "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/120.0.0.0 Safari/537.36",
+		}, BotReasonHeadless},
+		"headless client hints": {map[string]string{"User-Agent": chromeUA, "Sec-CH-UA": `"HeadlessChrome";v="120", "Chromium";v="120"`}, BotReasonHeadless},
+		"sec-purpose prefetch":  {map[string]string{"User-Agent": chromeUA, "Sec-Purpose": "prefetch;prerender"}, BotReasonPrefetch},
+		"firefox prefetch":      {map[string]string{"User-Agent": firefoxUA, "X-Moz": "prefetch"}, BotReasonPrefetch},
+	} {
+		req := httptest.NewRequest("POST", "/api/v1/track/pageview", nil)
+		for key, value := range test.headers {
+			req.Header.Set(key, value)
+		}
+		assert.Equal(t, test.expected, DetectBot(req), name)
+	}
+}
+
+func TestBotModes(t *testing.T) {
+	forEachRepository(t, func(name string, repo RepositoryInterface, db *sql.DB, dropId int64, post postFunc) {
+		flagId, err := repo.SaveDomain(context.Background(), "example.com", "key456")
+		assert.NoError(t, err, name)
+
+		// Bots are dropped unless a site flags them
+		mode, err := repo.GetBotMode(context.Background(), int(dropId))
+		assert.NoError(t, err, name)
+		assert.Equal(t, BotModeDrop, mode, name)
+		assert.NoError(t, repo.SetBotMode(context.Background(), int(flagId), BotModeFlag), name)
+		assert.NoError(t, repo.SetBotMode(context.Background(), int(flagId), BotModeFlag), name)
+		mode, err = repo.GetBotMode(context.Background(), int(flagId))
+		assert.NoError(t, err, name)
+		assert.Equal(t, BotModeFlag, mode, name)
+
+		assert.Error(t, repo.SetBotMode(context.Background(), int(flagId), "ignore"), name)
+		assert.ErrorIs(t, repo.SetBotMode(context.Background(), 999, BotModeFlag), sql.ErrNoRows, name)
+
+		handlers := NewHandlers(repo)
+		for _, origin := range []string{"http://localhost:3000", "https://example.com"} {
+			for _, userAgent := range []string{chromeUA, googlebotUA} {
+				code := post(handlers.TrackPageViewHandler, "/api/v1/track/pageview", `{"url":"`+origin+`/"}`, withOrigin(origin), withUserAgent(userAgent))
+				assert.Equal(t, http.StatusOK, code, name)
+			}
+		}
+
+		// Flagged page views are stored, but left out of the stats
+		now := time.Now()
+		for id, stored := range map[int64]int{dropId: 1, flagId: 2} {
+			counts, err := repo.GetVisitorCounts(context.Background(), int(id), now.Add(-time.Hour), now.Add(time.Hour))
+			assert.NoError(t, err, name)
+			assert.Equal(t, int64(1), counts.PageViews, name)
+
+			browsers, err := repo.GetDimensionCounts(context.Background(), int(id), DimensionBrowser, now.Add(-time.Hour), now.Add(time.Hour))
+			assert.NoError(t, err, name)
+			assert.Equal(t, []DimensionCount{{Value: "Chrome", Count: 1}}, browsers, name)
+
+			assert.Equal(t, stored, countPageViews(t, repo, db, int(id)), name)
+		}
+
+		assert.NoError(t, repo.RebuildRollups(context.Background(), now.AddDate(0, 0, -1), now.AddDate(0, 0, 1)), name)
+		if memRepo, ok := repo.(*MemoryRepository); ok {
+			for _, rollup := range memRepo.DailyPageViews() {
+				assert.Equal(t, 1, rollup.Views, name)
+			}
+		}
+	})
+}
+
+func TestHandlers_BatchFromBot(t *testing.T) {
+	repo := NewMemoryRepository()
+	_, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/api/v1/track/batch", strings.NewReader(mixedBatch))
+	req.Header.Set("Origin", "http://localhost:3000")
+	req.Header.Set("User-Agent", googlebotUA)
+
+	recorder := httptest.NewRecorder()
+	NewHandlers(repo).TrackBatchHandler(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var response TrackBatchResponse
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+	assert.Equal(t, []string{
+		BatchStatusDropped, BatchStatusDropped, BatchStatusDropped, BatchStatusDropped, BatchStatusInvalid, BatchStatusInvalid,
+	}, batchStatuses(response))
+	assert.Empty(t, repo.PageViews())
+	assert.Empty(t, repo.DailyUTMs())
+}
+
+// countPageViews counts the stored page views of a domain, including those from bots.
+func countPageViews(t *testing.T, repo RepositoryInterface, db *sql.DB, domainID int) int {
+	if memRepo, ok := repo.(*MemoryRepository); ok {
+		count := 0
+		for _, pv := range memRepo.PageViews() {
+			if pv.DomainID == domainID {
+				count++
+			}
+		}
+		return count
+	}
+
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM page_views_tb WHERE domain_id = ?", domainID).Scan(&count))
+	return count
+}
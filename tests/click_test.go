@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/jwtly10/simple-site-tracker/api/track"
+	"github.com/jwtly10/simple-site-tracker/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDownloadExtensions(t *testing.T) {
+	extensions, err := config.ParseDownloadExtensions(" pdf, .ZIP,dmg,")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pdf", "zip", "dmg"}, extensions)
+
+	// An empty list keeps the defaults
+	extensions, err = config.ParseDownloadExtensions("")
+	assert.NoError(t, err)
+	assert.Empty(t, extensions)
+
+	_, err = config.ParseDownloadExtensions("pdf,tar.gz")
+	assert.Error(t, err)
+}
+
+func TestClickClassifier_Classify(t *testing.T) {
+	classifier := NewClickClassifier([]string{"pdf", "zip"})
+
+	for name, test := range map[string]struct {
+		element  map[string]interface{}
+		expected ClickTarget
+	}{
+		"button":            {map[string]interface{}{"tag": "button"}, ClickTarget{ClickClass: ClickInternal}},
+		"internal link":     {map[string]interface{}{"tag": "a", "href": "https://example.com/pricing?plan=pro"}, ClickTarget{ClickClass: ClickInternal, TargetHost: "example.com", TargetPath: "/pricing"}},
+		"subdomain link":    {map[string]interface{}{"tag": "a", "href": "https://docs.example.com/"}, ClickTarget{ClickClass: ClickInternal, TargetHost: "docs.example.com", TargetPath: "/"}},
+		"outbound link":     {map[string]interface{}{"tag": "a", "href": "https://GitHub.com/jwtly10"}, ClickTarget{ClickClass: ClickOutbound, TargetHost: "github.com", TargetPath: "/jwtly10"}},
+		"outbound root":     {map[string]interface{}{"tag": "a", "href": "https://github.com"}, ClickTarget{ClickClass: ClickOutbound, TargetHost: "github.com", TargetPath: "/"}},
+		"download":          {map[string]interface{}{"tag": "a", "href": "https://example.com/files/Report.PDF"}, ClickTarget{ClickClass: ClickDownload, TargetHost: "example.com", TargetPath: "/files/Report.PDF"}},
+		"outbound download": {map[string]interface{}{"tag": "a", "href": "https://cdn.example.net/app.zip?v=2"}, ClickTarget{ClickClass: ClickDownload, TargetHost: "cdn.example.net", TargetPath: "/app.zip"}},
+		"other extension":   {map[string]interface{}{"tag": "a", "href": "https://example.com/app.dmg"}, ClickTarget{ClickClass: ClickInternal, TargetHost: "example.com", TargetPath: "/app.dmg"}},
+		"parent link":       {map[string]interface{}{"tag": "span", "parentElement": map[string]interface{}{"tag": "a", "href": "https://github.com/"}}, ClickTarget{ClickClass: ClickOutbound, TargetHost: "github.com", TargetPath: "/"}},
+		"mailto":            {map[string]interface{}{"tag": "a", "href": "mailto:hello@example.org"}, ClickTarget{ClickClass: ClickInternal}},
+		"invalid href":      {map[string]interface{}{"tag": "a", "href": "http://[::1"}, ClickTarget{ClickClass: ClickInternal}},
+	} {
+		assert.Equal(t, test.expected, classifier.Classify(test.element, "www.example.com"), name)
+	}
+}
+
+func TestClickTargets(t *testing.T) {
+	forEachRepository(t, func(name string, repo RepositoryInterface, db *sql.DB, domainId int64, post postFunc) {
+		handlers := NewHandlers(repo)
+		for _, click := range []string{
+			`{"url":"http://localhost:3000/","element":{"tag":"a","href":"https://github.com/jwtly10"}}`,
+			`{"url":"http://localhost:3000/about","element":{"tag":"a","href":"https://github.com/jwtly10/simple-site-tracker"}}`,
+			`{"url":"http://localhost:3000/","element":{"tag":"a","href":"https://twitter.com/"}}`,
+			`{"url":"http://localhost:3000/","element":{"tag":"span","parentElement":{"tag":"a","href":"http://localhost:3000/files/guide.pdf"}}}`,
+			`{"url":"http://localhost:3000/","element":{"tag":"a","href":"http://localhost:3000/about"}}`,
+			`{"url":"http://localhost:3000/","element":{"tag":"button"}}`,
+		} {
+			assert.Equal(t, http.StatusOK, post(handlers.TrackClickHandler, "/api/v1/track/click", click), name)
+		}
+
+		now := time.Now()
+		outbound, err := repo.GetClickTargets(context.Background(), int(domainId), ClickOutbound, now.Add(-time.Hour), now.Add(time.Hour))
+		assert.NoError(t, err, name)
+		assert.Equal(t, []ClickTargetCount{{Host: "github.com", Count: 2}, {Host: "twitter.com", Count: 1}}, outbound, name)
+
+		downloads, err := repo.GetClickTargets(context.Background(), int(domainId), ClickDownload, now.Add(-time.Hour), now.Add(time.Hour))
+		assert.NoError(t, err, name)
+		assert.Equal(t, []ClickTargetCount{{Host: "localhost", Path: "/files/guide.pdf", Count: 1}}, downloads, name)
+
+		internal, err := repo.GetClickTargets(context.Background(), int(domainId), ClickInternal, now.Add(-time.Hour), now.Add(time.Hour))
+		assert.NoError(t, err, name)
+		assert.Equal(t, []ClickTargetCount{{Count: 1}, {Host: "localhost", Path: "/about", Count: 1}}, internal, name)
+
+		_, err = repo.GetClickTargets(context.Background(), int(domainId), "hover", now.Add(-time.Hour), now.Add(time.Hour))
+		assert.Error(t, err, name)
+	})
+}
+
+func TestHandlers_BatchClickTargets(t *testing.T) {
+	repo := NewMemoryRepository()
+	_, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+
+	handlers := NewHandlers(repo)
+	handlers.SetDownloadExtensions([]string{"dmg"})
+
+	recorder, _ := trackBatch(handlers, `{"events":[
+		{"type":"click","url":"http://localhost:3000/","element":{"tag":"a","href":"https://example.org/app.dmg"}},
+		{"type":"click","url":"http://localhost:3000/","element":{"tag":"a","href":"https://example.org/guide.pdf"}}
+	]}`)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	clicks := repo.Clicks()
+	assert.Len(t, clicks, 2)
+	assert.Equal(t, ClickTarget{ClickClass: ClickDownload, TargetHost: "example.org", TargetPath: "/app.dmg"}, clicks[0].ClickTarget)
+	assert.Equal(t, ClickTarget{ClickClass: ClickOutbound, TargetHost: "example.org", TargetPath: "/guide.pdf"}, clicks[1].ClickTarget)
+}
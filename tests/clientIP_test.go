@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jwtly10/simple-site-tracker/api/middleware"
+	"github.com/jwtly10/simple-site-tracker/api/router"
+	"github.com/jwtly10/simple-site-tracker/api/service"
+	. "github.com/jwtly10/simple-site-tracker/api/track"
+	"github.com/jwtly10/simple-site-tracker/config"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := config.ParseTrustedProxies(" 10.0.0.0/8, 192.0.2.10,fd00::/8,2001:db8::1,")
+	assert.NoError(t, err)
+
+	var networks []string
+	for _, proxy := range proxies {
+		networks = append(networks, proxy.String())
+	}
+	assert.Equal(t, []string{"10.0.0.0/8", "192.0.2.10/32", "fd00::/8", "2001:db8::1/128"}, networks)
+
+	proxies, err = config.ParseTrustedProxies("")
+	assert.NoError(t, err)
+	assert.Empty(t, proxies)
+
+	_, err = config.ParseTrustedProxies("10.0.0.0/33")
+	assert.Error(t, err)
+	_, err = config.ParseTrustedProxies("proxy.internal")
+	assert.Error(t, err)
+}
+
+func TestClientIPResolver_Resolve(t *testing.T) {
+	proxies, err := config.ParseTrustedProxies("10.0.0.0/8,fd00::/8")
+	assert.NoError(t, err)
+	resolver := NewClientIPResolver(proxies)
+
+	for name, test := range map[string]struct {
+		remoteAddr string
+		headers    map[string][]string
+		expected   string
+	}{
+		"direct":                          {"203.0.113.7:5000", nil, "203.0.113.7"},
+		"untrusted forwarder":             {"203.0.113.7:5000", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}, "X-Real-Ip": {"198.51.100.1"}}, "203.0.113.7"},
+		"proxy without headers":           {"10.0.0.1:5000", nil, "10.0.0.1"},
+		"x-forwarded-for":                 {"10.0.0.1:5000", map[string][]string{"X-Forwarded-For": {"203.0.113.7"}}, "203.0.113.7"},
+		"x-forwarded-for through proxies": {"10.0.0.1:5000", map[string][]string{"X-Forwarded-For": {"203.0.113.7, 10.0.0.2"}}, "203.0.113.7"},
+		"x-forwarded-for over lines":      {"10.0.0.1:5000", map[string][]string{"X-Forwarded-For": {"203.0.113.7", "10.0.0.2"}}, "203.0.113.7"},
+		"spoofed x-forwarded-for":         {"10.0.0.1:5000", map[string][]string{"X-Forwarded-For": {"198.51.100.1, 203.0.113.7"}}, "203.0.113.7"},
+		"all trusted":                     {"10.0.0.1:5000", map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, "10.0.0.3"},
+		"x-forwarded-for with port":       {"10.0.0.1:5000", map[string][]string{"X-Forwarded-For": {"203.0.113.7:4711"}}, "203.0.113.7"},
+		"invalid x-forwarded-for":         {"10.0.0.1:5000", map[string][]string{"X-Forwarded-For": {"203.0.113.7, unknown"}}, "10.0.0.1"},
+		"x-real-ip":                       {"10.0.0.1:5000", map[string][]string{"X-Real-Ip": {"203.0.113.7"}}, "203.0.113.7"},
+		"invalid x-real-ip":               {"10.0.0.1:5000", map[string][]string{"X-Real-Ip": {"unknown"}}, "10.0.0.1"},
+		"x-forwarded-for over x-real-ip":  {"10.0.0.1:5000", map[string][]string{"X-Forwarded-For": {"203.0.113.7"}, "X-Real-Ip": {"198.51.100.1"}}, "203.0.113.7"},
+		"forwarded":                       {"10.0.0.1:5000", map[string][]string{"Forwarded": {`for=203.0.113.7;proto=https`}}, "203.0.113.7"},
+		"forwarded through proxies":       {"10.0.0.1:5000", map[string][]string{"Forwarded": {`For="203.0.113.7:4711", for=10.0.0.2;by=10.0.0.1`}}, "203.0.113.7"},
+		"forwarded ipv6":                  {"[fd00::1]:5000", map[string][]string{"Forwarded": {`for="[2001:db8::7]:4711"`}}, "2001:db8::7"},
+		"forwarded over x-forwarded-for":  {"10.0.0.1:5000", map[string][]string{"Forwarded": {"for=203.0.113.7"}, "X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.7"},
+		"obfuscated forwarded":            {"10.0.0.1:5000", map[string][]string{"Forwarded": {"for=_hidden, for=10.0.0.2"}}, "10.0.0.2"},
+		"forwarded without for":           {"10.0.0.1:5000", map[string][]string{"Forwarded": {"proto=https"}}, "10.0.0.1"},
+		"mapped ipv4":                     {"10.0.0.1:5000", map[string][]string{"X-Forwarded-For": {"::ffff:203.0.113.7"}}, "203.0.113.7"},
+	} {
+		req := httptest.NewRequest("POST", "/api/v1/track/pageview", nil)
+		req.RemoteAddr = test.remoteAddr
+		for header, values := range test.headers {
+			req.Header[header] = values
+		}
+		assert.Equal(t, test.expected, resolver.Resolve(req), name)
+	}
+
+	// Without trusted proxies, forwarding headers are ignored
+	req := httptest.NewRequest("POST", "/api/v1/track/pageview", nil)
+	req.RemoteAddr = "10.0.0.1:5000"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	assert.Equal(t, "10.0.0.1", NewClientIPResolver(nil).Resolve(req))
+}
+
+func TestClientIP(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/v1/track/pageview", nil)
+	req.RemoteAddr = "10.0.0.1:5000"
+	assert.Equal(t, "10.0.0.1", ClientIP(req))
+
+	req = req.WithContext(ContextWithClientIP(req.Context(), "203.0.113.7"))
+	assert.Equal(t, "203.0.113.7", ClientIP(req))
+}
+
+func TestClientLimiter(t *testing.T) {
+	limiter := middleware.NewClientLimiter(rate.Limit(0.001), 2)
+
+	assert.True(t, limiter.Allow("203.0.113.7"))
+	assert.True(t, limiter.Allow("203.0.113.7"))
+	assert.False(t, limiter.Allow("203.0.113.7"))
+
+	// Each client has its own limit
+	assert.True(t, limiter.Allow("198.51.100.1"))
+}
+
+func TestRouter_TrustedProxy(t *testing.T) {
+	t.Setenv("ALLOWED_ORIGINS", "http://localhost:3000")
+
+	repo := NewMemoryRepository()
+	domainId, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+	assert.NoError(t, repo.SetIPMode(context.Background(), int(domainId), IPModeFull))
+
+	proxies, err := config.ParseTrustedProxies("10.0.0.0/8")
+	assert.NoError(t, err)
+	mw := middleware.NewMiddleware(service.NewService(repo))
+	mw.SetTrustedProxies(proxies)
+	r := router.NewRouter(NewHandlers(repo), mw)
+
+	// Clients behind the proxy are rate limited separately
+	for i := 0; i < 51; i++ {
+		req := trackRequest("/api/v1/track/pageview", `{"url":"http://localhost:3000/about"}`)
+		req.RemoteAddr = "10.0.0.1:5000"
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, req)
+		if i < 50 {
+			assert.Equal(t, http.StatusOK, recorder.Code)
+		} else {
+			assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+		}
+	}
+
+	req := trackRequest("/api/v1/track/pageview", `{"url":"http://localhost:3000/about"}`)
+	req.RemoteAddr = "10.0.0.1:5000"
+	req.Header.Set("Forwarded", "for=198.51.100.1")
+
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	pageViews := repo.PageViews()
+	assert.Len(t, pageViews, 51)
+	assert.Equal(t, "203.0.113.7", repo.IPAddress(pageViews[0].IPAddressID))
+	assert.Equal(t, "198.51.100.1", repo.IPAddress(pageViews[50].IPAddressID))
+}
@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/jwtly10/simple-site-tracker/api/track"
+	"github.com/jwtly10/simple-site-tracker/spool"
+	"github.com/stretchr/testify/assert"
+)
+
+// stuckRepository is a MemoryRepository whose batch inserts hang until their context is cancelled.
+type stuckRepository struct {
+	*MemoryRepository
+}
+
+func (repo stuckRepository) SavePageViews(ctx context.Context, pageViews []PageView) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestSQLiteRepository_QueryTimeout(t *testing.T) {
+	repo, _ := newSQLiteRepository(t)
+	_, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+
+	repo.SetQueryTimeout(time.Nanosecond)
+	_, err = repo.GetDomain(context.Background(), "localhost")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	repo.SetQueryTimeout(0)
+	_, err = repo.GetDomain(context.Background(), "localhost")
+	assert.NoError(t, err)
+}
+
+func TestHandlers_CancelledRequestIsNotSaved(t *testing.T) {
+	repo, db := newSQLiteRepository(t)
+	_, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	req := httptest.NewRequest("POST", "/api/v1/track/pageview", strings.NewReader(`{"url":"http://localhost:3000/about"}`)).WithContext(ctx)
+	req.Header.Set("Origin", "http://localhost:3000")
+
+	recorder := httptest.NewRecorder()
+	NewHandlers(repo).TrackPageViewHandler(recorder, req)
+
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+
+	var views int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM page_views_tb").Scan(&views))
+	assert.Equal(t, 0, views)
+}
+
+func TestEventWriter_CloseCancelsStuckWrites(t *testing.T) {
+	sp, err := spool.Open(spool.Config{Dir: t.TempDir()})
+	assert.NoError(t, err)
+
+	repo := stuckRepository{NewMemoryRepository()}
+	_, err = repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+
+	writer := NewEventWriter(repo, WriterConfig{Spooler: sp})
+	writer.Start()
+	for _, event := range spoolEvents(3) {
+		assert.NoError(t, writer.Enqueue(event))
+	}
+
+	// Shutdown doesn't wait on the stuck insert, and the events are spooled rather than lost
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, writer.Close(ctx), context.DeadlineExceeded)
+	assert.Equal(t, int64(3), writer.Stats().Spooled)
+}
@@ -0,0 +1,118 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/jwtly10/simple-site-tracker/api/track"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestValidateCustomEvent(t *testing.T) {
+	tooMany := make(map[string]interface{})
+	for i := 0; i <= MaxEventProps; i++ {
+		tooMany[fmt.Sprintf("prop%d", i)] = i
+	}
+
+	for name, test := range map[string]struct {
+		name  string
+		props map[string]interface{}
+		valid bool
+	}{
+		"no props":         {"signup_started", nil, true},
+		"typed props":      {"pricing_toggle", map[string]interface{}{"plan": "pro", "seats": 5.0, "annual": true}, true},
+		"empty name":       {"", nil, false},
+		"name with spaces": {"signup started", nil, false},
+		"quoted key":       {"signup_started", map[string]interface{}{`plan"`: "pro"}, false},
+		"nested value":     {"signup_started", map[string]interface{}{"plan": map[string]interface{}{"name": "pro"}}, false},
+		"null value":       {"signup_started", map[string]interface{}{"plan": nil}, false},
+		"long value":       {"signup_started", map[string]interface{}{"plan": strings.Repeat("a", MaxEventPropLength+1)}, false},
+		"too many props":   {"signup_started", tooMany, false},
+	} {
+		err := ValidateCustomEvent(test.name, test.props)
+		if test.valid {
+			assert.NoError(t, err, name)
+		} else {
+			assert.Error(t, err, name)
+		}
+	}
+}
+
+func TestHandlers_TrackEventHandler(t *testing.T) {
+	mockRepo := &MockRepository{}
+	handlers := NewHandlers(mockRepo)
+
+	mockRepo.On("GetDomain", "localhost").Return(1, nil)
+	mockRepo.On("GetIPPolicy", 1).Return(IPPolicy{Mode: IPModeDisabled}, nil)
+	mockRepo.On("GetOrCreatePage", 1, "/pricing").Return(2, nil)
+	mockRepo.On("SaveCustomEvent", 1, 2, "pricing_toggle", map[string]interface{}{"plan": "pro", "annual": true}).Return(42, nil)
+
+	data := `{"name":"pricing_toggle","props":{"plan":"pro","annual":true},"url":"http://localhost:3000/pricing"}`
+	req := httptest.NewRequest("POST", "/api/v1/track/event", strings.NewReader(data))
+	req.Header.Set("Origin", "http://localhost:3000")
+
+	recorder := httptest.NewRecorder()
+	handlers.TrackEventHandler(recorder, req)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandlers_TrackEventHandler_InvalidProps(t *testing.T) {
+	mockRepo := &MockRepository{}
+	handlers := NewHandlers(mockRepo)
+
+	data := `{"name":"pricing_toggle","props":{"plan":["pro"]},"url":"http://localhost:3000/pricing"}`
+	req := httptest.NewRequest("POST", "/api/v1/track/event", strings.NewReader(data))
+	req.Header.Set("Origin", "http://localhost:3000")
+
+	recorder := httptest.NewRecorder()
+	handlers.TrackEventHandler(recorder, req)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	mockRepo.AssertNotCalled(t, "SaveCustomEvent", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestEventPropertyBreakdown(t *testing.T) {
+	forEachRepository(t, func(name string, repo RepositoryInterface, db *sql.DB, domainId int64, post postFunc) {
+		// Saved through the event writer, as the handlers do
+		createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		var events []Event
+		for _, props := range []map[string]interface{}{
+			{"plan": "pro", "seats": 5.0, "annual": true},
+			{"plan": "pro", "seats": 1.5, "annual": false},
+			{"plan": "team", "seats": 5.0, "annual": true},
+			{"seats": 5.0},
+			nil,
+		} {
+			events = append(events, Event{Type: EventCustom, Domain: "localhost", Page: "/pricing", CreatedAt: createdAt, Name: "pricing_toggle", Props: props})
+		}
+		events = append(events, Event{Type: EventCustom, Domain: "localhost", Page: "/pricing", CreatedAt: createdAt, Name: "signup_started", Props: map[string]interface{}{"plan": "pro"}})
+		events = append(events, Event{Type: EventCustom, Domain: "localhost", Page: "/pricing", CreatedAt: createdAt.AddDate(0, 0, 1), Name: "pricing_toggle", Props: map[string]interface{}{"plan": "pro"}})
+
+		written, dropped, retry, rejected := WriteEvents(context.Background(), repo, events)
+		assert.Equal(t, len(events), written, name)
+		assert.Zero(t, dropped, name)
+		assert.Empty(t, retry, name)
+		assert.Empty(t, rejected, name)
+
+		from, to := createdAt.Truncate(24*time.Hour), createdAt.Truncate(24*time.Hour).AddDate(0, 0, 1)
+		breakdown := func(property string) []EventPropertyCount {
+			counts, err := repo.GetEventPropertyBreakdown(context.Background(), int(domainId), "pricing_toggle", property, from, to)
+			assert.NoError(t, err, name)
+			return counts
+		}
+
+		assert.Equal(t, []EventPropertyCount{{Value: "pro", Count: 2}, {Value: "team", Count: 1}}, breakdown("plan"), name)
+		assert.Equal(t, []EventPropertyCount{{Value: "5", Count: 3}, {Value: "1.5", Count: 1}}, breakdown("seats"), name)
+		assert.Equal(t, []EventPropertyCount{{Value: "true", Count: 2}, {Value: "false", Count: 1}}, breakdown("annual"), name)
+		assert.Empty(t, breakdown("missing"), name)
+	})
+}
@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/jwtly10/simple-site-tracker/api/track"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateEngagement(t *testing.T) {
+	assert.NoError(t, ValidateEngagement("6f1c2a9e-3b4d-4e5f-8a9b-0c1d2e3f4a5b", 15))
+	assert.NoError(t, ValidateEngagement("lq2x3k9-4fzyo82m", 1))
+
+	assert.Error(t, ValidateEngagement("", 15))
+	assert.Error(t, ValidateEngagement("short", 15))
+	assert.Error(t, ValidateEngagement("not a view id!", 15))
+	assert.Error(t, ValidateEngagement("6f1c2a9e-3b4d-4e5f-8a9b-0c1d2e3f4a5b", 0))
+	assert.Error(t, ValidateEngagement("6f1c2a9e-3b4d-4e5f-8a9b-0c1d2e3f4a5b", -5))
+
+	// View IDs are optional on page views
+	assert.NoError(t, ValidateViewID(""))
+	assert.Error(t, ValidateViewID(strings.Repeat("a", 37)))
+}
+
+func TestPageEngagement(t *testing.T) {
+	forEachRepository(t, func(name string, repo RepositoryInterface, db *sql.DB, domainId int64, post postFunc) {
+		handlers := NewHandlers(repo)
+		for _, pageView := range []string{
+			`{"url":"http://localhost:3000/about","view_id":"view-0001"}`,
+			`{"url":"http://localhost:3000/about","view_id":"view-0002"}`,
+			`{"url":"http://localhost:3000/pricing","view_id":"view-0003"}`,
+			// Page views without a view ID don't report engagement, so aren't averaged
+			`{"url":"http://localhost:3000/pricing"}`,
+		} {
+			assert.Equal(t, http.StatusOK, post(handlers.TrackPageViewHandler, "/api/v1/track/pageview", pageView), name)
+		}
+		assert.Equal(t, http.StatusBadRequest, post(handlers.TrackPageViewHandler, "/api/v1/track/pageview", `{"url":"http://localhost:3000/","view_id":"bad id"}`), name)
+
+		for _, engagement := range []string{
+			`{"url":"http://localhost:3000/about","view_id":"view-0001","engaged_seconds":15}`,
+			`{"url":"http://localhost:3000/about","view_id":"view-0001","engaged_seconds":45}`,
+			// Reports are clamped
+			`{"url":"http://localhost:3000/about","view_id":"view-0002","engaged_seconds":10000}`,
+			// Unknown page views are ignored
+			`{"url":"http://localhost:3000/about","view_id":"view-9999","engaged_seconds":15}`,
+		} {
+			assert.Equal(t, http.StatusOK, post(handlers.TrackEngagementHandler, "/api/v1/track/engagement", engagement), name)
+		}
+		assert.Equal(t, http.StatusBadRequest, post(handlers.TrackEngagementHandler, "/api/v1/track/engagement", `{"url":"http://localhost:3000/about","view_id":"view-0001","engaged_seconds":0}`), name)
+		assert.Equal(t, http.StatusBadRequest, post(handlers.TrackEngagementHandler, "/api/v1/track/engagement", `{"url":"http://localhost:3000/about","engaged_seconds":15}`), name)
+
+		now := time.Now()
+		engagement, err := repo.GetPageEngagement(context.Background(), int(domainId), now.Add(-time.Hour), now.Add(time.Hour))
+		assert.NoError(t, err, name)
+		assert.Equal(t, []PageEngagement{
+			{Page: "/about", Views: 2, EngagedSeconds: 60 + MaxEngagedSeconds, AverageSeconds: float64(60+MaxEngagedSeconds) / 2},
+			{Page: "/pricing", Views: 1},
+		}, engagement, name)
+
+		// Engagement isn't stored as a page or event of its own
+		counts, err := repo.GetVisitorCounts(context.Background(), int(domainId), now.Add(-time.Hour), now.Add(time.Hour))
+		assert.NoError(t, err, name)
+		assert.Equal(t, int64(4), counts.PageViews, name)
+	})
+}
+
+func TestHandlers_BatchEngagement(t *testing.T) {
+	repo := NewMemoryRepository()
+	domainId, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+
+	// A page view left quickly sends its engagement in the same batch
+	recorder, response := trackBatch(NewHandlers(repo), `{"events":[
+		{"type":"pageview","url":"http://localhost:3000/about","view_id":"view-0001"},
+		{"type":"engagement","url":"http://localhost:3000/about","view_id":"view-0001","engaged_seconds":4},
+		{"type":"engagement","url":"http://localhost:3000/about","engaged_seconds":4}
+	]}`)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, BatchStatusSaved, response.Results[0].Status)
+	assert.Equal(t, BatchStatusSaved, response.Results[1].Status)
+	assert.Equal(t, BatchStatusInvalid, response.Results[2].Status)
+
+	assert.Len(t, repo.PageViews(), 1)
+	assert.Equal(t, "view-0001", repo.PageViews()[0].ViewID)
+	assert.Equal(t, 4, repo.PageViews()[0].EngagedSeconds)
+
+	now := time.Now()
+	engagement, err := repo.GetPageEngagement(context.Background(), int(domainId), now.Add(-time.Hour), now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, []PageEngagement{{Page: "/about", Views: 1, EngagedSeconds: 4, AverageSeconds: 4}}, engagement)
+}
+
+func TestRouter_HourOfHeartbeatsIsAccepted(t *testing.T) {
+	repo := NewMemoryRepository()
+	domainId, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+
+	r := newMemoryRouter(t, repo)
+	send := func(path, body string) int {
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, trackRequest(path, body))
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusOK, send("/api/v1/track/pageview", `{"url":"http://localhost:3000/article","view_id":"view-0001"}`))
+
+	// An hour of reading sends a heartbeat every minute, and a flush each time the reader switches tabs,
+	// more than the 50 requests an hour allowed to the other routes
+	for i := 0; i < 60; i++ {
+		assert.Equal(t, http.StatusOK, send("/api/v1/track/batch", `{"events":[{"type":"engagement","url":"http://localhost:3000/article","view_id":"view-0001","engaged_seconds":55}]}`), i)
+		assert.Equal(t, http.StatusOK, send("/api/v1/track/engagement", `{"url":"http://localhost:3000/article","view_id":"view-0001","engaged_seconds":5}`), i)
+	}
+
+	now := time.Now()
+	engagement, err := repo.GetPageEngagement(context.Background(), int(domainId), now.Add(-time.Hour), now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, []PageEngagement{{Page: "/article", Views: 1, EngagedSeconds: 3600, AverageSeconds: 3600}}, engagement)
+
+	// Background requests don't use up the limit of the other routes
+	assert.Equal(t, http.StatusOK, send("/api/v1/track/pageview", `{"url":"http://localhost:3000/next","view_id":"view-0002"}`))
+}
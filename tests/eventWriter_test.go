@@ -0,0 +1,196 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/jwtly10/simple-site-tracker/api/track"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestEventWriter_FlushesOnClose(t *testing.T) {
+	repo := NewMemoryRepository()
+	_, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+
+	writer := NewEventWriter(repo, WriterConfig{BatchSize: 100, FlushInterval: time.Hour})
+	writer.Start()
+
+	now := time.Now()
+	assert.NoError(t, writer.Enqueue(Event{Type: EventPageView, Domain: "localhost", Page: "/", CreatedAt: now}))
+	assert.NoError(t, writer.Enqueue(Event{Type: EventPageView, Domain: "localhost", Page: "/about", CreatedAt: now}))
+	assert.NoError(t, writer.Enqueue(Event{Type: EventClick, Domain: "localhost", Page: "/about", CreatedAt: now, Element: map[string]interface{}{"tag": "a"}}))
+	assert.NoError(t, writer.Enqueue(Event{Type: EventUTM, Domain: "localhost", Page: "/", CreatedAt: now, UTMSource: "newsletter"}))
+	assert.NoError(t, writer.Enqueue(Event{Type: EventPageView, Domain: "unknown", Page: "/", CreatedAt: now}))
+
+	assert.NoError(t, writer.Close(context.Background()))
+
+	assert.Len(t, repo.PageViews(), 2)
+	assert.True(t, repo.PageViews()[0].CreatedAt.Equal(now))
+	assert.Len(t, repo.Clicks(), 1)
+	assert.Equal(t, "newsletter", repo.UTMs()[0].UTMSource)
+
+	// Both /about events share the page created for the first one
+	assert.Equal(t, repo.PageViews()[1].PageID, repo.Clicks()[0].PageID)
+
+	stats := writer.Stats()
+	assert.Equal(t, int64(5), stats.Enqueued)
+	assert.Equal(t, int64(4), stats.Written)
+	assert.Equal(t, int64(1), stats.Dropped)
+	assert.Equal(t, int64(0), stats.Failed)
+
+	assert.ErrorIs(t, writer.Enqueue(Event{Type: EventPageView}), ErrWriterClosed)
+}
+
+func TestEventWriter_FlushesOnInterval(t *testing.T) {
+	repo := NewMemoryRepository()
+	_, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+
+	writer := NewEventWriter(repo, WriterConfig{BatchSize: 100, FlushInterval: 10 * time.Millisecond})
+	writer.Start()
+	defer writer.Close(context.Background())
+
+	assert.NoError(t, writer.Enqueue(Event{Type: EventPageView, Domain: "localhost", Page: "/", CreatedAt: time.Now()}))
+
+	assert.Eventually(t, func() bool { return len(repo.PageViews()) == 1 }, time.Second, 5*time.Millisecond)
+}
+
+func TestEventWriter_BatchesInserts(t *testing.T) {
+	mockRepo := &MockRepository{}
+	mockRepo.On("GetDomain", "localhost").Return(1, nil)
+	mockRepo.On("GetOrCreatePage", 1, "/").Return(2, nil)
+	mockRepo.On("SavePageViews", mock.MatchedBy(func(pvs []PageView) bool { return len(pvs) == 3 })).Return(nil)
+	mockRepo.On("SaveClicks", mock.Anything).Return(nil)
+	mockRepo.On("SaveUTMs", mock.Anything).Return(nil)
+
+	writer := NewEventWriter(mockRepo, WriterConfig{BatchSize: 3, FlushInterval: time.Hour})
+	writer.Start()
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, writer.Enqueue(Event{Type: EventPageView, Domain: "localhost", Page: "/", CreatedAt: time.Now()}))
+	}
+	assert.NoError(t, writer.Close(context.Background()))
+
+	// The domain and page are looked up once per batch, not per event
+	mockRepo.AssertNumberOfCalls(t, "GetDomain", 1)
+	mockRepo.AssertNumberOfCalls(t, "GetOrCreatePage", 1)
+	mockRepo.AssertNumberOfCalls(t, "SavePageViews", 1)
+}
+
+func TestEventWriter_FailedBatchIsCounted(t *testing.T) {
+	mockRepo := &MockRepository{}
+	mockRepo.On("GetDomain", "localhost").Return(1, nil)
+	mockRepo.On("GetOrCreatePage", 1, "/").Return(2, nil)
+	mockRepo.On("SavePageViews", mock.Anything).Return(syscall.ECONNREFUSED)
+	mockRepo.On("SaveClicks", mock.Anything).Return(nil)
+	mockRepo.On("SaveUTMs", mock.Anything).Return(nil)
+
+	writer := NewEventWriter(mockRepo, WriterConfig{})
+	writer.Start()
+
+	assert.NoError(t, writer.Enqueue(Event{Type: EventPageView, Domain: "localhost", Page: "/", CreatedAt: time.Now()}))
+	assert.NoError(t, writer.Close(context.Background()))
+
+	assert.Equal(t, int64(1), writer.Stats().Failed)
+	assert.Equal(t, int64(0), writer.Stats().Written)
+}
+
+func TestEventWriter_DroppedEventsAreNotFailed(t *testing.T) {
+	mockRepo := &MockRepository{}
+	mockRepo.On("GetDomain", "localhost").Return(1, nil)
+	mockRepo.On("GetDomain", "unknown.com").Return(0, sql.ErrNoRows)
+	mockRepo.On("GetBotMode", 1).Return(BotModeDrop, nil)
+	mockRepo.On("GetOrCreatePage", 1, "/").Return(2, nil)
+	mockRepo.On("SavePageViews", mock.Anything).Return(nil)
+
+	writer := NewEventWriter(mockRepo, WriterConfig{})
+	writer.Start()
+
+	bot := Event{Type: EventPageView, Domain: "localhost", Page: "/", CreatedAt: time.Now()}
+	bot.Bot = true
+	assert.NoError(t, writer.Enqueue(bot))
+	assert.NoError(t, writer.Enqueue(Event{Type: EventPageView, Domain: "unknown.com", Page: "/", CreatedAt: time.Now()}))
+	assert.NoError(t, writer.Enqueue(Event{Type: EventPageView, Domain: "localhost", Page: "/", CreatedAt: time.Now()}))
+	assert.NoError(t, writer.Close(context.Background()))
+
+	stats := writer.Stats()
+	assert.Equal(t, int64(1), stats.Written)
+	assert.Equal(t, int64(2), stats.Dropped)
+	assert.Equal(t, int64(0), stats.Failed)
+}
+
+func TestWriteEvents_RejectedBatchIsSavedOneAtATime(t *testing.T) {
+	mockRepo := &MockRepository{}
+	mockRepo.On("GetDomain", "localhost").Return(1, nil)
+	mockRepo.On("GetOrCreatePage", 1, "/").Return(2, nil)
+	mockRepo.On("GetOrCreatePage", 1, "/too-long").Return(3, nil)
+	// The database rejects any insert with the page view of /too-long
+	rejects := func(pageViews []PageView) bool {
+		for _, pv := range pageViews {
+			if pv.PageID == 3 {
+				return true
+			}
+		}
+		return false
+	}
+	mockRepo.On("SavePageViews", mock.MatchedBy(rejects)).Return(errors.New("Data too long for column"))
+	mockRepo.On("SavePageViews", mock.Anything).Return(nil)
+
+	events := []Event{
+		{Type: EventPageView, Domain: "localhost", Page: "/", CreatedAt: time.Now()},
+		{Type: EventPageView, Domain: "localhost", Page: "/too-long", CreatedAt: time.Now()},
+		{Type: EventPageView, Domain: "localhost", Page: "/", CreatedAt: time.Now()},
+	}
+	written, _, retry, rejected := WriteEvents(context.Background(), mockRepo, events)
+	assert.Equal(t, 2, written)
+	assert.Empty(t, retry)
+	assert.Equal(t, []int{1}, rejected)
+
+	// Unavailable databases fail the whole batch, to be retried
+	mockRepo = &MockRepository{}
+	mockRepo.On("GetDomain", "localhost").Return(1, nil)
+	mockRepo.On("GetOrCreatePage", 1, mock.Anything).Return(2, nil)
+	mockRepo.On("SavePageViews", mock.Anything).Return(syscall.ECONNREFUSED)
+
+	written, _, retry, rejected = WriteEvents(context.Background(), mockRepo, events)
+	assert.Equal(t, 0, written)
+	assert.Equal(t, []int{0, 1, 2}, retry)
+	assert.Empty(t, rejected)
+	mockRepo.AssertNumberOfCalls(t, "SavePageViews", 1)
+}
+
+func TestEventWriter_QueueFull(t *testing.T) {
+	mockRepo := &MockRepository{}
+	handlers := NewHandlers(mockRepo)
+
+	// The writer is never started, so the single slot stays full
+	writer := NewEventWriter(mockRepo, WriterConfig{QueueSize: 1})
+	handlers.SetEventWriter(writer)
+
+	codes := make([]int, 0, 2)
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest("POST", "/api/v1/track/pageview", strings.NewReader(`{"url":"http://localhost:3000/about"}`))
+		assert.NoError(t, err)
+		req.Header.Set("Origin", "http://localhost:3000")
+
+		recorder := httptest.NewRecorder()
+		handlers.TrackPageViewHandler(recorder, req)
+		codes = append(codes, recorder.Code)
+	}
+
+	assert.Equal(t, []int{http.StatusAccepted, http.StatusServiceUnavailable}, codes)
+	assert.Equal(t, int64(1), writer.Stats().Rejected)
+	assert.Equal(t, 1, writer.Stats().QueueDepth)
+
+	// Nothing touched the repository
+	mockRepo.AssertExpectations(t)
+}
@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/jwtly10/simple-site-tracker/api/track"
+	"github.com/stretchr/testify/assert"
+)
+
+// geoIPFixture is a tiny database of documentation ranges, written by testdata/generate_geoip.go.
+const geoIPFixture = "testdata/geoip.mmdb"
+
+func openGeoIPFixture(t *testing.T) *GeoIP {
+	geoIP, err := OpenGeoIP(geoIPFixture)
+	assert.NoError(t, err)
+	t.Cleanup(func() { geoIP.Close() })
+	return geoIP
+}
+
+func TestGeoIP_Lookup(t *testing.T) {
+	geoIP := openGeoIPFixture(t)
+
+	for ip, expected := range map[string]Geo{
+		"203.0.113.7":        {Country: "GB", Region: "England", City: "London"},
+		"::ffff:203.0.113.7": {Country: "GB", Region: "England", City: "London"},
+		"198.51.100.1":       {Country: "US", Region: "California", City: "San Francisco"},
+		"192.0.2.1":          {Country: "FR"},
+		"2001:db8::1":        {Country: "DE", Region: "Berlin", City: "Berlin"},
+		"10.0.0.1":           {},
+		"2001:db9::1":        {},
+		"not an ip":          {},
+		"":                   {},
+	} {
+		assert.Equal(t, expected, geoIP.Lookup(ip), ip)
+	}
+}
+
+func TestOpenGeoIP_Invalid(t *testing.T) {
+	_, err := OpenGeoIP("testdata/missing.mmdb")
+	assert.Error(t, err)
+
+	_, err = OpenGeoIP("geo_test.go")
+	assert.Error(t, err)
+}
+
+func TestGeoDimensionCounts(t *testing.T) {
+	geoIP := openGeoIPFixture(t)
+
+	forEachRepository(t, func(name string, repo RepositoryInterface, db *sql.DB, domainId int64, post postFunc) {
+		handlers := NewHandlers(repo)
+		handlers.SetGeoIP(geoIP)
+		for _, addr := range []string{"203.0.113.7:5000", "203.0.113.8:5000", "198.51.100.1:5000", "192.0.2.1:5000", "[2001:db8::1]:5000", "10.0.0.1:5000"} {
+			assert.Equal(t, http.StatusOK, post(handlers.TrackPageViewHandler, "/api/v1/track/pageview", `{"url":"http://localhost:3000/"}`, withRemoteAddr(addr)), name)
+		}
+
+		now := time.Now()
+		dimension := func(dimension string) []DimensionCount {
+			counts, err := repo.GetDimensionCounts(context.Background(), int(domainId), dimension, now.Add(-time.Hour), now.Add(time.Hour))
+			assert.NoError(t, err, name)
+			return counts
+		}
+
+		assert.Equal(t, []DimensionCount{{Value: "GB", Count: 2}, {Value: "", Count: 1}, {Value: "DE", Count: 1}, {Value: "FR", Count: 1}, {Value: "US", Count: 1}}, dimension(DimensionCountry), name)
+		assert.Equal(t, []DimensionCount{{Value: "", Count: 2}, {Value: "England, GB", Count: 2}, {Value: "Berlin, DE", Count: 1}, {Value: "California, US", Count: 1}}, dimension(DimensionRegion), name)
+		assert.Equal(t, []DimensionCount{{Value: "", Count: 2}, {Value: "London, England, GB", Count: 2}, {Value: "Berlin, Berlin, DE", Count: 1}, {Value: "San Francisco, California, US", Count: 1}}, dimension(DimensionCity), name)
+
+		// The IP addresses are only used for the lookup
+		if db == nil {
+			return
+		}
+		rows, err := db.Query("SELECT * FROM page_views_tb")
+		assert.NoError(t, err)
+		columns, err := rows.Columns()
+		assert.NoError(t, err)
+		for rows.Next() {
+			values := make([]interface{}, len(columns))
+			dest := make([]interface{}, len(columns))
+			for i := range values {
+				dest[i] = &values[i]
+			}
+			assert.NoError(t, rows.Scan(dest...))
+			for i, value := range values {
+				stored := fmt.Sprint(value)
+				for _, ip := range []string{"203.0.113", "198.51.100", "192.0.2", "2001:db8", "10.0.0"} {
+					assert.NotContains(t, stored, ip, columns[i])
+				}
+			}
+		}
+		assert.NoError(t, rows.Close())
+
+		var ipAddresses int
+		assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM ip_addresses_tb").Scan(&ipAddresses))
+		assert.Zero(t, ipAddresses)
+	})
+}
@@ -0,0 +1,125 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/jwtly10/simple-site-tracker/api/track"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIPPolicy_Anonymise(t *testing.T) {
+	for name, test := range map[string]struct {
+		policy   IPPolicy
+		ip       string
+		expected string
+	}{
+		"disabled":            {IPPolicy{Mode: IPModeDisabled}, "203.0.113.7", ""},
+		"full ipv4":           {IPPolicy{Mode: IPModeFull}, "203.0.113.7", "203.0.113.7"},
+		"full mapped ipv4":    {IPPolicy{Mode: IPModeFull}, "::ffff:203.0.113.7", "203.0.113.7"},
+		"full ipv6":           {IPPolicy{Mode: IPModeFull}, "2001:DB8:1:2::7", "2001:db8:1:2::7"},
+		"truncated ipv4":      {IPPolicy{Mode: IPModeTruncated}, "203.0.113.7", "203.0.113.0"},
+		"truncated ipv6":      {IPPolicy{Mode: IPModeTruncated}, "2001:db8:1:2::7", "2001:db8:1::"},
+		"hash":                {IPPolicy{Mode: IPModeHash, HashKey: "secret"}, "203.0.113.7", "d1eda5f85436ad2d5e25828b7bc77ca290d6c797a9ab2479327aec37fa3e09d3"},
+		"hash without a key":  {IPPolicy{Mode: IPModeHash}, "203.0.113.7", ""},
+		"invalid ip":          {IPPolicy{Mode: IPModeFull}, "not an ip", ""},
+		"empty ip":            {IPPolicy{Mode: IPModeFull}, "", ""},
+		"unknown mode":        {IPPolicy{Mode: "partial"}, "203.0.113.7", ""},
+		"hash of mapped ipv4": {IPPolicy{Mode: IPModeHash, HashKey: "secret"}, "::ffff:203.0.113.7", "d1eda5f85436ad2d5e25828b7bc77ca290d6c797a9ab2479327aec37fa3e09d3"},
+	} {
+		assert.Equal(t, test.expected, test.policy.Anonymise(test.ip), name)
+	}
+
+	// Sites' hashes of the same address can't be linked
+	assert.NotEqual(t, IPPolicy{Mode: IPModeHash, HashKey: "a"}.Anonymise("203.0.113.7"), IPPolicy{Mode: IPModeHash, HashKey: "b"}.Anonymise("203.0.113.7"))
+}
+
+func TestIPModes(t *testing.T) {
+	forEachRepository(t, func(name string, repo RepositoryInterface, db *sql.DB, domainId int64, post postFunc) {
+		// IP addresses aren't stored unless a site sets a mode
+		policy, err := repo.GetIPPolicy(context.Background(), int(domainId))
+		assert.NoError(t, err, name)
+		assert.Equal(t, IPPolicy{Mode: IPModeDisabled}, policy, name)
+
+		assert.Error(t, repo.SetIPMode(context.Background(), int(domainId), "partial"), name)
+		assert.ErrorIs(t, repo.SetIPMode(context.Background(), 999, IPModeFull), sql.ErrNoRows, name)
+
+		handlers := NewHandlers(repo)
+		track := func(addr string) {
+			assert.Equal(t, http.StatusOK, post(handlers.TrackPageViewHandler, "/api/v1/track/pageview", `{"url":"http://localhost:3000/"}`, withRemoteAddr(addr)), name)
+		}
+
+		track("203.0.113.7:5000")
+
+		assert.NoError(t, repo.SetIPMode(context.Background(), int(domainId), IPModeFull), name)
+		track("203.0.113.7:5000")
+
+		assert.NoError(t, repo.SetIPMode(context.Background(), int(domainId), IPModeTruncated), name)
+		track("203.0.113.7:5000")
+		track("203.0.113.8:5000")
+
+		assert.NoError(t, repo.SetIPMode(context.Background(), int(domainId), IPModeHash), name)
+		policy, err = repo.GetIPPolicy(context.Background(), int(domainId))
+		assert.NoError(t, err, name)
+		assert.Equal(t, IPModeHash, policy.Mode, name)
+		assert.Len(t, policy.HashKey, 64, name)
+		track("203.0.113.7:5000")
+
+		// The hash key is kept, so hashes stay comparable if a site switches back
+		assert.NoError(t, repo.SetIPMode(context.Background(), int(domainId), IPModeDisabled), name)
+		assert.NoError(t, repo.SetIPMode(context.Background(), int(domainId), IPModeHash), name)
+		rehashed, err := repo.GetIPPolicy(context.Background(), int(domainId))
+		assert.NoError(t, err, name)
+		assert.Equal(t, policy.HashKey, rehashed.HashKey, name)
+
+		assert.Equal(t, []string{"", "203.0.113.7", "203.0.113.0", "203.0.113.0", policy.Anonymise("203.0.113.7")}, pageViewIPAddresses(t, repo, db), name)
+	})
+}
+
+func TestHandlers_BatchIPAddress(t *testing.T) {
+	repo := NewMemoryRepository()
+	domainId, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+	assert.NoError(t, repo.SetIPMode(context.Background(), int(domainId), IPModeTruncated))
+
+	req := httptest.NewRequest("POST", "/api/v1/track/batch", strings.NewReader(mixedBatch))
+	req.Header.Set("Origin", "http://localhost:3000")
+	req.RemoteAddr = "[2001:db8:1:2::7]:5000"
+	NewHandlers(repo).TrackBatchHandler(httptest.NewRecorder(), req)
+
+	id, err := repo.SaveIPAddress(context.Background(), "2001:db8:1::")
+	assert.NoError(t, err)
+	assert.Equal(t, int(id), repo.PageViews()[0].IPAddressID)
+	assert.Equal(t, int(id), repo.Clicks()[0].IPAddressID)
+	assert.Equal(t, int(id), repo.UTMs()[0].IPAddressID)
+	assert.Equal(t, int(id), repo.CustomEvents()[0].IPAddressID)
+}
+
+// pageViewIPAddresses returns the stored IP address of each page view in order, or an empty string if it has none.
+func pageViewIPAddresses(t *testing.T, repo RepositoryInterface, db *sql.DB) []string {
+	var ipAddresses []string
+	if memRepo, ok := repo.(*MemoryRepository); ok {
+		for _, pv := range memRepo.PageViews() {
+			ipAddress := ""
+			if pv.IPAddressID != 0 {
+				ipAddress = memRepo.IPAddress(pv.IPAddressID)
+			}
+			ipAddresses = append(ipAddresses, ipAddress)
+		}
+		return ipAddresses
+	}
+
+	rows, err := db.Query("SELECT COALESCE(ip.ip_address, '') FROM page_views_tb pv LEFT JOIN ip_addresses_tb ip ON ip.id = pv.ip_address_id ORDER BY pv.id")
+	assert.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var ipAddress string
+		assert.NoError(t, rows.Scan(&ipAddress))
+		ipAddresses = append(ipAddresses, ipAddress)
+	}
+	return ipAddresses
+}
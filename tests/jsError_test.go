@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/jwtly10/simple-site-tracker/api/track"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewJSError(t *testing.T) {
+	jsError, err := NewJSError(ErrorKindError, " TypeError: cart is undefined ", "https://example.com/app.js?v=3", 42, 7, "at checkout (app.js:42:7)")
+	assert.NoError(t, err)
+	assert.Equal(t, "TypeError: cart is undefined", jsError.ErrorMessage)
+	assert.Equal(t, "https://example.com/app.js", jsError.ErrorSource)
+	assert.Len(t, jsError.Fingerprint, 32)
+
+	// Identical errors share a fingerprint, whatever their stack or cache busting query string
+	same, err := NewJSError(ErrorKindError, "TypeError: cart is undefined", "https://example.com/app.js?v=4", 42, 7, "checkout@app.js:42:7")
+	assert.NoError(t, err)
+	assert.Equal(t, jsError.Fingerprint, same.Fingerprint)
+
+	other, err := NewJSError(ErrorKindError, "TypeError: cart is undefined", "https://example.com/app.js", 43, 7, "")
+	assert.NoError(t, err)
+	assert.NotEqual(t, jsError.Fingerprint, other.Fingerprint)
+
+	// Long messages and stacks are trimmed rather than rejected, without splitting characters
+	long, err := NewJSError(ErrorKindRejection, strings.Repeat("é", MaxErrorMessageLength), "", 0, 0, strings.Repeat("a", MaxErrorStackLength+1))
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat("é", MaxErrorMessageLength/2), long.ErrorMessage)
+	assert.Len(t, long.ErrorStack, MaxErrorStackLength)
+
+	_, err = NewJSError("warning", "Deprecated", "", 0, 0, "")
+	assert.Error(t, err)
+	_, err = NewJSError(ErrorKindError, " ", "", 0, 0, "")
+	assert.Error(t, err)
+	_, err = NewJSError(ErrorKindError, "ReferenceError: x is not defined", "", -1, 0, "")
+	assert.Error(t, err)
+}
+
+func TestJSErrors(t *testing.T) {
+	forEachRepository(t, func(name string, repo RepositoryInterface, db *sql.DB, domainId int64, post postFunc) {
+		handlers := NewHandlers(repo)
+		const chrome = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+		const firefox = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:121.0) Gecko/20100101 Firefox/121.0"
+		const cartError = `"kind":"error","message":"TypeError: cart is undefined","source":"http://localhost:3000/app.js","line":42,"column":7`
+		for _, report := range []struct{ body, userAgent string }{
+			{`{"url":"http://localhost:3000/checkout",` + cartError + `,"stack":"at checkout (app.js:42:7)"}`, chrome},
+			{`{"url":"http://localhost:3000/checkout",` + cartError + `,"stack":"checkout@app.js:42:7"}`, firefox},
+			{`{"url":"http://localhost:3000/checkout",` + cartError + `}`, chrome},
+			{`{"url":"http://localhost:3000/cart",` + cartError + `}`, chrome},
+			{`{"url":"http://localhost:3000/","kind":"unhandledrejection","message":"Error: Failed to fetch"}`, firefox},
+		} {
+			assert.Equal(t, http.StatusOK, post(handlers.TrackErrorHandler, "/api/v1/track/error", report.body, withUserAgent(report.userAgent)), name)
+		}
+		assert.Equal(t, http.StatusBadRequest, post(handlers.TrackErrorHandler, "/api/v1/track/error", `{"url":"http://localhost:3000/","kind":"warning","message":"Deprecated"}`, withUserAgent(chrome)), name)
+
+		cart, err := NewJSError(ErrorKindError, "TypeError: cart is undefined", "http://localhost:3000/app.js", 42, 7, "")
+		assert.NoError(t, err, name)
+		fetch, err := NewJSError(ErrorKindRejection, "Error: Failed to fetch", "", 0, 0, "")
+		assert.NoError(t, err, name)
+
+		now := time.Now()
+		top, err := repo.GetTopJSErrors(context.Background(), int(domainId), now.Add(-time.Hour), now.Add(time.Hour))
+		assert.NoError(t, err, name)
+		assert.Equal(t, []JSErrorGroup{
+			{Fingerprint: cart.Fingerprint, Kind: ErrorKindError, Message: "TypeError: cart is undefined", Source: "http://localhost:3000/app.js", Line: 42, Column: 7, Count: 4, Pages: 2},
+			{Fingerprint: fetch.Fingerprint, Kind: ErrorKindRejection, Message: "Error: Failed to fetch", Count: 1, Pages: 1},
+		}, top, name)
+
+		counts, err := repo.GetJSErrorCounts(context.Background(), int(domainId), cart.Fingerprint, now.Add(-time.Hour), now.Add(time.Hour))
+		assert.NoError(t, err, name)
+		assert.Equal(t, []JSErrorCount{
+			{Page: "/checkout", Browser: "Chrome", Count: 2},
+			{Page: "/cart", Browser: "Chrome", Count: 1},
+			{Page: "/checkout", Browser: "Firefox", Count: 1},
+		}, counts, name)
+	})
+}
+
+func TestHandlers_BatchJSError(t *testing.T) {
+	repo := NewMemoryRepository()
+	_, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+
+	recorder, response := trackBatch(NewHandlers(repo), `{"events":[
+		{"type":"error","url":"http://localhost:3000/","kind":"error","message":"ReferenceError: x is not defined","source":"http://localhost:3000/app.js","line":3,"column":1},
+		{"type":"error","url":"http://localhost:3000/","kind":"error","message":""}
+	]}`)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, []string{BatchStatusSaved, BatchStatusInvalid}, batchStatuses(response))
+
+	jsErrors := repo.JSErrors()
+	assert.Len(t, jsErrors, 1)
+	assert.Equal(t, "ReferenceError: x is not defined", jsErrors[0].ErrorMessage)
+	assert.NotEmpty(t, jsErrors[0].Fingerprint)
+}
@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jwtly10/simple-site-tracker/api/middleware"
+	"github.com/jwtly10/simple-site-tracker/api/router"
+	"github.com/jwtly10/simple-site-tracker/api/service"
+	. "github.com/jwtly10/simple-site-tracker/api/track"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMemoryRouter(t *testing.T, repo *MemoryRepository) http.Handler {
+	t.Setenv("ALLOWED_ORIGINS", "http://localhost:3000")
+
+	mw := middleware.NewMiddleware(service.NewService(repo))
+	return router.NewRouter(NewHandlers(repo), mw)
+}
+
+func trackRequest(path, body string) *http.Request {
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Origin", "http://localhost:3000")
+	req.Header.Set("Referer", "http://localhost:3000/")
+	req.Header.Set("X-Site-Key", "key123")
+	return req
+}
+
+func TestMemoryRepository_EndToEnd(t *testing.T) {
+	repo := NewMemoryRepository()
+	_, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+
+	r := newMemoryRouter(t, repo)
+
+	requests := []*http.Request{
+		trackRequest("/api/v1/track/pageview", `{"url":"http://localhost:3000/about"}`),
+		trackRequest("/api/v1/track/utm", `{"utm_source":"newsletter","utm_medium":"email","utm_campaign":"launch","page_url":"http://localhost:3000/about"}`),
+		trackRequest("/api/v1/track/click", `{"element":{"tag":"a","href":"https://example.com"},"url":"http://localhost:3000/about"}`),
+	}
+
+	for _, req := range requests {
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, req)
+		assert.Equal(t, http.StatusOK, recorder.Code, req.URL.Path)
+	}
+
+	pageId, err := repo.GetPage(context.Background(), 1, "/about")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, pageId)
+
+	assert.Len(t, repo.PageViews(), 1)
+	assert.Equal(t, pageId, repo.PageViews()[0].PageID)
+	assert.Equal(t, "launch", repo.UTMs()[0].UTMCampaign)
+	assert.Equal(t, "https://example.com", repo.Clicks()[0].Element["href"])
+}
+
+func TestMemoryRepository_InvalidSiteKey(t *testing.T) {
+	repo := NewMemoryRepository()
+	_, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+
+	req := trackRequest("/api/v1/track/pageview", `{"url":"http://localhost:3000/about"}`)
+	req.Header.Set("X-Site-Key", "wrong")
+
+	recorder := httptest.NewRecorder()
+	newMemoryRouter(t, repo).ServeHTTP(recorder, req)
+
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.Empty(t, repo.PageViews())
+}
+
+func TestMemoryRepository_ConcurrentWrites(t *testing.T) {
+	repo := NewMemoryRepository()
+	domainId, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repo.SavePageView(context.Background(), int(domainId), 1, "", Visitor{}, Referrer{})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	ids := make(map[int64]bool)
+	for _, pv := range repo.PageViews() {
+		ids[pv.ID] = true
+	}
+	assert.Len(t, ids, 50)
+}
+
+func TestMemoryRepository_BeaconRequests(t *testing.T) {
+	repo := NewMemoryRepository()
+	_, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+
+	r := newMemoryRouter(t, repo)
+
+	// Beacons can't set headers, so the site key is in the body or query string, and JSON is sent as text/plain
+	for _, path := range []string{"/api/v1/track/batch", "/api/v1/track/batch?site_key=key123"} {
+		body := `{"site_key":"key123","events":[{"type":"click","url":"http://localhost:3000/about","element":{"tag":"a"}}]}`
+		if strings.Contains(path, "?") {
+			body = `{"events":[{"type":"click","url":"http://localhost:3000/about","element":{"tag":"a"}}]}`
+		}
+
+		req := trackRequest(path, body)
+		req.Header.Del("X-Site-Key")
+		req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
+
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, req)
+		assert.Equal(t, http.StatusOK, recorder.Code, path)
+	}
+	assert.Len(t, repo.Clicks(), 2)
+
+	// A wrong key in the body is still rejected
+	req := trackRequest("/api/v1/track/pageview", `{"site_key":"wrong","url":"http://localhost:3000/about"}`)
+	req.Header.Del("X-Site-Key")
+
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	// Bodies which aren't JSON or text are rejected
+	req = trackRequest("/api/v1/track/pageview", `url=http://localhost:3000/about`)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, recorder.Code)
+	assert.Empty(t, repo.PageViews())
+}
@@ -0,0 +1,76 @@
+package tests
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/jwtly10/simple-site-tracker/config"
+	"github.com/jwtly10/simple-site-tracker/migrations"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrator_UpStatusDown(t *testing.T) {
+	db, err := config.OpenDB(&config.Config{
+		DBDriver: config.DriverSQLite,
+		DBPath:   filepath.Join(t.TempDir(), "tracker.db"),
+	})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	migrator, err := migrations.NewMigrator(db, config.DriverSQLite)
+	assert.NoError(t, err)
+
+	pending, err := migrator.Pending()
+	assert.NoError(t, err)
+	assert.Len(t, pending, migrator.Latest())
+
+	applied, err := migrator.Up()
+	assert.NoError(t, err)
+	assert.Equal(t, pending, applied)
+
+	// Running up again is a no-op
+	applied, err = migrator.Up()
+	assert.NoError(t, err)
+	assert.Empty(t, applied)
+
+	statuses, err := migrator.Status()
+	assert.NoError(t, err)
+	for _, s := range statuses {
+		assert.True(t, s.Applied, s.Name)
+		assert.NotEmpty(t, s.AppliedAt, s.Name)
+	}
+
+	reverted, err := migrator.Down()
+	assert.NoError(t, err)
+	assert.Equal(t, migrator.Latest(), reverted.Version)
+
+	pending, err = migrator.Pending()
+	assert.NoError(t, err)
+	assert.Len(t, pending, 1)
+
+	// Revert everything, then the schema can be rebuilt from scratch
+	for reverted != nil {
+		reverted, err = migrator.Down()
+		assert.NoError(t, err)
+	}
+
+	var tables int
+	err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name LIKE '%_tb'").Scan(&tables)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, tables)
+
+	_, err = migrator.Up()
+	assert.NoError(t, err)
+}
+
+func TestMigrator_EveryDriverHasMigrations(t *testing.T) {
+	var latest []int
+	for _, driver := range []string{config.DriverMySQL, config.DriverSQLite, config.DriverPostgres} {
+		migrator, err := migrations.NewMigrator(nil, driver)
+		assert.NoError(t, err, driver)
+		latest = append(latest, migrator.Latest())
+	}
+
+	// Every driver must expect the same schema version
+	assert.Equal(t, []int{latest[0], latest[0], latest[0]}, latest)
+}
@@ -1,6 +1,9 @@
 package tests
 
 import (
+	"context"
+	"time"
+
 	. "github.com/jwtly10/simple-site-tracker/api/track"
 	"github.com/stretchr/testify/mock"
 )
@@ -9,52 +12,207 @@ type MockRepository struct {
 	mock.Mock
 }
 
-func (m *MockRepository) SavePageView(domainId, pageId int) (int64, error) {
+func (m *MockRepository) SavePageView(ctx context.Context, domainId, pageId int, viewID string, visitor Visitor, referrer Referrer) (int64, error) {
 	args := m.Called(domainId, pageId)
 	return int64(args.Int(0)), args.Error(1)
 }
 
-func (m *MockRepository) SaveDomain(domain, key string) (int64, error) {
+func (m *MockRepository) SaveDomain(ctx context.Context, domain, key string) (int64, error) {
 	args := m.Called(domain, key)
 	return int64(args.Int(0)), args.Error(1)
 }
 
-func (m *MockRepository) GetDomain(domain string) (int, error) {
+func (m *MockRepository) GetDomain(ctx context.Context, domain string) (int, error) {
 	args := m.Called(domain)
 	return args.Int(0), args.Error(1)
 }
 
-func (m *MockRepository) GetDomainIDFromKey(key string) (int, error) {
+func (m *MockRepository) GetDomainIDFromKey(ctx context.Context, key string) (int, error) {
 	args := m.Called(key)
 	return args.Int(0), args.Error(1)
 }
 
-func (m *MockRepository) GetDomainKeyPair(domain string) (DomainKeyPair, error) {
+func (m *MockRepository) GetDomainKeyPair(ctx context.Context, domain string) (DomainKeyPair, error) {
 	args := m.Called(domain)
 	return args.Get(0).(DomainKeyPair), args.Error(1)
 }
 
-func (m *MockRepository) GetPage(domainID int, pageURL string) (int, error) {
+func (m *MockRepository) GetPage(ctx context.Context, domainID int, pageURL string) (int, error) {
 	args := m.Called(domainID, pageURL)
 	return args.Int(0), args.Error(1)
 }
 
-func (m *MockRepository) CreatePage(domainID int, pageURL string) (int64, error) {
+func (m *MockRepository) CreatePage(ctx context.Context, domainID int, pageURL string) (int64, error) {
 	args := m.Called(domainID, pageURL)
 	return int64(args.Int(0)), args.Error(1)
 }
 
-func (m *MockRepository) SaveIPAddress(ipAddress string) (int64, error) {
+func (m *MockRepository) SaveIPAddress(ctx context.Context, ipAddress string) (int64, error) {
 	args := m.Called(ipAddress)
 	return int64(args.Int(0)), args.Error(1)
 }
 
-func (m *MockRepository) SaveUTM(pageID int, utmSource, utmMedium, utmCampaign, track string) (int64, error) {
+func (m *MockRepository) SaveUTM(ctx context.Context, pageID int, utmSource, utmMedium, utmCampaign, track string, visitor Visitor) (int64, error) {
 	args := m.Called(pageID, utmSource, utmMedium, utmCampaign, track)
 	return int64(args.Int(0)), args.Error(1)
 }
 
-func (m *MockRepository) SaveClick(pageID int, element map[string]interface{}) (int64, error) {
+func (m *MockRepository) SaveClick(ctx context.Context, pageID int, element map[string]interface{}, visitor Visitor, target ClickTarget) (int64, error) {
 	args := m.Called(pageID, element)
 	return int64(args.Int(0)), args.Error(1)
 }
+
+func (m *MockRepository) SavePageViews(ctx context.Context, pageViews []PageView) error {
+	args := m.Called(pageViews)
+	return args.Error(0)
+}
+
+func (m *MockRepository) SaveClicks(ctx context.Context, clicks []Click) error {
+	args := m.Called(clicks)
+	return args.Error(0)
+}
+
+func (m *MockRepository) SaveUTMs(ctx context.Context, utms []UTM) error {
+	args := m.Called(utms)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetRetentionPolicies(ctx context.Context) ([]RetentionPolicy, error) {
+	args := m.Called()
+	return args.Get(0).([]RetentionPolicy), args.Error(1)
+}
+
+func (m *MockRepository) SetRetentionPolicy(ctx context.Context, policy RetentionPolicy) error {
+	args := m.Called(policy)
+	return args.Error(0)
+}
+
+func (m *MockRepository) DeleteExpired(ctx context.Context, eventType EventType, domainID int, before time.Time, limit int) (int64, error) {
+	args := m.Called(eventType, domainID, before, limit)
+	return int64(args.Int(0)), args.Error(1)
+}
+
+func (m *MockRepository) GetOrCreatePage(ctx context.Context, domainID int, pageURL string) (int, error) {
+	args := m.Called(domainID, pageURL)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockRepository) RebuildRollups(ctx context.Context, from, to time.Time) error {
+	args := m.Called(from, to)
+	return args.Error(0)
+}
+
+func (m *MockRepository) SaveCustomEvent(ctx context.Context, domainID, pageID int, name string, props map[string]interface{}, visitor Visitor) (int64, error) {
+	args := m.Called(domainID, pageID, name, props)
+	return int64(args.Int(0)), args.Error(1)
+}
+
+func (m *MockRepository) SaveCustomEvents(ctx context.Context, events []CustomEvent) error {
+	args := m.Called(events)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetEventPropertyBreakdown(ctx context.Context, domainID int, name, property string, from, to time.Time) ([]EventPropertyCount, error) {
+	args := m.Called(domainID, name, property, from, to)
+	return args.Get(0).([]EventPropertyCount), args.Error(1)
+}
+
+func (m *MockRepository) GetOrCreateVisitorSalt(ctx context.Context, day, salt string) (string, error) {
+	args := m.Called(day, salt)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockRepository) GetVisitorCounts(ctx context.Context, domainID int, from, to time.Time) (VisitorCounts, error) {
+	args := m.Called(domainID, from, to)
+	return args.Get(0).(VisitorCounts), args.Error(1)
+}
+
+func (m *MockRepository) GetReferrerCounts(ctx context.Context, domainID int, from, to time.Time) ([]ReferrerCount, error) {
+	args := m.Called(domainID, from, to)
+	return args.Get(0).([]ReferrerCount), args.Error(1)
+}
+
+func (m *MockRepository) GetDimensionCounts(ctx context.Context, domainID int, dimension string, from, to time.Time) ([]DimensionCount, error) {
+	args := m.Called(domainID, dimension, from, to)
+	return args.Get(0).([]DimensionCount), args.Error(1)
+}
+
+func (m *MockRepository) GetBotMode(ctx context.Context, domainID int) (string, error) {
+	args := m.Called(domainID)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockRepository) SetBotMode(ctx context.Context, domainID int, mode string) error {
+	args := m.Called(domainID, mode)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetIPPolicy(ctx context.Context, domainID int) (IPPolicy, error) {
+	args := m.Called(domainID)
+	return args.Get(0).(IPPolicy), args.Error(1)
+}
+
+func (m *MockRepository) SetIPMode(ctx context.Context, domainID int, mode string) error {
+	args := m.Called(domainID, mode)
+	return args.Error(0)
+}
+
+func (m *MockRepository) AddEngagements(ctx context.Context, engagements []Engagement) error {
+	args := m.Called(engagements)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetPageEngagement(ctx context.Context, domainID int, from, to time.Time) ([]PageEngagement, error) {
+	args := m.Called(domainID, from, to)
+	return args.Get(0).([]PageEngagement), args.Error(1)
+}
+
+func (m *MockRepository) SaveScrollDepths(ctx context.Context, scrolls []ScrollDepth) error {
+	args := m.Called(scrolls)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetScrollDepths(ctx context.Context, domainID int, from, to time.Time) ([]PageScrollDepth, error) {
+	args := m.Called(domainID, from, to)
+	return args.Get(0).([]PageScrollDepth), args.Error(1)
+}
+
+func (m *MockRepository) GetClickTargets(ctx context.Context, domainID int, class string, from, to time.Time) ([]ClickTargetCount, error) {
+	args := m.Called(domainID, class, from, to)
+	return args.Get(0).([]ClickTargetCount), args.Error(1)
+}
+
+func (m *MockRepository) SaveFormSubmission(ctx context.Context, domainID, pageID int, form Form, visitor Visitor) (int64, error) {
+	args := m.Called(domainID, pageID, form)
+	return int64(args.Int(0)), args.Error(1)
+}
+
+func (m *MockRepository) SaveFormSubmissions(ctx context.Context, submissions []FormSubmission) error {
+	args := m.Called(submissions)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetFormSubmissionCounts(ctx context.Context, domainID int, from, to time.Time) ([]FormSubmissionCount, error) {
+	args := m.Called(domainID, from, to)
+	return args.Get(0).([]FormSubmissionCount), args.Error(1)
+}
+
+func (m *MockRepository) SaveJSError(ctx context.Context, domainID, pageID int, jsError JSError, visitor Visitor) (int64, error) {
+	args := m.Called(domainID, pageID, jsError)
+	return int64(args.Int(0)), args.Error(1)
+}
+
+func (m *MockRepository) SaveJSErrors(ctx context.Context, events []JSErrorEvent) error {
+	args := m.Called(events)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetTopJSErrors(ctx context.Context, domainID int, from, to time.Time) ([]JSErrorGroup, error) {
+	args := m.Called(domainID, from, to)
+	return args.Get(0).([]JSErrorGroup), args.Error(1)
+}
+
+func (m *MockRepository) GetJSErrorCounts(ctx context.Context, domainID int, fingerprint string, from, to time.Time) ([]JSErrorCount, error) {
+	args := m.Called(domainID, fingerprint, from, to)
+	return args.Get(0).([]JSErrorCount), args.Error(1)
+}
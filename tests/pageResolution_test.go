@@ -0,0 +1,169 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+
+	. "github.com/jwtly10/simple-site-tracker/api/track"
+	"github.com/jwtly10/simple-site-tracker/config"
+	"github.com/jwtly10/simple-site-tracker/migrations"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetOrCreatePage_ConcurrentFirstHits(t *testing.T) {
+	forEachRepository(t, func(name string, repo RepositoryInterface, db *sql.DB, domainId int64, post postFunc) {
+		ids := make([]int, 20)
+		var wg sync.WaitGroup
+		for i := range ids {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				id, err := repo.GetOrCreatePage(context.Background(), int(domainId), "/new")
+				assert.NoError(t, err, name)
+				ids[i] = id
+			}(i)
+		}
+		wg.Wait()
+
+		for _, id := range ids {
+			assert.Equal(t, ids[0], id, name)
+		}
+
+		if db == nil {
+			return
+		}
+		var pages int
+		assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM pages_tb WHERE page_url = '/new'").Scan(&pages))
+		assert.Equal(t, 1, pages)
+	})
+}
+
+func TestMigrations_MergeDuplicatePages(t *testing.T) {
+	repo, db := newSQLiteRepository(t)
+
+	// Recreate the duplicates the unique index now prevents
+	migrator, err := migrations.NewMigrator(db, config.DriverSQLite)
+	assert.NoError(t, err)
+	for version := migrator.Latest(); version >= 5; version-- {
+		reverted, err := migrator.Down()
+		assert.NoError(t, err)
+		assert.Equal(t, version, reverted.Version)
+	}
+
+	domainId, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+	var pageIds []int
+	for i := 0; i < 3; i++ {
+		id, err := repo.CreatePage(context.Background(), int(domainId), "/about")
+		assert.NoError(t, err)
+		pageIds = append(pageIds, int(id))
+	}
+
+	// Rows are inserted directly, as the repository writes columns added by later migrations
+	for _, pageId := range pageIds {
+		_, err = db.Exec("INSERT INTO page_views_tb (domain_id, page_id) VALUES (?, ?)", domainId, pageId)
+		assert.NoError(t, err)
+		_, err = db.Exec("INSERT INTO page_views_daily_tb (domain_id, page_id, day, views) VALUES (?, ?, '2024-01-02', 1)", domainId, pageId)
+		assert.NoError(t, err)
+		_, err = db.Exec("INSERT INTO clicks_tb (page_id, element) VALUES (?, '{}')", pageId)
+		assert.NoError(t, err)
+	}
+
+	_, err = migrator.Up()
+	assert.NoError(t, err)
+
+	var pages, viewPages, clickPages, views int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM pages_tb").Scan(&pages))
+	assert.NoError(t, db.QueryRow("SELECT COUNT(DISTINCT page_id) FROM page_views_tb").Scan(&viewPages))
+	assert.NoError(t, db.QueryRow("SELECT COUNT(DISTINCT page_id) FROM clicks_tb").Scan(&clickPages))
+	assert.NoError(t, db.QueryRow("SELECT views FROM page_views_daily_tb WHERE page_id = ?", pageIds[0]).Scan(&views))
+	assert.Equal(t, 1, pages)
+	assert.Equal(t, 1, viewPages)
+	assert.Equal(t, 1, clickPages)
+	assert.Equal(t, 3, views)
+
+	id, err := repo.GetOrCreatePage(context.Background(), int(domainId), "/about")
+	assert.NoError(t, err)
+	assert.Equal(t, pageIds[0], id)
+	_, err = repo.CreatePage(context.Background(), int(domainId), "/about")
+	assert.Error(t, err)
+}
+
+func TestCachedRepository_CachesLookups(t *testing.T) {
+	mockRepo := &MockRepository{}
+	mockRepo.On("GetDomain", "localhost").Return(1, nil)
+	mockRepo.On("GetDomain", "unknown").Return(0, nil)
+	mockRepo.On("GetOrCreatePage", 1, "/a").Return(2, nil)
+	mockRepo.On("GetOrCreatePage", 1, "/b").Return(3, nil)
+
+	repo := NewCachedRepository(mockRepo, 1)
+	for i := 0; i < 3; i++ {
+		id, err := repo.GetDomain(context.Background(), "localhost")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, id)
+
+		id, err = repo.GetOrCreatePage(context.Background(), 1, "/a")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, id)
+
+		// Unknown domains are not cached, so they are found once registered
+		id, err = repo.GetDomain(context.Background(), "unknown")
+		assert.NoError(t, err)
+		assert.Equal(t, 0, id)
+	}
+	mockRepo.AssertNumberOfCalls(t, "GetDomain", 4)
+	mockRepo.AssertNumberOfCalls(t, "GetOrCreatePage", 1)
+
+	// With room for one page, /b evicts /a
+	_, err := repo.GetOrCreatePage(context.Background(), 1, "/b")
+	assert.NoError(t, err)
+	_, err = repo.GetOrCreatePage(context.Background(), 1, "/a")
+	assert.NoError(t, err)
+	mockRepo.AssertNumberOfCalls(t, "GetOrCreatePage", 3)
+
+	stats := repo.Stats()
+	assert.Equal(t, CacheStats{Domains: 1, Pages: 1, Hits: 4, Misses: 7}, stats)
+}
+
+func TestCachedRepository_CachesSettings(t *testing.T) {
+	mockRepo := &MockRepository{}
+	mockRepo.On("GetBotMode", 1).Return(BotModeDrop, nil)
+	mockRepo.On("SetBotMode", 1, BotModeFlag).Return(nil)
+	mockRepo.On("GetIPPolicy", 1).Return(IPPolicy{Mode: IPModeTruncated}, nil)
+	mockRepo.On("SaveIPAddress", "203.0.113.0").Return(4, nil)
+
+	repo := NewCachedRepository(mockRepo, 10)
+	for i := 0; i < 3; i++ {
+		mode, err := repo.GetBotMode(context.Background(), 1)
+		assert.NoError(t, err)
+		assert.Equal(t, BotModeDrop, mode)
+
+		policy, err := repo.GetIPPolicy(context.Background(), 1)
+		assert.NoError(t, err)
+		assert.Equal(t, IPModeTruncated, policy.Mode)
+
+		id, err := repo.SaveIPAddress(context.Background(), "203.0.113.0")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(4), id)
+	}
+	mockRepo.AssertNumberOfCalls(t, "GetBotMode", 1)
+	mockRepo.AssertNumberOfCalls(t, "GetIPPolicy", 1)
+	mockRepo.AssertNumberOfCalls(t, "SaveIPAddress", 1)
+
+	// Changing a setting drops it from the cache
+	assert.NoError(t, repo.SetBotMode(context.Background(), 1, BotModeFlag))
+	_, err := repo.GetBotMode(context.Background(), 1)
+	assert.NoError(t, err)
+	mockRepo.AssertNumberOfCalls(t, "GetBotMode", 2)
+
+	// Settings changed by another process are picked up once they expire
+	repo = NewCachedRepository(mockRepo, 10)
+	repo.SetSettingsTTL(0)
+	_, err = repo.GetIPPolicy(context.Background(), 1)
+	assert.NoError(t, err)
+	_, err = repo.GetIPPolicy(context.Background(), 1)
+	assert.NoError(t, err)
+	mockRepo.AssertNumberOfCalls(t, "GetIPPolicy", 3)
+}
@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/jwtly10/simple-site-tracker/api/track"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReferrerRules_Classify(t *testing.T) {
+	rules := DefaultReferrerRules()
+
+	for referrer, expected := range map[string]Referrer{
+		"":                                       {ReferrerChannel: ChannelDirect},
+		"not a url":                              {ReferrerChannel: ChannelDirect},
+		"https://example.com/blog?ref=nav":       {ReferrerHost: "example.com", ReferrerPath: "/blog", ReferrerChannel: ChannelInternal},
+		"https://www.example.com":                {ReferrerHost: "www.example.com", ReferrerPath: "/", ReferrerChannel: ChannelInternal},
+		"https://docs.example.com/start":         {ReferrerHost: "docs.example.com", ReferrerPath: "/start", ReferrerChannel: ChannelInternal},
+		"https://www.google.co.uk/search?q=test": {ReferrerHost: "www.google.co.uk", ReferrerPath: "/search", ReferrerChannel: ChannelSearch},
+		"https://DuckDuckGo.com/":                {ReferrerHost: "duckduckgo.com", ReferrerPath: "/", ReferrerChannel: ChannelSearch},
+		"https://mail.google.com/mail/u/0/":      {ReferrerHost: "mail.google.com", ReferrerPath: "/mail/u/0/", ReferrerChannel: ChannelEmail},
+		"android-app://com.google.android.gm/":   {ReferrerHost: "com.google.android.gm", ReferrerPath: "/", ReferrerChannel: ChannelEmail},
+		"https://t.co/abc123":                    {ReferrerHost: "t.co", ReferrerPath: "/abc123", ReferrerChannel: ChannelSocial},
+		"https://old.reddit.com/r/golang/":       {ReferrerHost: "old.reddit.com", ReferrerPath: "/r/golang/", ReferrerChannel: ChannelSocial},
+		"https://blog.golang.org/post":           {ReferrerHost: "blog.golang.org", ReferrerPath: "/post", ReferrerChannel: ChannelReferral},
+		"https://notexample.com/":                {ReferrerHost: "notexample.com", ReferrerPath: "/", ReferrerChannel: ChannelReferral},
+	} {
+		assert.Equal(t, expected, rules.Classify(referrer, "example.com"), referrer)
+	}
+}
+
+func TestLoadReferrerRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "referrers.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"social": ["community.example.org"], "search": ["search.*"]}`), 0644))
+
+	rules, err := LoadReferrerRules(path)
+	assert.NoError(t, err)
+	assert.Equal(t, ChannelSocial, rules.Classify("https://community.example.org/t/1", "localhost").ReferrerChannel)
+	assert.Equal(t, ChannelSearch, rules.Classify("https://search.example.net/", "localhost").ReferrerChannel)
+	assert.Equal(t, ChannelReferral, rules.Classify("https://www.google.com/", "localhost").ReferrerChannel)
+
+	for name, data := range map[string]string{
+		"invalid json":    `["google.com"]`,
+		"unknown channel": `{"ads": ["doubleclick.net"]}`,
+		"empty host":      `{"search": [""]}`,
+		"duplicate host":  `{"search": ["example.com"], "social": ["Example.com"]}`,
+	} {
+		_, err := ParseReferrerRules([]byte(data))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestReferrerCounts(t *testing.T) {
+	forEachRepository(t, func(name string, repo RepositoryInterface, db *sql.DB, domainId int64, post postFunc) {
+		recorder, _ := trackBatch(NewHandlers(repo), `{"events":[
+			{"type":"pageview","url":"http://localhost:3000/","referrer":"https://www.google.com/search?q=tracker"},
+			{"type":"pageview","url":"http://localhost:3000/","referrer":"https://www.google.com/"},
+			{"type":"pageview","url":"http://localhost:3000/about","referrer":"http://localhost:3000/"},
+			{"type":"pageview","url":"http://localhost:3000/","referrer":"https://news.ycombinator.com/item?id=1"},
+			{"type":"pageview","url":"http://localhost:3000/"}
+		]}`)
+		assert.Equal(t, http.StatusOK, recorder.Code, name)
+
+		now := time.Now()
+		counts, err := repo.GetReferrerCounts(context.Background(), int(domainId), now.Add(-time.Hour), now.Add(time.Hour))
+		assert.NoError(t, err, name)
+		assert.Equal(t, []ReferrerCount{
+			{Channel: ChannelSearch, Host: "www.google.com", Count: 2},
+			{Channel: ChannelDirect, Host: "", Count: 1},
+			{Channel: ChannelInternal, Host: "localhost", Count: 1},
+			{Channel: ChannelSocial, Host: "news.ycombinator.com", Count: 1},
+		}, counts, name)
+	})
+}
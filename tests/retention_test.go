@@ -0,0 +1,98 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/jwtly10/simple-site-tracker/api/track"
+	"github.com/stretchr/testify/assert"
+)
+
+// seedRetentionEvents saves a page view, click, UTM, custom event, form submission and JavaScript error from 100 days ago and from now.
+func seedRetentionEvents(t *testing.T, repo RepositoryInterface) RetentionPolicy {
+	domainId, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+	pageId, err := repo.CreatePage(context.Background(), int(domainId), "/")
+	assert.NoError(t, err)
+
+	for _, createdAt := range []time.Time{time.Now().AddDate(0, 0, -100), time.Now()} {
+		assert.NoError(t, repo.SavePageViews(context.Background(), []PageView{
+			{DomainID: int(domainId), PageID: int(pageId), CreatedAt: createdAt},
+			{DomainID: int(domainId), PageID: int(pageId), CreatedAt: createdAt},
+			{DomainID: int(domainId), PageID: int(pageId), CreatedAt: createdAt},
+		}))
+		assert.NoError(t, repo.SaveClicks(context.Background(), []Click{{PageID: int(pageId), Element: map[string]interface{}{}, CreatedAt: createdAt}}))
+		assert.NoError(t, repo.SaveUTMs(context.Background(), []UTM{{DomainID: int(domainId), PageID: int(pageId), UTMSource: "a", CreatedAt: createdAt}}))
+		assert.NoError(t, repo.SaveCustomEvents(context.Background(), []CustomEvent{{DomainID: int(domainId), PageID: int(pageId), Name: "signup", CreatedAt: createdAt}}))
+		assert.NoError(t, repo.SaveFormSubmissions(context.Background(), []FormSubmission{{DomainID: int(domainId), PageID: int(pageId), CreatedAt: createdAt, Form: Form{FormID: "signup"}}}))
+		assert.NoError(t, repo.SaveJSErrors(context.Background(), []JSErrorEvent{{DomainID: int(domainId), PageID: int(pageId), CreatedAt: createdAt,
+			JSError: JSError{ErrorKind: ErrorKindError, ErrorMessage: "TypeError: cart is undefined", Fingerprint: "cart"}}}))
+	}
+
+	return RetentionPolicy{DomainID: int(domainId), PageViewsDays: 90, ClicksDays: 30, EventsDays: 30, FormsDays: 90, JSErrorsDays: 7}
+}
+
+func TestRetentionJob_SQLite(t *testing.T) {
+	repo, db := newSQLiteRepository(t)
+	policy := seedRetentionEvents(t, repo)
+	assert.NoError(t, repo.SetRetentionPolicy(context.Background(), policy))
+
+	policies, err := repo.GetRetentionPolicies(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []RetentionPolicy{{DomainID: policy.DomainID, Domain: "localhost", PageViewsDays: 90, ClicksDays: 30, EventsDays: 30, FormsDays: 90, JSErrorsDays: 7}}, policies)
+
+	// A batch size smaller than the number of expired rows prunes in several batches
+	job := NewRetentionJob(repo, RetentionConfig{BatchSize: 2})
+	pruned, err := job.RunOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), pruned)
+
+	counts := map[string]int{}
+	for _, table := range []string{"page_views_tb", "clicks_tb", "utm_tb", "events_tb", "form_submissions_tb", "js_errors_tb"} {
+		var n int
+		assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM "+table).Scan(&n))
+		counts[table] = n
+	}
+
+	// UTMs have no retention, so are kept forever
+	assert.Equal(t, map[string]int{"page_views_tb": 3, "clicks_tb": 1, "utm_tb": 2, "events_tb": 1, "form_submissions_tb": 1, "js_errors_tb": 1}, counts)
+}
+
+func TestRetentionJob_Memory(t *testing.T) {
+	repo := NewMemoryRepository()
+	policy := seedRetentionEvents(t, repo)
+	policy.UTMsDays = 30
+	assert.NoError(t, repo.SetRetentionPolicy(context.Background(), policy))
+
+	job := NewRetentionJob(repo, RetentionConfig{BatchSize: 2})
+	pruned, err := job.RunOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8), pruned)
+
+	assert.Len(t, repo.PageViews(), 3)
+	assert.Len(t, repo.Clicks(), 1)
+	assert.Len(t, repo.UTMs(), 1)
+	assert.Len(t, repo.CustomEvents(), 1)
+	assert.Len(t, repo.FormSubmissions(), 1)
+	assert.Len(t, repo.JSErrors(), 1)
+
+	// New events never reuse the IDs of pruned ones
+	_, err = repo.SavePageView(context.Background(), policy.DomainID, 1, "", Visitor{}, Referrer{})
+	assert.NoError(t, err)
+	ids := map[int64]bool{}
+	for _, pv := range repo.PageViews() {
+		ids[pv.ID] = true
+	}
+	assert.Len(t, ids, 4)
+}
+
+func TestRetentionJob_NoPolicyKeepsEverything(t *testing.T) {
+	repo := NewMemoryRepository()
+	seedRetentionEvents(t, repo)
+
+	pruned, err := NewRetentionJob(repo, RetentionConfig{}).RunOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), pruned)
+	assert.Len(t, repo.PageViews(), 6)
+}
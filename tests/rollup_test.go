@@ -0,0 +1,139 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/jwtly10/simple-site-tracker/api/track"
+	"github.com/stretchr/testify/assert"
+)
+
+// seedRollupEvents saves page views across two hours of one day and one hour of the next, and UTM hits on both days.
+func seedRollupEvents(t *testing.T, repo RepositoryInterface) (int, int) {
+	domainId, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+	pageId, err := repo.CreatePage(context.Background(), int(domainId), "/")
+	assert.NoError(t, err)
+
+	day1 := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	day2 := time.Date(2024, 1, 3, 0, 30, 0, 0, time.UTC)
+	pv := func(createdAt time.Time) PageView {
+		return PageView{DomainID: int(domainId), PageID: int(pageId), CreatedAt: createdAt}
+	}
+	utm := func(campaign string, createdAt time.Time) UTM {
+		return UTM{DomainID: int(domainId), PageID: int(pageId), UTMCampaign: campaign, UTMSource: "news", CreatedAt: createdAt}
+	}
+
+	// Split across batches, so later batches add onto existing rollup rows
+	assert.NoError(t, repo.SavePageViews(context.Background(), []PageView{pv(day1), pv(day1.Add(time.Minute))}))
+	assert.NoError(t, repo.SavePageViews(context.Background(), []PageView{pv(day1.Add(time.Hour)), pv(day1), pv(day2)}))
+	assert.NoError(t, repo.SaveUTMs(context.Background(), []UTM{utm("launch", day1), utm("launch", day1), utm("", day1)}))
+	assert.NoError(t, repo.SaveUTMs(context.Background(), []UTM{utm("launch", day1), utm("launch", day2)}))
+
+	return int(domainId), int(pageId)
+}
+
+func TestRollups_SQLite(t *testing.T) {
+	repo, db := newSQLiteRepository(t)
+	seedRollupEvents(t, repo)
+
+	assertRollups := func() {
+		hourly := map[string]int{}
+		rows, err := db.Query("SELECT hour, views FROM page_views_hourly_tb")
+		assert.NoError(t, err)
+		for rows.Next() {
+			var hour string
+			var views int
+			assert.NoError(t, rows.Scan(&hour, &views))
+			hourly[hour] = views
+		}
+		assert.NoError(t, rows.Close())
+		assert.Equal(t, map[string]int{"2024-01-02 03:00:00": 3, "2024-01-02 04:00:00": 1, "2024-01-03 00:00:00": 1}, hourly)
+
+		daily := map[string]int{}
+		rows, err = db.Query("SELECT day, views FROM page_views_daily_tb")
+		assert.NoError(t, err)
+		for rows.Next() {
+			var day string
+			var views int
+			assert.NoError(t, rows.Scan(&day, &views))
+			daily[day] = views
+		}
+		assert.NoError(t, rows.Close())
+		assert.Equal(t, map[string]int{"2024-01-02": 4, "2024-01-03": 1}, daily)
+
+		utms := map[string]int{}
+		rows, err = db.Query("SELECT day, utm_campaign, hits FROM utm_daily_tb WHERE utm_source = 'news'")
+		assert.NoError(t, err)
+		for rows.Next() {
+			var day, campaign string
+			var hits int
+			assert.NoError(t, rows.Scan(&day, &campaign, &hits))
+			utms[day+" "+campaign] = hits
+		}
+		assert.NoError(t, rows.Close())
+		assert.Equal(t, map[string]int{"2024-01-02 launch": 3, "2024-01-02 ": 1, "2024-01-03 launch": 1}, utms)
+	}
+	assertRollups()
+
+	// Corrupt the first day's rollups, then a backfill of that day restores them without touching the second
+	for _, stmt := range []string{
+		"UPDATE page_views_hourly_tb SET views = 100 WHERE hour < '2024-01-03 00:00:00'",
+		"UPDATE page_views_daily_tb SET views = 100 WHERE day = '2024-01-02'",
+		"DELETE FROM utm_daily_tb WHERE day = '2024-01-02'",
+	} {
+		_, err := db.Exec(stmt)
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, repo.RebuildRollups(context.Background(), time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)))
+	assertRollups()
+}
+
+func TestRollups_SQLiteSingleSaves(t *testing.T) {
+	repo, db := newSQLiteRepository(t)
+
+	domainId, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+	pageId, err := repo.CreatePage(context.Background(), int(domainId), "/")
+	assert.NoError(t, err)
+
+	_, err = repo.SavePageView(context.Background(), int(domainId), int(pageId), "", Visitor{}, Referrer{})
+	assert.NoError(t, err)
+	_, err = repo.SaveUTM(context.Background(), int(pageId), "news", "email", "launch", "", Visitor{})
+	assert.NoError(t, err)
+
+	var views, hits int
+	assert.NoError(t, db.QueryRow("SELECT SUM(views) FROM page_views_daily_tb").Scan(&views))
+	assert.NoError(t, db.QueryRow("SELECT SUM(hits) FROM utm_daily_tb WHERE domain_id = ?", domainId).Scan(&hits))
+	assert.Equal(t, 1, views)
+	assert.Equal(t, 1, hits)
+}
+
+func TestRollups_Memory(t *testing.T) {
+	repo := NewMemoryRepository()
+	domainId, pageId := seedRollupEvents(t, repo)
+
+	expectedHourly := []PageViewRollup{
+		{DomainID: domainId, PageID: pageId, Bucket: "2024-01-02 03:00:00", Views: 3},
+		{DomainID: domainId, PageID: pageId, Bucket: "2024-01-02 04:00:00", Views: 1},
+		{DomainID: domainId, PageID: pageId, Bucket: "2024-01-03 00:00:00", Views: 1},
+	}
+	expectedUTMs := []UTMRollup{
+		{DomainID: domainId, Day: "2024-01-02", UTMSource: "news", Hits: 1},
+		{DomainID: domainId, Day: "2024-01-02", UTMCampaign: "launch", UTMSource: "news", Hits: 3},
+		{DomainID: domainId, Day: "2024-01-03", UTMCampaign: "launch", UTMSource: "news", Hits: 1},
+	}
+	assert.Equal(t, expectedHourly, repo.HourlyPageViews())
+	assert.Equal(t, expectedUTMs, repo.DailyUTMs())
+
+	// Rebuilding from the raw events gives the same counts
+	assert.NoError(t, repo.RebuildRollups(context.Background(), time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, expectedHourly, repo.HourlyPageViews())
+	assert.Equal(t, expectedUTMs, repo.DailyUTMs())
+	assert.Equal(t, []PageViewRollup{
+		{DomainID: domainId, PageID: pageId, Bucket: "2024-01-02", Views: 4},
+		{DomainID: domainId, PageID: pageId, Bucket: "2024-01-03", Views: 1},
+	}, repo.DailyPageViews())
+}
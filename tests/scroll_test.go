@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/jwtly10/simple-site-tracker/api/track"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateScrollDepth(t *testing.T) {
+	for _, depth := range ScrollDepths {
+		assert.NoError(t, ValidateScrollDepth("view-0001", depth))
+	}
+
+	assert.Error(t, ValidateScrollDepth("view-0001", 0))
+	assert.Error(t, ValidateScrollDepth("view-0001", 30))
+	assert.Error(t, ValidateScrollDepth("view-0001", 125))
+	assert.Error(t, ValidateScrollDepth("", 50))
+	assert.Error(t, ValidateScrollDepth("bad id", 50))
+}
+
+func TestScrollDepths(t *testing.T) {
+	forEachRepository(t, func(name string, repo RepositoryInterface, db *sql.DB, domainId int64, post postFunc) {
+		handlers := NewHandlers(repo)
+		for _, pageView := range []string{
+			`{"url":"http://localhost:3000/guide","view_id":"view-0001"}`,
+			`{"url":"http://localhost:3000/guide","view_id":"view-0002"}`,
+			`{"url":"http://localhost:3000/guide","view_id":"view-0003"}`,
+			`{"url":"http://localhost:3000/about","view_id":"view-0004"}`,
+			// Page views without a view ID don't report scroll depth, so aren't counted
+			`{"url":"http://localhost:3000/about"}`,
+		} {
+			assert.Equal(t, http.StatusOK, post(handlers.TrackPageViewHandler, "/api/v1/track/pageview", pageView), name)
+		}
+
+		for _, scroll := range []string{
+			`{"url":"http://localhost:3000/guide","view_id":"view-0001","scroll_depth":25}`,
+			`{"url":"http://localhost:3000/guide","view_id":"view-0001","scroll_depth":75}`,
+			// Only the deepest bucket is kept, even if reports arrive out of order
+			`{"url":"http://localhost:3000/guide","view_id":"view-0001","scroll_depth":50}`,
+			`{"url":"http://localhost:3000/guide","view_id":"view-0002","scroll_depth":100}`,
+			`{"url":"http://localhost:3000/about","view_id":"view-0004","scroll_depth":100}`,
+			// Unknown page views are ignored
+			`{"url":"http://localhost:3000/guide","view_id":"view-9999","scroll_depth":100}`,
+		} {
+			assert.Equal(t, http.StatusOK, post(handlers.TrackScrollHandler, "/api/v1/track/scroll", scroll), name)
+		}
+		assert.Equal(t, http.StatusBadRequest, post(handlers.TrackScrollHandler, "/api/v1/track/scroll", `{"url":"http://localhost:3000/guide","view_id":"view-0001","scroll_depth":60}`), name)
+
+		now := time.Now()
+		depths, err := repo.GetScrollDepths(context.Background(), int(domainId), now.Add(-time.Hour), now.Add(time.Hour))
+		assert.NoError(t, err, name)
+		assert.Equal(t, []PageScrollDepth{
+			{Page: "/guide", Views: 3, Depths: map[int]int64{0: 1, 25: 0, 50: 0, 75: 1, 100: 1}},
+			{Page: "/about", Views: 1, Depths: map[int]int64{0: 0, 25: 0, 50: 0, 75: 0, 100: 1}},
+		}, depths, name)
+	})
+}
+
+func TestHandlers_BatchScroll(t *testing.T) {
+	repo := NewMemoryRepository()
+	_, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+
+	// A page which fits in the window reports its scroll depth with its page view
+	recorder, response := trackBatch(NewHandlers(repo), `{"events":[
+		{"type":"pageview","url":"http://localhost:3000/about","view_id":"view-0001"},
+		{"type":"scroll","url":"http://localhost:3000/about","view_id":"view-0001","scroll_depth":100},
+		{"type":"scroll","url":"http://localhost:3000/about","view_id":"view-0001","scroll_depth":10}
+	]}`)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, []string{BatchStatusSaved, BatchStatusSaved, BatchStatusInvalid}, batchStatuses(response))
+
+	assert.Len(t, repo.PageViews(), 1)
+	assert.Equal(t, 100, repo.PageViews()[0].ScrollDepth)
+}
@@ -0,0 +1,311 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/jwtly10/simple-site-tracker/api/track"
+	"github.com/jwtly10/simple-site-tracker/spool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func spoolEvents(n int) []Event {
+	events := make([]Event, n)
+	for i := range events {
+		events[i] = Event{Type: EventPageView, Domain: "localhost", Page: fmt.Sprintf("/%d", i), CreatedAt: time.Now().UTC()}
+	}
+	return events
+}
+
+func TestSpool_ReplayInOrder(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := spool.Open(spool.Config{Dir: dir, SegmentBytes: 200})
+	assert.NoError(t, err)
+
+	events := spoolEvents(10)
+	for _, event := range events {
+		assert.NoError(t, sp.Spool([]Event{event}))
+	}
+	assert.NoError(t, sp.Close())
+
+	// Segments survive a restart
+	sp, err = spool.Open(spool.Config{Dir: dir})
+	assert.NoError(t, err)
+
+	segments, err := sp.Inspect()
+	assert.NoError(t, err)
+	assert.Greater(t, len(segments), 1)
+
+	var replayed []Event
+	n, err := sp.Replay(func(batch []Event) ([]int, []int) {
+		replayed = append(replayed, batch...)
+		return nil, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 10, n)
+	assert.Equal(t, events, replayed)
+
+	segments, err = sp.Inspect()
+	assert.NoError(t, err)
+	assert.Empty(t, segments)
+	assert.Equal(t, int64(0), sp.Size())
+}
+
+func TestSpool_ReplayStallsAndResumes(t *testing.T) {
+	sp, err := spool.Open(spool.Config{Dir: t.TempDir(), ReplayBatchSize: 2})
+	assert.NoError(t, err)
+	assert.NoError(t, sp.Spool(spoolEvents(5)))
+
+	// The first batch is saved, then the database goes away
+	var saved []Event
+	calls := 0
+	n, err := sp.Replay(func(batch []Event) ([]int, []int) {
+		calls++
+		if calls > 1 {
+			return []int{0, 1}, nil
+		}
+		saved = append(saved, batch...)
+		return nil, nil
+	})
+	assert.ErrorIs(t, err, spool.ErrReplayStall)
+	assert.Equal(t, 2, n)
+
+	// Replay resumes after the checkpoint, so no event is saved twice
+	n, err = sp.Replay(func(batch []Event) ([]int, []int) {
+		saved = append(saved, batch...)
+		return nil, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Len(t, saved, 5)
+}
+
+func TestSpool_PartialReplayIsRespooled(t *testing.T) {
+	sp, err := spool.Open(spool.Config{Dir: t.TempDir()})
+	assert.NoError(t, err)
+	events := spoolEvents(3)
+	assert.NoError(t, sp.Spool(events))
+
+	n, err := sp.Replay(func(batch []Event) ([]int, []int) { return []int{2}, nil })
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	var remaining []Event
+	_, err = sp.Replay(func(batch []Event) ([]int, []int) {
+		remaining = append(remaining, batch...)
+		return nil, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, events[2:], remaining)
+}
+
+func TestSpool_RejectedEventsAreDeadLettered(t *testing.T) {
+	sp, err := spool.Open(spool.Config{Dir: t.TempDir(), ReplayBatchSize: 2})
+	assert.NoError(t, err)
+	events := spoolEvents(5)
+	assert.NoError(t, sp.Spool(events))
+
+	// A batch the database rejects entirely doesn't stall the events behind it
+	var saved []Event
+	n, err := sp.Replay(func(batch []Event) ([]int, []int) {
+		if batch[0].Page == "/0" {
+			return nil, []int{0, 1}
+		}
+		saved = append(saved, batch...)
+		return nil, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, events[2:], saved)
+
+	dead, err := sp.DeadLetters()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, dead)
+	assert.Equal(t, int64(0), sp.Size())
+}
+
+func TestSpool_RetriedEventsAreDeadLetteredAfterMaxAttempts(t *testing.T) {
+	sp, err := spool.Open(spool.Config{Dir: t.TempDir(), MaxAttempts: 3})
+	assert.NoError(t, err)
+
+	// The first event keeps failing while the events spooled alongside it are saved
+	failing := spoolEvents(1)
+	assert.NoError(t, sp.Spool(failing))
+	attempts := 0
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, sp.Spool([]Event{{Type: EventPageView, Domain: "localhost", Page: "/saved", CreatedAt: time.Now().UTC()}}))
+		_, err := sp.Replay(func(batch []Event) ([]int, []int) {
+			for i, event := range batch {
+				if event.Page == failing[0].Page {
+					attempts++
+					return []int{i}, nil
+				}
+			}
+			return nil, nil
+		})
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 3, attempts)
+
+	dead, err := sp.DeadLetters()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, dead)
+	assert.Equal(t, int64(0), sp.Size())
+
+	// A replay which saves nothing stalls without using up any attempts
+	assert.NoError(t, sp.Spool(spoolEvents(1)))
+	for i := 0; i < 5; i++ {
+		_, err := sp.Replay(func(batch []Event) ([]int, []int) { return []int{0}, nil })
+		assert.ErrorIs(t, err, spool.ErrReplayStall)
+	}
+	dead, err = sp.DeadLetters()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, dead)
+}
+
+func TestSpool_LockedWhileOpen(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := spool.Open(spool.Config{Dir: dir})
+	assert.NoError(t, err)
+	assert.NoError(t, sp.Spool(spoolEvents(1)))
+
+	_, err = spool.Open(spool.Config{Dir: dir})
+	assert.ErrorIs(t, err, spool.ErrSpoolLocked)
+
+	// The spool can still be inspected, but not replayed
+	readOnly, err := spool.Open(spool.Config{Dir: dir, ReadOnly: true})
+	assert.NoError(t, err)
+	segments, err := readOnly.Inspect()
+	assert.NoError(t, err)
+	assert.Len(t, segments, 1)
+	_, err = readOnly.Replay(func(batch []Event) ([]int, []int) { return nil, nil })
+	assert.ErrorIs(t, err, spool.ErrReadOnly)
+	assert.NoError(t, readOnly.Close())
+
+	assert.NoError(t, sp.Close())
+	sp, err = spool.Open(spool.Config{Dir: dir})
+	assert.NoError(t, err)
+	assert.NoError(t, sp.Close())
+}
+
+func TestSpool_BoundedSize(t *testing.T) {
+	sp, err := spool.Open(spool.Config{Dir: t.TempDir(), MaxBytes: 300})
+	assert.NoError(t, err)
+
+	assert.NoError(t, sp.Spool(spoolEvents(1)))
+	assert.ErrorIs(t, sp.Spool(spoolEvents(5)), spool.ErrSpoolFull)
+	assert.LessOrEqual(t, sp.Size(), int64(300))
+}
+
+func TestSpool_WriterSpoolsFailedBatches(t *testing.T) {
+	sp, err := spool.Open(spool.Config{Dir: t.TempDir()})
+	assert.NoError(t, err)
+
+	mockRepo := &MockRepository{}
+	mockRepo.On("GetDomain", "localhost").Return(1, nil)
+	mockRepo.On("GetOrCreatePage", 1, mock.Anything).Return(2, nil)
+	mockRepo.On("SavePageViews", mock.Anything).Return(syscall.ECONNREFUSED)
+
+	writer := NewEventWriter(mockRepo, WriterConfig{Spooler: sp})
+	writer.Start()
+	for _, event := range spoolEvents(3) {
+		assert.NoError(t, writer.Enqueue(event))
+	}
+	assert.NoError(t, writer.Close(context.Background()))
+
+	assert.Equal(t, int64(3), writer.Stats().Spooled)
+	assert.Equal(t, int64(0), writer.Stats().Failed)
+
+	// Once the database is back, the spooled events are replayed into it
+	repo := NewMemoryRepository()
+	_, err = repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+
+	n, err := sp.Replay(func(events []Event) ([]int, []int) {
+		_, _, retry, rejected := WriteEvents(context.Background(), repo, events)
+		return retry, rejected
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Len(t, repo.PageViews(), 3)
+}
+
+func TestSpool_WriterSpoolsAnonymisedIPAddresses(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := spool.Open(spool.Config{Dir: dir})
+	assert.NoError(t, err)
+
+	mockRepo := &MockRepository{}
+	mockRepo.On("GetDomain", "localhost").Return(1, nil)
+	mockRepo.On("GetIPPolicy", 1).Return(IPPolicy{Mode: IPModeTruncated}, nil)
+	mockRepo.On("SaveIPAddress", "203.0.113.0").Return(4, nil)
+	mockRepo.On("GetOrCreatePage", 1, mock.Anything).Return(2, nil)
+	mockRepo.On("SavePageViews", mock.Anything).Return(syscall.ECONNREFUSED)
+
+	writer := NewEventWriter(mockRepo, WriterConfig{Spooler: sp})
+	writer.Start()
+	event := spoolEvents(1)[0]
+	event.ClientIP = "203.0.113.7"
+	assert.NoError(t, writer.Enqueue(event))
+	assert.NoError(t, writer.Close(context.Background()))
+	assert.Equal(t, int64(1), writer.Stats().Spooled)
+
+	// The raw address never reaches the disk
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	for _, file := range files {
+		contents, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		assert.NoError(t, err)
+		assert.NotContains(t, string(contents), "203.0.113.7", file.Name())
+	}
+
+	// Replay stores the address in the form it was spooled in
+	repo := NewMemoryRepository()
+	_, err = repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+
+	n, err := sp.Replay(func(events []Event) ([]int, []int) {
+		_, _, retry, rejected := WriteEvents(context.Background(), repo, events)
+		return retry, rejected
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Len(t, repo.PageViews(), 1)
+	assert.Equal(t, "203.0.113.0", repo.IPAddress(repo.PageViews()[0].IPAddressID))
+}
+
+func TestSpool_HandlerSpoolsFailedSave(t *testing.T) {
+	sp, err := spool.Open(spool.Config{Dir: t.TempDir()})
+	assert.NoError(t, err)
+
+	mockRepo := &MockRepository{}
+	mockRepo.On("GetDomain", mock.Anything).Return(2, nil)
+	mockRepo.On("GetOrCreatePage", mock.Anything, mock.Anything).Return(3, nil)
+	mockRepo.On("SavePageView", 2, 3).Return(0, syscall.ECONNREFUSED)
+
+	handlers := NewHandlers(mockRepo)
+	handlers.SetSpooler(sp)
+
+	req, err := http.NewRequest("POST", "/api/v1/track/pageview", strings.NewReader(`{"url":"http://localhost:3000/about"}`))
+	assert.NoError(t, err)
+	req.Header.Set("Origin", "http://localhost:3000")
+
+	recorder := httptest.NewRecorder()
+	handlers.TrackPageViewHandler(recorder, req)
+
+	assert.Equal(t, http.StatusAccepted, recorder.Code)
+
+	segments, err := sp.Inspect()
+	assert.NoError(t, err)
+	assert.Len(t, segments, 1)
+	assert.Equal(t, 1, segments[0].Events)
+}
@@ -0,0 +1,205 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/jwtly10/simple-site-tracker/api/track"
+	"github.com/jwtly10/simple-site-tracker/config"
+	"github.com/jwtly10/simple-site-tracker/migrations"
+	"github.com/stretchr/testify/assert"
+)
+
+func newSQLiteRepository(t *testing.T) (*SQLiteRepository, *sql.DB) {
+	db, err := config.OpenDB(&config.Config{
+		DBDriver: config.DriverSQLite,
+		DBPath:   filepath.Join(t.TempDir(), "tracker.db"),
+	})
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := migrations.NewMigrator(db, config.DriverSQLite)
+	assert.NoError(t, err)
+	_, err = migrator.Up()
+	assert.NoError(t, err)
+
+	return NewSQLiteRepository(db), db
+}
+
+// postFunc posts a tracking request from http://localhost:3000 to a handler, and returns the response's status code.
+type postFunc func(handler http.HandlerFunc, path, body string, options ...func(*http.Request)) int
+
+// forEachRepository runs a test against a SQLite and a memory repository, each with localhost saved as a domain.
+// db is the SQLite repository's database, or nil for the memory repository.
+func forEachRepository(t *testing.T, test func(name string, repo RepositoryInterface, db *sql.DB, domainId int64, post postFunc)) {
+	sqliteRepo, db := newSQLiteRepository(t)
+	for name, repo := range map[string]RepositoryInterface{"sqlite": sqliteRepo, "memory": NewMemoryRepository()} {
+		domainId, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+		assert.NoError(t, err, name)
+
+		var repoDB *sql.DB
+		if name == "sqlite" {
+			repoDB = db
+		}
+		test(name, repo, repoDB, domainId, postRequest)
+	}
+}
+
+func postRequest(handler http.HandlerFunc, path, body string, options ...func(*http.Request)) int {
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	req.Header.Set("Origin", "http://localhost:3000")
+	for _, option := range options {
+		option(req)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, req)
+	return recorder.Code
+}
+
+// withOrigin, withUserAgent and withRemoteAddr set the client details of a posted request.
+func withOrigin(origin string) func(*http.Request) {
+	return func(req *http.Request) { req.Header.Set("Origin", origin) }
+}
+
+func withUserAgent(userAgent string) func(*http.Request) {
+	return func(req *http.Request) { req.Header.Set("User-Agent", userAgent) }
+}
+
+func withRemoteAddr(addr string) func(*http.Request) {
+	return func(req *http.Request) { req.RemoteAddr = addr }
+}
+
+func TestSQLiteRepository_DomainsAndPages(t *testing.T) {
+	repo, _ := newSQLiteRepository(t)
+
+	domainId, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+
+	id, err := repo.GetDomain(context.Background(), "localhost")
+	assert.NoError(t, err)
+	assert.Equal(t, int(domainId), id)
+
+	id, err = repo.GetDomainIDFromKey(context.Background(), "key123")
+	assert.NoError(t, err)
+	assert.Equal(t, int(domainId), id)
+
+	id, err = repo.GetDomainIDFromKey(context.Background(), "unknown")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, id)
+
+	keyPair, err := repo.GetDomainKeyPair(context.Background(), "localhost")
+	assert.NoError(t, err)
+	assert.Equal(t, DomainKeyPair{Domain: "localhost", SiteKey: "key123"}, keyPair)
+
+	pageId, err := repo.GetPage(context.Background(), id, "/about")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, pageId)
+
+	newPageId, err := repo.CreatePage(context.Background(), int(domainId), "/about")
+	assert.NoError(t, err)
+
+	pageId, err = repo.GetPage(context.Background(), int(domainId), "/about")
+	assert.NoError(t, err)
+	assert.Equal(t, int(newPageId), pageId)
+}
+
+func TestSQLiteRepository_SaveEvents(t *testing.T) {
+	repo, db := newSQLiteRepository(t)
+
+	domainId, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+	pageId, err := repo.CreatePage(context.Background(), int(domainId), "/generate")
+	assert.NoError(t, err)
+
+	_, err = repo.SavePageView(context.Background(), int(domainId), int(pageId), "", Visitor{}, Referrer{})
+	assert.NoError(t, err)
+
+	_, err = repo.SaveUTM(context.Background(), int(pageId), "test_source", "test_medium", "test_campaign", "test_track", Visitor{})
+	assert.NoError(t, err)
+
+	element := map[string]interface{}{"tag": "a", "href": "https://example.com", "textContent": "Example"}
+	_, err = repo.SaveClick(context.Background(), int(pageId), element, Visitor{}, ClickTarget{})
+	assert.NoError(t, err)
+
+	var tag, href string
+	err = db.QueryRow("SELECT tag, href FROM click_tracking_view WHERE domain = ?", "localhost").Scan(&tag, &href)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", tag)
+	assert.Equal(t, "https://example.com", href)
+
+	var views int
+	err = db.QueryRow("SELECT COUNT(*) FROM page_views_view WHERE page_url = ?", "/generate").Scan(&views)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, views)
+}
+
+func TestSQLiteRepository_BulkInserts(t *testing.T) {
+	repo, db := newSQLiteRepository(t)
+
+	domainId, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+	pageId, err := repo.CreatePage(context.Background(), int(domainId), "/")
+	assert.NoError(t, err)
+
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.NoError(t, repo.SavePageViews(context.Background(), []PageView{
+		{DomainID: int(domainId), PageID: int(pageId), CreatedAt: createdAt},
+		{DomainID: int(domainId), PageID: int(pageId), CreatedAt: createdAt},
+	}))
+	assert.NoError(t, repo.SaveClicks(context.Background(), []Click{
+		{PageID: int(pageId), Element: map[string]interface{}{"tag": "a"}, CreatedAt: createdAt},
+	}))
+	assert.NoError(t, repo.SaveUTMs(context.Background(), []UTM{
+		{DomainID: int(domainId), PageID: int(pageId), UTMSource: "a", CreatedAt: createdAt},
+		{DomainID: int(domainId), PageID: int(pageId), UTMSource: "b", CreatedAt: createdAt},
+	}))
+
+	var views int
+	var timestamp string
+	err = db.QueryRow("SELECT COUNT(*), MAX(created_at) FROM page_views_tb").Scan(&views, &timestamp)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, views)
+	assert.Equal(t, "2024-01-02 03:04:05", timestamp)
+
+	var tag string
+	err = db.QueryRow("SELECT tag FROM click_tracking_view").Scan(&tag)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", tag)
+
+	var utms int
+	err = db.QueryRow("SELECT COUNT(*) FROM utm_tb").Scan(&utms)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, utms)
+}
+
+func TestSQLiteRepository_BulkInsertsOverPlaceholderLimit(t *testing.T) {
+	repo, db := newSQLiteRepository(t)
+
+	domainId, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+	pageId, err := repo.CreatePage(context.Background(), int(domainId), "/")
+	assert.NoError(t, err)
+
+	// Too many page views for SQLite's placeholder limit, so they're inserted in several statements
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	pageViews := make([]PageView, 5000)
+	for i := range pageViews {
+		pageViews[i] = PageView{DomainID: int(domainId), PageID: int(pageId), CreatedAt: createdAt}
+	}
+	assert.NoError(t, repo.SavePageViews(context.Background(), pageViews))
+
+	var views, rollup int
+	err = db.QueryRow("SELECT COUNT(*) FROM page_views_tb").Scan(&views)
+	assert.NoError(t, err)
+	assert.Equal(t, 5000, views)
+	err = db.QueryRow("SELECT views FROM page_views_daily_tb").Scan(&rollup)
+	assert.NoError(t, err)
+	assert.Equal(t, 5000, rollup)
+}
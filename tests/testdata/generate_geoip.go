@@ -0,0 +1,206 @@
+//go:build ignore
+
+// generate_geoip writes geoip.mmdb, a tiny MaxMind-format city database for the geolocation tests.
+// Run it from this directory with: go run generate_geoip.go
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"time"
+)
+
+type network struct {
+	cidr string
+	data map[string]interface{}
+}
+
+func names(en string) map[string]interface{} {
+	return map[string]interface{}{"en": en}
+}
+
+func city(country, region, regionName, cityName string) map[string]interface{} {
+	record := map[string]interface{}{
+		"country": map[string]interface{}{"iso_code": country},
+	}
+	if region != "" {
+		record["subdivisions"] = []interface{}{map[string]interface{}{"iso_code": region, "names": names(regionName)}}
+	}
+	if cityName != "" {
+		record["city"] = map[string]interface{}{"names": names(cityName)}
+	}
+	return record
+}
+
+// The networks are documentation ranges, so they never match real clients
+var networks = []network{
+	{"203.0.113.0/24", city("GB", "ENG", "England", "London")},
+	{"198.51.100.0/24", city("US", "CA", "California", "San Francisco")},
+	{"192.0.2.0/24", city("FR", "", "", "")},
+	{"2001:db8::/32", city("DE", "BE", "Berlin", "Berlin")},
+}
+
+// node is a node of the search tree. A record is either another node, or the data of a network.
+type node struct {
+	children [2]*node
+	data     [2]int
+}
+
+func main() {
+	var section bytes.Buffer
+	root := &node{data: [2]int{-1, -1}}
+	for _, n := range networks {
+		_, ipNet, err := net.ParseCIDR(n.cidr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ip := ipNet.IP.To16()
+		ones, bits := ipNet.Mask.Size()
+		if bits == 32 {
+			// IPv4 networks live under ::/96, where the reader looks them up
+			ip = append(make(net.IP, 12), ipNet.IP.To4()...)
+			ones += 96
+		}
+
+		offset := section.Len()
+		encode(&section, n.data)
+
+		current := root
+		for i := 0; i < ones-1; i++ {
+			bit := ip[i/8] >> (7 - i%8) & 1
+			if current.children[bit] == nil {
+				current.children[bit] = &node{data: [2]int{-1, -1}}
+			}
+			current = current.children[bit]
+		}
+		current.data[ip[(ones-1)/8]>>(7-(ones-1)%8)&1] = offset
+	}
+
+	// Number the nodes breadth first, so the root is node 0
+	var nodes []*node
+	index := make(map[*node]int)
+	for queue := []*node{root}; len(queue) > 0; queue = queue[1:] {
+		index[queue[0]] = len(nodes)
+		nodes = append(nodes, queue[0])
+		for _, child := range queue[0].children {
+			if child != nil {
+				queue = append(queue, child)
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	for _, n := range nodes {
+		for i := range n.children {
+			record := len(nodes) // empty
+			if n.children[i] != nil {
+				record = index[n.children[i]]
+			} else if n.data[i] >= 0 {
+				record = len(nodes) + 16 + n.data[i]
+			}
+			out.Write([]byte{byte(record >> 16), byte(record >> 8), byte(record)})
+		}
+	}
+	out.Write(make([]byte, 16))
+	out.Write(section.Bytes())
+
+	out.WriteString("\xab\xcd\xefMaxMind.com")
+	encode(&out, map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Unix()),
+		"database_type":               "GeoIP2-City",
+		"description":                 map[string]interface{}{"en": "Simple Site Tracker test database"},
+		"ip_version":                  uint16(6),
+		"languages":                   []interface{}{"en"},
+		"node_count":                  uint32(len(nodes)),
+		"record_size":                 uint16(24),
+	})
+
+	if err := os.WriteFile("geoip.mmdb", out.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Data section types, from https://maxmind.github.io/MaxMind-DB/
+const (
+	typeString = 2
+	typeUint16 = 5
+	typeUint32 = 6
+	typeMap    = 7
+	typeUint64 = 9
+	typeArray  = 11
+)
+
+func encode(buf *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		writeControl(buf, typeString, len(v))
+		buf.WriteString(v)
+	case uint16:
+		writeUint(buf, typeUint16, uint64(v))
+	case uint32:
+		writeUint(buf, typeUint32, uint64(v))
+	case uint64:
+		writeUint(buf, typeUint64, v)
+	case []interface{}:
+		writeControl(buf, typeArray, len(v))
+		for _, item := range v {
+			encode(buf, item)
+		}
+	case map[string]interface{}:
+		writeControl(buf, typeMap, len(v))
+		for _, key := range sortedKeys(v) {
+			encode(buf, key)
+			encode(buf, v[key])
+		}
+	default:
+		log.Fatalf("unsupported value %#v", value)
+	}
+}
+
+// writeUint writes an unsigned integer in as few bytes as it fits.
+func writeUint(buf *bytes.Buffer, kind int, v uint64) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	b = bytes.TrimLeft(b, "\x00")
+	writeControl(buf, kind, len(b))
+	buf.Write(b)
+}
+
+func writeControl(buf *bytes.Buffer, kind, size int) {
+	control := byte(kind << 5)
+	if kind > 7 {
+		control = 0
+	}
+
+	var extra []byte
+	switch {
+	case size < 29:
+		control |= byte(size)
+	case size < 285:
+		control |= 29
+		extra = []byte{byte(size - 29)}
+	default:
+		log.Fatalf("unsupported size %d", size)
+	}
+
+	buf.WriteByte(control)
+	if kind > 7 {
+		buf.WriteByte(byte(kind - 7))
+	}
+	buf.Write(extra)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/jwtly10/simple-site-tracker/api/track"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	iPhoneSafariUA  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1"
+	androidTabletUA = "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.144 Safari/537.36"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	for header, expected := range map[string]UserAgent{
+		firefoxUA:       {Browser: "Firefox", BrowserVersion: "121", OS: "Linux", Device: DeviceDesktop},
+		chromeUA:        {Browser: "Chrome", BrowserVersion: "120", OS: "Windows", Device: DeviceDesktop},
+		iPhoneSafariUA:  {Browser: "Safari", BrowserVersion: "17", OS: "iOS", Device: DeviceMobile},
+		androidTabletUA: {Browser: "Chrome", BrowserVersion: "120", OS: "Android", Device: DeviceTablet},
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91": {
+			Browser: "Edge", BrowserVersion: "120", OS: "Windows", Device: DeviceDesktop,
+		},
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15": {
+			Browser: "Safari", BrowserVersion: "17", OS: "macOS", Device: DeviceDesktop,
+		},
+		"Mozilla/5.0 (iPad; CPU OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1": {
+			Browser: "Safari", BrowserVersion: "17", OS: "iOS", Device: DeviceTablet,
+		},
+		"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.144 Mobile Safari/537.36": {
+			Browser: "Chrome", BrowserVersion: "120", OS: "Android", Device: DeviceMobile,
+		},
+		"Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/23.0 Chrome/115.0.0.0 Mobile Safari/537.36": {
+			Browser: "Samsung Internet", BrowserVersion: "23", OS: "Android", Device: DeviceMobile,
+		},
+		"Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36": {
+			Browser: "Chrome", BrowserVersion: "120", OS: "Chrome OS", Device: DeviceDesktop,
+		},
+		"": {},
+	} {
+		assert.Equal(t, expected, ParseUserAgent(header), header)
+	}
+}
+
+func TestDimensionCounts(t *testing.T) {
+	forEachRepository(t, func(name string, repo RepositoryInterface, db *sql.DB, domainId int64, post postFunc) {
+		handlers := NewHandlers(repo)
+		for _, userAgent := range []string{firefoxUA, chromeUA, chromeUA, iPhoneSafariUA, androidTabletUA, ""} {
+			assert.Equal(t, http.StatusOK, post(handlers.TrackPageViewHandler, "/api/v1/track/pageview", `{"url":"http://localhost:3000/"}`, withUserAgent(userAgent)), name)
+		}
+
+		now := time.Now()
+		dimension := func(dimension string) []DimensionCount {
+			counts, err := repo.GetDimensionCounts(context.Background(), int(domainId), dimension, now.Add(-time.Hour), now.Add(time.Hour))
+			assert.NoError(t, err, name)
+			return counts
+		}
+
+		assert.Equal(t, []DimensionCount{{Value: "Chrome", Count: 3}, {Value: "", Count: 1}, {Value: "Firefox", Count: 1}, {Value: "Safari", Count: 1}}, dimension(DimensionBrowser), name)
+		assert.Equal(t, []DimensionCount{{Value: "Chrome 120", Count: 3}, {Value: "", Count: 1}, {Value: "Firefox 121", Count: 1}, {Value: "Safari 17", Count: 1}}, dimension(DimensionBrowserVersion), name)
+		assert.Equal(t, []DimensionCount{{Value: "Windows", Count: 2}, {Value: "", Count: 1}, {Value: "Android", Count: 1}, {Value: "Linux", Count: 1}, {Value: "iOS", Count: 1}}, dimension(DimensionOS), name)
+		assert.Equal(t, []DimensionCount{{Value: DeviceDesktop, Count: 3}, {Value: "", Count: 1}, {Value: DeviceMobile, Count: 1}, {Value: DeviceTablet, Count: 1}}, dimension(DimensionDevice), name)
+
+		_, err := repo.GetDimensionCounts(context.Background(), int(domainId), "user_agent", now.Add(-time.Hour), now.Add(time.Hour))
+		assert.Error(t, err, name)
+	})
+}
+
+func TestHandlers_UserAgentOnEvents(t *testing.T) {
+	repo := NewMemoryRepository()
+	_, err := repo.SaveDomain(context.Background(), "localhost", "key123")
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/api/v1/track/batch", strings.NewReader(mixedBatch))
+	req.Header.Set("Origin", "http://localhost:3000")
+	req.Header.Set("User-Agent", iPhoneSafariUA)
+	NewHandlers(repo).TrackBatchHandler(httptest.NewRecorder(), req)
+
+	expected := ParseUserAgent(iPhoneSafariUA)
+	assert.Equal(t, expected, repo.PageViews()[0].UserAgent)
+	assert.Equal(t, expected, repo.Clicks()[0].UserAgent)
+	assert.Equal(t, expected, repo.UTMs()[0].UserAgent)
+	assert.Equal(t, expected, repo.CustomEvents()[0].UserAgent)
+}
@@ -0,0 +1,113 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/jwtly10/simple-site-tracker/api/track"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const (
+	firefoxUA = "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0"
+	chromeUA  = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+)
+
+func TestVisitors_Identify(t *testing.T) {
+	visitors := NewVisitors(NewMemoryRepository(), VisitorConfig{SessionTimeout: 30 * time.Minute})
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	first := visitors.Identify(context.Background(), "localhost", "203.0.113.7", firefoxUA, at)
+	assert.Len(t, first.VisitorID, 32)
+	assert.NotEmpty(t, first.SessionID)
+
+	// The same client on the same site is the same visitor, in the same session
+	again := visitors.Identify(context.Background(), "localhost", "203.0.113.7", firefoxUA, at.Add(10*time.Minute))
+	assert.Equal(t, first, again)
+
+	// Another browser, address or site is another visitor
+	for _, other := range []Visitor{
+		visitors.Identify(context.Background(), "localhost", "203.0.113.7", chromeUA, at),
+		visitors.Identify(context.Background(), "localhost", "203.0.113.8", firefoxUA, at),
+		visitors.Identify(context.Background(), "example.com", "203.0.113.7", firefoxUA, at),
+	} {
+		assert.NotEqual(t, first.VisitorID, other.VisitorID)
+		assert.NotEqual(t, first.SessionID, other.SessionID)
+	}
+
+	// Inactivity past the timeout starts a new session for the same visitor
+	later := visitors.Identify(context.Background(), "localhost", "203.0.113.7", firefoxUA, at.Add(41*time.Minute))
+	assert.Equal(t, first.VisitorID, later.VisitorID)
+	assert.NotEqual(t, first.SessionID, later.SessionID)
+
+	// Visitor IDs rotate with the daily salt
+	nextDay := visitors.Identify(context.Background(), "localhost", "203.0.113.7", firefoxUA, at.AddDate(0, 0, 1))
+	assert.NotEqual(t, first.VisitorID, nextDay.VisitorID)
+}
+
+func TestVisitors_SharedSalt(t *testing.T) {
+	repo, db := newSQLiteRepository(t)
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// Servers sharing a database agree on visitor IDs
+	first := NewVisitors(repo, VisitorConfig{}).Identify(context.Background(), "localhost", "203.0.113.7", firefoxUA, at)
+	second := NewVisitors(repo, VisitorConfig{}).Identify(context.Background(), "localhost", "203.0.113.7", firefoxUA, at)
+	assert.Equal(t, first.VisitorID, second.VisitorID)
+
+	// Only the current day's salt is kept
+	NewVisitors(repo, VisitorConfig{}).Identify(context.Background(), "localhost", "203.0.113.7", firefoxUA, at.AddDate(0, 0, 1))
+	var days []string
+	rows, err := db.Query("SELECT day FROM visitor_salts_tb")
+	assert.NoError(t, err)
+	for rows.Next() {
+		var day string
+		assert.NoError(t, rows.Scan(&day))
+		days = append(days, day)
+	}
+	assert.NoError(t, rows.Close())
+	assert.Equal(t, []string{"2024-01-03"}, days)
+}
+
+func TestVisitors_SaltUnavailable(t *testing.T) {
+	mockRepo := &MockRepository{}
+	mockRepo.On("GetOrCreateVisitorSalt", "2024-01-02", mock.Anything).Return("", errors.New("connection refused"))
+
+	visitors := NewVisitors(mockRepo, VisitorConfig{})
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// A local salt is used, and the database isn't retried on every event
+	first := visitors.Identify(context.Background(), "localhost", "203.0.113.7", firefoxUA, at)
+	second := visitors.Identify(context.Background(), "localhost", "203.0.113.7", firefoxUA, at.Add(time.Second))
+	assert.NotEmpty(t, first.VisitorID)
+	assert.Equal(t, first, second)
+	mockRepo.AssertNumberOfCalls(t, "GetOrCreateVisitorSalt", 1)
+}
+
+func TestVisitorCounts(t *testing.T) {
+	forEachRepository(t, func(name string, repo RepositoryInterface, db *sql.DB, domainId int64, post postFunc) {
+		handlers := NewHandlers(repo)
+		handlers.SetVisitors(NewVisitors(repo, VisitorConfig{}))
+
+		for _, hit := range []struct {
+			addr, userAgent, url string
+		}{
+			{"203.0.113.7:5000", firefoxUA, "http://localhost:3000/"},
+			{"203.0.113.7:5001", firefoxUA, "http://localhost:3000/about"},
+			{"203.0.113.7:5002", chromeUA, "http://localhost:3000/"},
+			{"198.51.100.1:5000", firefoxUA, "http://localhost:3000/"},
+		} {
+			code := post(handlers.TrackPageViewHandler, "/api/v1/track/pageview", `{"url":"`+hit.url+`"}`, withUserAgent(hit.userAgent), withRemoteAddr(hit.addr))
+			assert.Equal(t, http.StatusOK, code, name)
+		}
+
+		now := time.Now()
+		counts, err := repo.GetVisitorCounts(context.Background(), int(domainId), now.Add(-time.Hour), now.Add(time.Hour))
+		assert.NoError(t, err, name)
+		assert.Equal(t, VisitorCounts{PageViews: 4, Visitors: 3, Sessions: 3}, counts, name)
+	})
+}